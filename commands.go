@@ -0,0 +1,265 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/pkg/aggregator"
+	"scanner.magictradebot.com/pkg/db"
+	"scanner.magictradebot.com/pkg/export"
+	"scanner.magictradebot.com/pkg/parquetstore"
+	"scanner.magictradebot.com/pkg/reconcile"
+	"scanner.magictradebot.com/pkg/retention"
+	"scanner.magictradebot.com/pkg/storage"
+)
+
+// runReconcile compares stored aggregated candles with the exchange's official ones, e.g.
+// `reconcile -interval 5m -hours 12 -sample 20 -out report.csv`.
+func runReconcile(args []string, repo storage.KlineRepository, log *logrus.Logger) {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	symbolList := fs.String("symbols", "", "comma-separated symbols (default: configured symbols)")
+	sampleSize := fs.Int("sample", 10, "symbols picked at random, 0 for all")
+	interval := fs.String("interval", aggregator.BaseInterval, "interval to compare")
+	fromFlag := fs.String("from", "", "range start, RFC3339 or date (default: -hours before -to)")
+	toFlag := fs.String("to", "", "range end, RFC3339 or date (default: now)")
+	hours := fs.Int("hours", 24, "range length when -from is not set")
+	tolerance := fs.Float64("tolerance", 0.1, "bps a high or low may differ before it counts as missed")
+	out := fs.String("out", "", "report file, .csv or .json")
+	fs.Parse(args)
+
+	to, err := parseTimeFlag(*toFlag, time.Now().UTC())
+	if err != nil {
+		log.Fatalf("❌ Invalid -to: %v", err)
+	}
+	from, err := parseTimeFlag(*fromFlag, to.Add(-time.Duration(*hours)*time.Hour))
+	if err != nil {
+		log.Fatalf("❌ Invalid -from: %v", err)
+	}
+
+	symbols := config.Settings.Symbols
+	if *symbolList != "" {
+		symbols = splitList(*symbolList)
+	}
+
+	log.WithFields(logrus.Fields{
+		"interval": *interval,
+		"from":     from.Format(time.RFC3339),
+		"to":       to.Format(time.RFC3339),
+	}).Info("🔎 Reconciling collected klines with the exchange")
+
+	stats, err := reconcile.Run(reconcile.Options{
+		Exchange:     config.Settings.Exchange,
+		Symbols:      symbols,
+		Sample:       *sampleSize,
+		Interval:     *interval,
+		From:         from.UnixMilli(),
+		To:           to.UnixMilli(),
+		ToleranceBps: *tolerance,
+	}, repo, log)
+	if err != nil {
+		log.Fatalf("❌ Reconciliation failed: %v", err)
+	}
+
+	if err := reconcile.PrintTable(os.Stdout, stats); err != nil {
+		log.Errorf("❌ Failed to print reconciliation table: %v", err)
+	}
+	if *out != "" {
+		if err := reconcile.WriteReport(*out, stats); err != nil {
+			log.Fatalf("❌ Failed to write reconciliation report: %v", err)
+		}
+		log.Infof("📄 Reconciliation report written to %s", *out)
+	}
+}
+
+// runCompact merges small Parquet files written by the sink.
+func runCompact(log *logrus.Logger) {
+	stats, err := parquetstore.Compact(config.Settings.Parquet, log)
+	if err != nil {
+		log.Fatalf("❌ Parquet compaction failed: %v", err)
+	}
+	log.WithFields(logrus.Fields{
+		"partitions":   stats.Partitions,
+		"files_merged": stats.FilesMerged,
+		"rows_read":    stats.RowsRead,
+		"rows_written": stats.RowsWritten,
+	}).Info("🗜️ Parquet compaction complete")
+}
+
+// runMigrate shows or changes the schema version, e.g. `migrate status`, `migrate up -to 4`
+// or `migrate down -steps 1`.
+func runMigrate(args []string, migrator *db.Migrator, log *logrus.Logger) {
+	action := "status"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet("migrate "+action, flag.ExitOnError)
+	target := fs.Int("to", 0, "up: last version to apply (default: latest)")
+	steps := fs.Int("steps", 1, "down: number of migrations to revert")
+	fs.Parse(args)
+
+	switch action {
+	case "status":
+		status, err := migrator.Status()
+		if err != nil {
+			log.Fatalf("❌ Failed to read migration status: %v", err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		tw.Flush()
+	case "up":
+		count, err := migrator.Up(*target)
+		if err != nil {
+			log.Fatalf("❌ Migration failed after %d applied: %v", count, err)
+		}
+		log.WithField("applied", count).Info("✅ Migrations applied")
+	case "down":
+		count, err := migrator.Down(*steps)
+		if err != nil {
+			log.Fatalf("❌ Revert failed after %d reverted: %v", count, err)
+		}
+		log.WithField("reverted", count).Info("✅ Migrations reverted")
+	default:
+		log.Fatalf("❌ Unknown migrate action %q, expected status, up or down", action)
+	}
+}
+
+// runExport streams klines from the database to a file or stdout, e.g.
+// `export -symbols 'BTC,ETH*' -interval 1h -from 2024-01-01 -format parquet -out klines.parquet`.
+func runExport(args []string, repo storage.KlineRepository, log *logrus.Logger) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	exchange := fs.String("exchange", config.Settings.Exchange, "exchange to export, empty for all")
+	symbolList := fs.String("symbols", "", "comma-separated symbols or globs such as ETH* (default: all); matched against stored symbols, "+
+		"which drop the quote asset and contract suffix (BTC-USDT-SWAP and BTCUSDT are stored as BTC)")
+	interval := fs.String("interval", aggregator.BaseInterval, "interval to export")
+	source := fs.String("source", "", "aggregated or exchange (default: both)")
+	fromFlag := fs.String("from", "", "range start, RFC3339 or date (default: beginning)")
+	toFlag := fs.String("to", "", "range end, RFC3339 or date (default: no limit)")
+	format := fs.String("format", "", "csv, jsonl or parquet (default: from -out extension, else csv)")
+	compression := fs.String("compression", "none", "none, gzip or zstd")
+	columnList := fs.String("columns", "", "comma-separated columns (default: all): "+strings.Join(export.ColumnNames(), ","))
+	out := fs.String("out", "-", "output file, - for stdout")
+	fs.Parse(args)
+
+	from, err := parseTimeFlag(*fromFlag, time.UnixMilli(0))
+	if err != nil {
+		log.Fatalf("❌ Invalid -from: %v", err)
+	}
+	var to int64
+	if *toFlag != "" {
+		t, err := parseTimeFlag(*toFlag, time.Time{})
+		if err != nil {
+			log.Fatalf("❌ Invalid -to: %v", err)
+		}
+		to = t.UnixMilli()
+	}
+
+	opts := export.Options{
+		Filter: storage.KlineFilter{
+			Exchange: *exchange,
+			Symbols:  splitList(*symbolList),
+			Interval: *interval,
+			Source:   *source,
+			From:     from.UnixMilli(),
+			To:       to,
+		},
+		Format:      *format,
+		Compression: *compression,
+		Columns:     splitList(*columnList),
+	}
+	if opts.Format == "" {
+		opts.Format = formatFromPath(*out)
+	}
+
+	w := os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("❌ Failed to create export file: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	count, err := export.Run(repo, opts, w)
+	if err != nil {
+		log.Fatalf("❌ Export failed after %d rows: %v", count, err)
+	}
+	if err := w.Sync(); err != nil && *out != "-" {
+		log.Fatalf("❌ Failed to write export file: %v", err)
+	}
+	log.WithFields(logrus.Fields{
+		"rows":   count,
+		"format": opts.Format,
+		"out":    *out,
+	}).Info("📤 Export complete")
+}
+
+// runRetention reports what the retention policies would roll up, archive and delete, e.g.
+// `retention` for a dry run or `retention -apply` to carry it out.
+func runRetention(args []string, store retention.Store, log *logrus.Logger) {
+	fs := flag.NewFlagSet("retention", flag.ExitOnError)
+	apply := fs.Bool("apply", false, "roll up, archive and delete instead of only reporting")
+	fs.Parse(args)
+
+	job, err := retention.New(config.Settings.Retention, config.Settings.Aggregator.Alignment, config.Settings.Exchange, store, log)
+	if err != nil {
+		log.Fatalf("❌ Invalid retention policies: %v", err)
+	}
+	results := job.Run(time.Now(), !*apply)
+	if err := retention.PrintReport(os.Stdout, results); err != nil {
+		log.Errorf("❌ Failed to print retention report: %v", err)
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			log.Fatalf("❌ Retention failed for %s: %s", r.Interval, r.Error)
+		}
+	}
+}
+
+// parseTimeFlag accepts RFC3339 timestamps or plain UTC dates, returning fallback when empty.
+func parseTimeFlag(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// splitList splits a comma-separated flag, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// formatFromPath guesses the export format from the file extension, ignoring a compression suffix.
+func formatFromPath(path string) string {
+	path = strings.TrimSuffix(strings.TrimSuffix(path, ".gz"), ".zst")
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".ndjson", ".json":
+		return export.FormatJSONL
+	case ".parquet":
+		return export.FormatParquet
+	}
+	return export.FormatCSV
+}
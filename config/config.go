@@ -22,13 +22,67 @@ func LoadConfig(path string) {
 }
 
 type AggregatorSettings struct {
-	EnableJitter     bool `yaml:"EnableJitter"`
-	JitterMaxMillis  int  `yaml:"JitterMaxMillis"`
-	EnableBatchStats bool `yaml:"EnableBatchStats"`
-	PersistRawTicks  struct {
-		Enabled bool   `yaml:"Enabled"`
-		Output  string `yaml:"Output"` // "redis", "kafka"
-	} `yaml:"PersistRawTicks"`
+	Intervals              []string                     `yaml:"Intervals"`              // e.g. ["1m", "5m", "1h"]; higher intervals roll up from 1m
+	AllowedLatenessSeconds int                          `yaml:"AllowedLatenessSeconds"` // late ticks within this window amend emitted candles
+	EmptyCandlePolicy      map[string]string            `yaml:"EmptyCandlePolicy"`      // per interval: "skip" (default), "flat" or "synthetic"
+	Alignment              map[string]AlignmentSettings `yaml:"Alignment"`              // per interval; UTC epoch boundaries when unset
+	PartialGapSeconds      int                          `yaml:"PartialGapSeconds"`      // candles with a longer sample gap are flagged partial (default 30)
+	EnableJitter           bool                         `yaml:"EnableJitter"`
+	JitterMaxMillis        int                          `yaml:"JitterMaxMillis"`
+	EnableBatchStats       bool                         `yaml:"EnableBatchStats"`
+	Journal                struct {
+		Enabled         bool   `yaml:"Enabled"`
+		Directory       string `yaml:"Directory"`       // holds the snapshot and the tick journal
+		SnapshotSeconds int    `yaml:"SnapshotSeconds"` // how often the journal is compacted into a snapshot
+	} `yaml:"Journal"`
+	PersistRawTicks RawTickSettings    `yaml:"PersistRawTicks"`
+	Bars            []BarSettings      `yaml:"Bars"` // information-driven bars built from the same ticks
+	TickFilter      TickFilterSettings `yaml:"TickFilter"`
+	SubMinute       SubMinuteSettings  `yaml:"SubMinute"`
+}
+
+// SubMinuteSettings bounds the second-level candles built when a streaming source is active.
+type SubMinuteSettings struct {
+	RingSize       int `yaml:"RingSize"`       // closed bars kept in memory per symbol and interval (default 900)
+	FlushSeconds   int `yaml:"FlushSeconds"`   // how often closed bars are written in one batch (default 10)
+	MaxPending     int `yaml:"MaxPending"`     // bars buffered for writing before the oldest are dropped (default 100000)
+	RetentionHours int `yaml:"RetentionHours"` // stored sub-minute bars older than this are purged (default 24)
+}
+
+// TickFilterSettings configures the checks ticks pass before reaching the aggregator.
+type TickFilterSettings struct {
+	Enabled               bool     `yaml:"Enabled"`
+	Rules                 []string `yaml:"Rules"`                 // "non_positive", "mad_jump", "stale", "duplicate"; all when empty
+	MadMultiplier         float64  `yaml:"MadMultiplier"`         // reject prices further than this many MADs from the median (default 10)
+	MadWindow             int      `yaml:"MadWindow"`             // accepted prices kept per symbol (default 50)
+	MadMinSamples         int      `yaml:"MadMinSamples"`         // prices needed before jumps are checked (default 10)
+	MinJumpPercent        float64  `yaml:"MinJumpPercent"`        // moves within this percent of the median always pass (default 2)
+	MaxConsecutiveRejects int      `yaml:"MaxConsecutiveRejects"` // jump rejections in a row before a new price level is accepted (default 5)
+	MaxAgeSeconds         int      `yaml:"MaxAgeSeconds"`         // ticks older than this are stale (default 120)
+	Quarantine            bool     `yaml:"Quarantine"`            // store rejected ticks in the quarantine table
+}
+
+type BarSettings struct {
+	Type      string             `yaml:"Type"`      // "volume", "dollar", "tick", "range" or "renko"
+	Threshold float64            `yaml:"Threshold"` // default threshold; also used while the adaptive estimate warms up
+	Symbols   map[string]float64 `yaml:"Symbols"`   // fixed per-symbol thresholds, these are never adapted
+	Adaptive  struct {
+		Enabled         bool `yaml:"Enabled"`
+		BarsPerDay      int  `yaml:"BarsPerDay"`      // target bar count given recent activity
+		HalfLifeMinutes int  `yaml:"HalfLifeMinutes"` // decay of the per-minute activity average (default 60)
+	} `yaml:"Adaptive"`
+}
+
+// AlignmentSettings moves an interval's candle boundaries onto a local calendar.
+type AlignmentSettings struct {
+	Timezone string `yaml:"Timezone"` // IANA name such as "Asia/Shanghai" or a fixed offset such as "UTC+08:00"
+	Offset   string `yaml:"Offset"`   // session open after local midnight, e.g. "17h" or "30m"
+}
+
+type RawTickSettings struct {
+	Enabled bool   `yaml:"Enabled"`
+	Output  string `yaml:"Output"` // "redis", "kafka"
+	Target  string `yaml:"Target"` // Redis stream or Kafka topic; connection details come from Streaming
 }
 
 type AppSettings struct {
@@ -39,12 +93,107 @@ type AppSettings struct {
 	BlacklistedSymbols []string           `yaml:"blacklisted_symbols"`
 	Aggregator         AggregatorSettings `yaml:"Aggregator"`
 	Streaming          StreamingConfig    `yaml:"Streaming"`
+	Scheduler          SchedulerSettings  `yaml:"Scheduler"`
+	Hybrid             HybridSettings     `yaml:"Hybrid"`
+	Parquet            ParquetSettings    `yaml:"Parquet"`
+	WriteQueue         WriteQueueSettings `yaml:"WriteQueue"`
+	Retention          RetentionSettings  `yaml:"Retention"`
 	Debug              bool               `yaml:"Debug"`
 
-	Database struct {
-		Provider         string `yaml:"provider"`
-		ConnectionString string `yaml:"connectionString"`
-	} `yaml:"database"`
+	Database DatabaseSettings `yaml:"database"`
+}
+
+type DatabaseSettings struct {
+	Provider         string           `yaml:"provider"`         // "sqlite", "postgresql" or "mysql" (also for MariaDB)
+	ConnectionString string           `yaml:"connectionString"` // SQLite file or MySQL DSN; PostgreSQL and an empty MySQL DSN use the DB_* variables
+	TablePrefix      *string          `yaml:"tablePrefix"`      // prepended to table names; DB_TABLE_PREFIX overrides, unset keeps "Dev_"
+	AutoMigrate      bool             `yaml:"autoMigrate"`      // apply pending migrations at startup instead of refusing to start
+	PriceScale       int              `yaml:"priceScale"`       // decimals kept for prices (0 derives them from the instruments' tick sizes)
+	VolumeScale      int              `yaml:"volumeScale"`      // decimals kept for volumes (0 derives them from tick and lot sizes)
+	Postgres         PostgresSettings `yaml:"postgres"`
+}
+
+// PostgresSettings tunes bulk loading and partitioning on PostgreSQL 11 or later.
+type PostgresSettings struct {
+	CopyThreshold int `yaml:"copyThreshold"` // batches with at least this many klines are COPYed into a staging table and merged (default 500, -1 disables)
+	Partitioning  struct {
+		Enabled       bool `yaml:"enabled"`       // partition the kline table by open_time month; the kline_partitions migration converts it
+		PremakeMonths int  `yaml:"premakeMonths"` // future months whose partitions are created ahead (default 3)
+		RetainMonths  int  `yaml:"retainMonths"`  // months kept attached before the current one; older partitions are detached (0 keeps all)
+	} `yaml:"partitioning"`
+}
+
+// Prefix returns the table prefix, preferring the DB_TABLE_PREFIX environment variable.
+func (d DatabaseSettings) Prefix() string {
+	if prefix, ok := os.LookupEnv("DB_TABLE_PREFIX"); ok {
+		return prefix
+	}
+	if d.TablePrefix == nil {
+		return "Dev_"
+	}
+	return *d.TablePrefix
+}
+
+// SchedulerSettings controls which symbols are requested each cycle.
+type SchedulerSettings struct {
+	Mode               string `yaml:"Mode"`               // "rotate" (default, fixed round-robin batches) or "adaptive"
+	BatchSize          int    `yaml:"BatchSize"`          // symbols per cycle; the most an adaptive batch may hold (default 50)
+	PerSymbol          bool   `yaml:"PerSymbol"`          // request each symbol's ticker instead of all tickers at once
+	MinIntervalSeconds int    `yaml:"MinIntervalSeconds"` // adaptive: how often the most active symbols are due (default RefreshSeconds)
+	MaxIntervalSeconds int    `yaml:"MaxIntervalSeconds"` // adaptive: every symbol is fetched at least this often (default 60)
+	ReservePercent     int    `yaml:"ReservePercent"`     // adaptive: share of the exchange rate limit left unused (default 20)
+}
+
+// HybridSettings controls fetching the exchange's own closed klines next to the aggregated ones.
+type HybridSettings struct {
+	Enabled      bool     `yaml:"Enabled"`
+	Intervals    []string `yaml:"Intervals"`    // intervals to fetch (default all aggregator intervals of 1m or more)
+	BatchSize    int      `yaml:"BatchSize"`    // symbols requested per cycle (default 20)
+	DelaySeconds int      `yaml:"DelaySeconds"` // wait after a candle closes before asking for it (default 5)
+	MaxAttempts  int      `yaml:"MaxAttempts"`  // failed requests before a range is dropped (default 5)
+	PollSeconds  int      `yaml:"PollSeconds"`  // how often due klines are fetched, apart from the ticker loop (default 10)
+}
+
+// ParquetSettings controls the Parquet file sink for finalized klines.
+type ParquetSettings struct {
+	Enabled        bool   `yaml:"Enabled"`
+	Directory      string `yaml:"Directory"`      // root of the exchange=/interval=/date= partitions (default "data/parquet")
+	Compression    string `yaml:"Compression"`    // "snappy" (default), "gzip", "zstd" or "none"
+	MaxFileMB      int    `yaml:"MaxFileMB"`      // roll to a new file past this size (default 128)
+	RollSeconds    int    `yaml:"RollSeconds"`    // commit open files at least this often (default 300)
+	CompactBelowMB int    `yaml:"CompactBelowMB"` // compaction merges files smaller than this (default 32)
+}
+
+// WriteQueueSettings controls the write-behind queue between the aggregator and the database.
+type WriteQueueSettings struct {
+	Enabled           bool   `yaml:"Enabled"`
+	Directory         string `yaml:"Directory"`         // spill files for klines the database could not take yet (default "data/writequeue")
+	BatchSize         int    `yaml:"BatchSize"`         // klines per database write (default 500)
+	MaxPending        int    `yaml:"MaxPending"`        // klines buffered in memory before new ones spill to disk (default 50000)
+	SegmentSize       int    `yaml:"SegmentSize"`       // klines per spill file (default 10000)
+	MinBackoffMillis  int    `yaml:"MinBackoffMillis"`  // delay before the first retry of a failed write (default 500)
+	MaxBackoffSeconds int    `yaml:"MaxBackoffSeconds"` // the retry delay doubles up to this (default 60)
+	ShutdownSeconds   int    `yaml:"ShutdownSeconds"`   // time to drain the memory buffer on shutdown before spilling the rest (default 10)
+}
+
+// RetentionSettings controls how long stored klines are kept per interval.
+type RetentionSettings struct {
+	Enabled      bool                       `yaml:"Enabled"`
+	DryRun       bool                       `yaml:"DryRun"`       // only log what would be rolled up, archived and deleted
+	CheckMinutes int                        `yaml:"CheckMinutes"` // how often the retention job runs (default 60)
+	Policies     map[string]RetentionPolicy `yaml:"Policies"`     // per interval; intervals without a policy are kept forever
+	Archive      struct {
+		Enabled     bool   `yaml:"Enabled"`
+		Directory   string `yaml:"Directory"`   // archive files go to <Directory>/<exchange>/<interval> (default "data/archive")
+		Format      string `yaml:"Format"`      // "csv", "jsonl" (default) or "parquet"
+		Compression string `yaml:"Compression"` // "gzip" (default), "zstd" or "none"
+	} `yaml:"Archive"`
+}
+
+// RetentionPolicy expires the klines of one interval.
+type RetentionPolicy struct {
+	KeepDays int    `yaml:"KeepDays"` // klines opening earlier are deleted (0 keeps them forever)
+	RollUpTo string `yaml:"RollUpTo"` // coarser interval the expiring klines are rolled up into first, e.g. "5m"
 }
 
 type StreamingConfig struct {
@@ -62,6 +211,17 @@ type StreamingConfig struct {
 		Brokers []string `yaml:"Brokers"`
 		Topic   string   `yaml:"Topic"`
 	} `yaml:"Kafka"`
+
+	Source StreamSourceSettings `yaml:"Source"`
+}
+
+// StreamSourceSettings reads ticks that an external feed, such as an exchange websocket
+// bridge, publishes to Redis or Kafka. Connection details come from Streaming.
+type StreamSourceSettings struct {
+	Enabled bool   `yaml:"Enabled"`
+	Input   string `yaml:"Input"`   // "redis", "kafka"
+	Target  string `yaml:"Target"`  // Redis stream or Kafka topic to read
+	GroupID string `yaml:"GroupID"` // Kafka consumer group; offsets are not committed when empty
 }
 
 var Settings AppSettings
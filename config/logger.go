@@ -9,6 +9,16 @@ import (
 
 type LoggerResult struct {
 	Logger *logrus.Logger
+	file   *os.File
+}
+
+// UseStderr moves console logging to stderr so commands can write their output to stdout.
+func (r *LoggerResult) UseStderr() {
+	if r.file != nil {
+		r.Logger.SetOutput(io.MultiWriter(os.Stderr, r.file))
+	} else {
+		r.Logger.SetOutput(os.Stderr)
+	}
 }
 
 func InitLogger(isDebug bool) (*LoggerResult, error) {
@@ -42,7 +52,11 @@ func InitLogger(isDebug bool) (*LoggerResult, error) {
 		logger.SetLevel(logrus.InfoLevel)
 	}
 
-	return &LoggerResult{
+	result := &LoggerResult{
 		Logger: logger,
-	}, nil
+	}
+	if err == nil {
+		result.file = file
+	}
+	return result, nil
 }
@@ -1,23 +1,30 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
 	"os"
 	"os/signal"
-	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/shopspring/decimal"
 	"github.com/sirupsen/logrus"
 	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/models"
 	"scanner.magictradebot.com/pkg/aggregator"
 	"scanner.magictradebot.com/pkg/db"
 	"scanner.magictradebot.com/pkg/exchanges"
 	"scanner.magictradebot.com/pkg/global"
+	"scanner.magictradebot.com/pkg/parquetstore"
+	"scanner.magictradebot.com/pkg/retention"
+	"scanner.magictradebot.com/pkg/storage"
+	"scanner.magictradebot.com/pkg/writequeue"
 )
 
 func init() {
@@ -36,39 +43,192 @@ func main() {
 
 	loggerResult, _ := config.InitLogger(true)
 	log := loggerResult.Logger
+	if len(os.Args) > 1 {
+		loggerResult.UseStderr()
+	}
 
 	log.Info("📈 App started")
 	log.Debug("🔍 Debug info loaded")
 
 	config.LoadConfig("appsettings.yaml")
+	models.TablePrefix = config.Settings.Database.Prefix()
 	log.Info("⚙️ Configuration loaded")
 
-	db.InitDB(log)
+	if len(os.Args) > 1 && os.Args[1] == "compact" {
+		runCompact(log)
+		return
+	}
+
+	gormDB, err := db.Open(config.Settings.Database, log)
+	if err != nil {
+		log.Fatalf("❌ Failed to open database: %v", err)
+	}
+	command := ""
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	// Sizing the decimal columns fetches every instrument from the exchange, so only the
+	// collector, the migrate command and commands about to apply migrations do it.
+	needsScale := command == "" || command == "migrate"
+	if !needsScale && config.Settings.Database.AutoMigrate {
+		pending, err := db.NewMigrator(gormDB, config.Settings.Exchange, db.DecimalScale{}, config.Settings.Database.Postgres, log).Pending()
+		if err != nil {
+			log.Fatalf("❌ Failed to read migration status: %v", err)
+		}
+		needsScale = pending > 0
+	}
+	var scale db.DecimalScale // zero leaves the decimal columns as they are
+	if needsScale {
+		scale = decimalScale(config.Settings.Exchange, config.Settings.Symbols, config.Settings.Database, log)
+	}
+	store := db.NewGormRepository(gormDB, config.Settings.Exchange, config.Settings.Instance, scale, config.Settings.Database.Postgres, log)
+	defer store.Close()
 	log.Info("🗃️ Database initialized")
 
-	if err := db.AutoMigrate(); err != nil {
-		log.Fatalf("❌ AutoMigrate failed: %v", err)
+	if command == "migrate" {
+		runMigrate(os.Args[2:], db.NewMigrator(gormDB, config.Settings.Exchange, scale, config.Settings.Database.Postgres, log), log)
+		return
 	}
-	log.Info("✅ Auto-migration complete")
+
+	if config.Settings.Database.AutoMigrate {
+		if err := store.Migrate(); err != nil {
+			log.Fatalf("❌ Migration failed: %v", err)
+		}
+		log.Info("✅ Schema is up to date")
+	} else {
+		pending, err := db.NewMigrator(gormDB, config.Settings.Exchange, scale, config.Settings.Database.Postgres, log).Pending()
+		if err != nil {
+			log.Fatalf("❌ Failed to read migration status: %v", err)
+		}
+		if pending > 0 {
+			log.Fatalf("❌ %d pending schema migrations; run `migrate up` first", pending)
+		}
+	}
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "reconcile":
+			runReconcile(os.Args[2:], store, log)
+			return
+		case "export":
+			runExport(os.Args[2:], store, log)
+			return
+		case "retention":
+			runRetention(os.Args[2:], store, log)
+			return
+		}
+	}
+
+	if err := store.MaintainPartitions(time.Now()); err != nil {
+		log.Fatalf("❌ Failed to prepare kline partitions: %v", err)
+	}
+	lastPartitionCheck := time.Now()
+
+	var retentionJob *retention.Job
+	if retentionCfg := config.Settings.Retention; retentionCfg.Enabled {
+		if retentionJob, err = retention.New(retentionCfg, config.Settings.Aggregator.Alignment, config.Settings.Exchange, store, log); err != nil {
+			log.Fatalf("❌ Invalid retention policies: %v", err)
+		}
+		log.WithField("dry_run", retentionCfg.DryRun).Info("🧹 Kline retention enabled")
+	}
+	lastRetention := time.Time{}
 
 	exchange := config.Settings.Exchange
 	symbols := config.Settings.Symbols
 
+	var dbSink storage.KlineSink = store
+	var writeQueue *writequeue.Queue
+	if queueCfg := config.Settings.WriteQueue; queueCfg.Enabled {
+		if writeQueue, err = writequeue.New(queueCfg, store, log); err != nil {
+			log.Fatalf("❌ Failed to open write queue: %v", err)
+		}
+		dbSink = writeQueue
+		log.WithField("directory", queueCfg.Directory).Info("📬 Writing klines through the write-behind queue")
+	}
+
+	klineSink := dbSink
+	var parquetSink *parquetstore.Sink
+	if parquetCfg := config.Settings.Parquet; parquetCfg.Enabled {
+		if parquetSink, err = parquetstore.NewSink(parquetCfg, exchange, config.Settings.Instance, log); err != nil {
+			log.Fatalf("❌ Failed to open parquet sink: %v", err)
+		}
+		klineSink = storage.MultiSink{dbSink, parquetSink}
+		log.WithField("directory", parquetCfg.Directory).Info("📦 Writing klines to Parquet")
+	}
+
 	// ❌ Load blacklisted symbols from config
 	invalidSymbols := make(map[string]bool)
 	for _, sym := range config.Settings.BlacklistedSymbols {
 		invalidSymbols[strings.ToUpper(sym)] = true
 	}
 
-	batchSize := 50
+	schedCfg := config.Settings.Scheduler
+	batchSize := schedCfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = 50
+	}
 	rotator := aggregator.NewSymbolRotator(symbols, batchSize)
 
-	kAgg := aggregator.NewKlineAggregator(log, config.Settings.Debug)
+	kAgg, err := aggregator.NewKlineAggregator(log, config.Settings.Debug, config.Settings.Aggregator)
+	if err != nil {
+		log.Fatalf("❌ Invalid aggregator settings: %v", err)
+	}
+	log.WithField("intervals", kAgg.Intervals()).Info("🕯️ Kline intervals configured")
+
+	var tickFilter *aggregator.TickFilter
+	if filterCfg := config.Settings.Aggregator.TickFilter; filterCfg.Enabled {
+		if tickFilter, err = aggregator.NewTickFilter(filterCfg, log); err != nil {
+			log.Fatalf("❌ Invalid tick filter settings: %v", err)
+		}
+		log.Info("🧹 Tick filter enabled")
+	}
+
+	var officialQueue *aggregator.OfficialKlineQueue
+	if hybridCfg := config.Settings.Hybrid; hybridCfg.Enabled {
+		if officialQueue, err = aggregator.NewOfficialKlineQueue(symbols, hybridCfg, kAgg.Intervals()); err != nil {
+			log.Fatalf("❌ Invalid hybrid settings: %v", err)
+		}
+		log.Info("🏛️ Hybrid mode enabled: official exchange klines are stored alongside aggregated ones")
+	}
+
+	if len(config.Settings.Aggregator.Bars) > 0 {
+		// Seeded before the journal replay, which may start new bar builders
+		next, err := store.NextBarSequences()
+		if err != nil {
+			log.Fatalf("❌ Failed to load bar sequences: %v", err)
+		}
+		kAgg.SeedBarSequences(func(symbol, barType string) int64 {
+			return next[storage.StoredSymbol(symbol)][barType]
+		})
+	}
+
+	journalCfg := config.Settings.Aggregator.Journal
+	if journalCfg.Enabled {
+		replayed, err := kAgg.OpenJournal(journalCfg.Directory)
+		if err != nil {
+			log.Fatalf("❌ Failed to restore aggregator state: %v", err)
+		}
+		log.WithFields(logrus.Fields{
+			"directory": journalCfg.Directory,
+			"replayed":  replayed,
+		}).Info("💾 Aggregator state restored from journal")
+	}
+	if journalCfg.SnapshotSeconds <= 0 {
+		journalCfg.SnapshotSeconds = 60
+	}
+	lastSnapshot := time.Now()
 
 	if config.Settings.RefreshSeconds < 4 {
 		config.Settings.RefreshSeconds = 4
 	}
 	refreshInterval := time.Duration(config.Settings.RefreshSeconds) * time.Second
+
+	var scheduler *aggregator.SymbolScheduler
+	if strings.EqualFold(schedCfg.Mode, "adaptive") {
+		scheduler = aggregator.NewSymbolScheduler(symbols, schedCfg, refreshInterval)
+		log.WithField("per_symbol", schedCfg.PerSymbol).Info("🎯 Adaptive symbol scheduling enabled")
+	}
 	ticker := time.NewTicker(refreshInterval)
 	defer ticker.Stop()
 
@@ -84,16 +244,100 @@ func main() {
 
 	if streamCfg.Enabled {
 		global.InitStreamingClients(streamCfg)
-		defer global.ShutdownStreamingClients()
+	}
+
+	aggCfg := config.Settings.Aggregator
+	if err := global.ValidateRawTickConfig(streamCfg, aggCfg.PersistRawTicks, log); err != nil {
+		log.Fatal(err)
+	}
+
+	if aggCfg.PersistRawTicks.Enabled {
+		global.InitRawTickClients(streamCfg, aggCfg.PersistRawTicks)
+	}
+	defer global.ShutdownStreamingClients()
+
+	if err := global.ValidateStreamSourceConfig(streamCfg, log); err != nil {
+		log.Fatal(err)
+	}
+
+	subCfg := aggCfg.SubMinute
+	subIntervals := kAgg.SubMinuteIntervals()
+	if len(subIntervals) > 0 && !streamCfg.Source.Enabled {
+		log.Fatalf("❌ Sub-minute intervals %v need Streaming.Source to be enabled", subIntervals)
+	}
+	if subCfg.FlushSeconds <= 0 {
+		subCfg.FlushSeconds = 10
+	}
+	if subCfg.RetentionHours <= 0 {
+		subCfg.RetentionHours = 24
+	}
+	lastSubFlush := time.Now()
+	lastSubPurge := time.Time{}
+
+	sourceCtx, stopSource := context.WithCancel(context.Background())
+	defer stopSource()
+	if streamCfg.Source.Enabled {
+		global.InitStreamSourceClients(streamCfg)
+		go aggregator.ConsumeStream(sourceCtx, streamCfg.Source, func(t aggregator.StreamTick) {
+			tick := aggregator.TickData{
+				Price:       t.Price,
+				Time:        t.Timestamp,
+				Volume:      t.Volume,
+				QuoteVolume: t.QuoteVolume,
+			}
+			if tickFilter != nil && !tickFilter.Accept(t.Symbol, tick) {
+				return
+			}
+			if t.Cumulative {
+				kAgg.AddTicker(t.Symbol, t.Price, t.Volume, t.QuoteVolume, t.Timestamp)
+			} else {
+				kAgg.AddTick(t.Symbol, tick)
+			}
+		}, log)
+		log.WithFields(logrus.Fields{
+			"input":     streamCfg.Source.Input,
+			"target":    streamCfg.Source.Target,
+			"intervals": subIntervals,
+		}).Info("📡 Consuming ticks from stream source")
+	}
+
+	// Official klines are fetched on their own ticker so slow kline requests never delay tickers
+	officialCtx, stopOfficial := context.WithCancel(context.Background())
+	defer stopOfficial()
+	officialDone := make(chan struct{})
+	if officialQueue != nil {
+		every := time.Duration(config.Settings.Hybrid.PollSeconds) * time.Second
+		if every <= 0 {
+			every = 10 * time.Second
+		}
+		go func() {
+			defer close(officialDone)
+			runOfficialKlines(officialCtx, exchange, officialQueue, store, every, log)
+		}()
+	} else {
+		close(officialDone)
 	}
 
 loop:
 	for {
 		select {
 		case <-ticker.C:
-			time.Sleep(time.Duration(rand.Intn(500)) * time.Millisecond)
+			if aggCfg.EnableJitter && aggCfg.JitterMaxMillis > 0 {
+				time.Sleep(time.Duration(rand.Intn(aggCfg.JitterMaxMillis)) * time.Millisecond)
+			}
+
+			stats := aggregator.NewBatchStats()
 
-			rawBatch := rotator.NextBatch()
+			var rawBatch []string
+			if scheduler != nil {
+				budget := 1.0
+				if remaining, limit, ok := exchanges.RateLimitBudget(exchange); ok && limit > 0 {
+					budget = float64(remaining) / float64(limit)
+				}
+				rawBatch = scheduler.NextBatch(time.Now(), budget)
+			} else {
+				rawBatch = rotator.NextBatch()
+			}
 			batch := make([]string, 0, len(rawBatch))
 			for _, sym := range rawBatch {
 				if !invalidSymbols[strings.ToUpper(sym)] {
@@ -102,34 +346,62 @@ loop:
 			}
 
 			if len(batch) == 0 {
-				log.Warn("⚠️ No valid symbols in batch to process")
-				continue
-			}
-
-			tickers, err := exchanges.CoreFuturesAllTickers(exchange)
-			if err != nil {
-				log.Errorf("❌ Failed to fetch tickers: %v", err)
+				if scheduler != nil {
+					// Nothing is due yet; the adaptive scheduler skips cycles on purpose
+					log.Debug("⏸️ No symbols due this cycle")
+				} else {
+					log.Warn("⚠️ No valid symbols in batch to process")
+				}
 				continue
 			}
 
-			symbolSet := make(map[string]bool)
+			// Exchanges may report a symbol in another case than it is configured in
+			requested := make(map[string]string, len(batch))
 			for _, sym := range batch {
-				symbolSet[strings.ToUpper(sym)] = true
+				requested[strings.ToUpper(sym)] = sym
 			}
 
-			filtered := make([]*exchanges.TickerInfo, 0, len(batch))
-			for _, t := range tickers {
-				if symbolSet[strings.ToUpper(t.Symbol)] {
-					filtered = append(filtered, t)
+			fetchStart := time.Now()
+			var tickers []*exchanges.TickerInfo
+			failed := make(map[string]bool)
+			if schedCfg.PerSymbol {
+				tickers, failed = fetchPerSymbol(exchange, batch, log)
+			} else {
+				all, err := exchanges.CoreFuturesAllTickers(exchange)
+				if err != nil {
+					log.Errorf("❌ Failed to fetch tickers: %v", err)
+					if aggCfg.EnableBatchStats {
+						// A failed cycle still shows up, with every requested symbol missing
+						stats.FetchLatency = time.Since(fetchStart)
+						stats.Requested = len(batch)
+						stats.Missing = len(batch)
+						stats.Log(log)
+					}
+					continue
+				}
+
+				tickers = make([]*exchanges.TickerInfo, 0, len(batch))
+				for _, t := range all {
+					if _, ok := requested[strings.ToUpper(t.Symbol)]; ok {
+						tickers = append(tickers, t)
+					}
 				}
 			}
-			tickers = filtered
+			stats.FetchLatency = time.Since(fetchStart)
+
+			stats.Requested = len(batch)
+			stats.Fetched = len(tickers)
+			stats.Missing = len(batch) - len(tickers)
 
 			log.WithFields(logrus.Fields{
 				"fetched":   len(tickers),
 				"requested": len(batch),
 			}).Info("📥 Batch ticker fetch complete")
 
+			if aggCfg.PersistRawTicks.Enabled {
+				go aggregator.PersistRawTicks(convertToRawTicks(tickers), aggCfg.PersistRawTicks, log)
+			}
+
 			// 🛑 Detect and persist new blacklisted symbols
 			foundSymbols := make(map[string]bool)
 			for _, t := range tickers {
@@ -137,9 +409,13 @@ loop:
 			}
 			for _, sym := range batch {
 				up := strings.ToUpper(sym)
-				if !foundSymbols[up] && !invalidSymbols[up] {
+				// A failed per-symbol request says nothing about whether the symbol exists
+				if !foundSymbols[up] && !invalidSymbols[up] && !failed[up] {
 					log.WithField("symbol", up).Warn("🚫 Symbol not found in exchange response, blacklisting and saving to config")
 					invalidSymbols[up] = true
+					if scheduler != nil {
+						scheduler.Remove(sym)
+					}
 					config.Settings.BlacklistedSymbols = append(config.Settings.BlacklistedSymbols, up)
 					if err := config.SaveConfig("appsettings.yaml"); err != nil {
 						log.Errorf("❌ Failed to save updated config: %v", err)
@@ -148,25 +424,54 @@ loop:
 			}
 
 			for _, t := range tickers {
-				price, err := strconv.ParseFloat(t.LastPrice, 64)
+				price, err := decimal.NewFromString(t.LastPrice)
 				if err != nil {
 					log.WithFields(logrus.Fields{
 						"symbol": t.Symbol,
 						"value":  t.LastPrice,
 					}).Warnf("❌ Failed to parse price: %v", err)
+					stats.ParseFailures++
 					continue
 				}
 
-				volume, err := strconv.ParseFloat(t.Vol24h, 64)
+				volume, err := decimal.NewFromString(t.Vol24h)
 				if err != nil {
 					log.WithFields(logrus.Fields{
 						"symbol": t.Symbol,
 						"value":  t.Vol24h,
 					}).Warnf("❌ Failed to parse volume: %v", err)
+					stats.ParseFailures++
+					continue
+				}
+
+				// Quote volume is optional; without it turnover is estimated from price × volume
+				quoteVolume := decimal.Zero
+				if t.QuoteVol24h != "" {
+					if quoteVolume, err = decimal.NewFromString(t.QuoteVol24h); err != nil {
+						log.WithFields(logrus.Fields{
+							"symbol": t.Symbol,
+							"value":  t.QuoteVol24h,
+						}).Warnf("⚠️ Failed to parse quote volume: %v", err)
+						quoteVolume = decimal.Zero
+					}
+				}
+
+				if tickFilter != nil && !tickFilter.Accept(t.Symbol, aggregator.TickData{
+					Price:       price,
+					Time:        t.Timestamp,
+					Volume:      volume,
+					QuoteVolume: quoteVolume,
+				}) {
+					stats.Rejected++
 					continue
 				}
 
-				kAgg.AddPrice(t.Symbol, price, volume)
+				kAgg.AddTicker(t.Symbol, price, volume, quoteVolume, t.Timestamp)
+				stats.Applied++
+
+				if scheduler != nil {
+					scheduler.Observe(requested[strings.ToUpper(t.Symbol)], price.InexactFloat64(), quoteVolume.InexactFloat64(), time.UnixMilli(t.Timestamp))
+				}
 
 				if streamCfg.Enabled {
 					tick := ConvertToAggregatorTicker(t)
@@ -175,7 +480,7 @@ loop:
 			}
 
 			now := time.Now().UTC().Truncate(time.Second)
-			flushNow := getFlushIntervals(now, log)
+			flushNow := getFlushIntervals(kAgg, now, log)
 
 			if kAgg.Debug {
 				kAgg.Logger.Infof("[Debug] Flushing intervals: %v", flushNow)
@@ -183,29 +488,208 @@ loop:
 
 			if len(flushNow) > 0 {
 				klineData := kAgg.ExtractOhlc(flushNow...)
-				if len(klineData) > 0 {
-					log.Infof("📊 Extracted %d OHLC records", len(klineData))
-					if err := db.SaveKlines(klineData, config.Settings.Instance, log); err != nil {
+				stats.RecordCandles(klineData)
+				fresh, amended := splitAmended(klineData)
+				if len(fresh) > 0 {
+					log.Infof("📊 Extracted %d OHLC records", len(fresh))
+					if err := klineSink.SaveKlines(fresh); err != nil {
 						log.Errorf("❌ Failed to save klines: %v", err)
 					} else {
-						log.Infof("✅ Saved %d OHLC entries to DB", len(klineData))
+						log.Infof("✅ Saved %d OHLC entries to DB", len(fresh))
 					}
 				}
+				if len(amended) > 0 {
+					log.Infof("♻️ Extracted %d amended OHLC records", len(amended))
+					if err := klineSink.UpsertKlines(amended); err != nil {
+						log.Errorf("❌ Failed to upsert amended klines: %v", err)
+					}
+				}
+				if dropped := kAgg.DroppedLateTicks(); dropped > 0 && kAgg.Debug {
+					kAgg.Logger.Debugf("[Debug] Late ticks dropped so far: %d", dropped)
+				}
+				if officialQueue != nil {
+					officialQueue.Enqueue(klineData)
+				}
 			} else {
 				if kAgg.Debug {
 					kAgg.Logger.Debug("No intervals to flush this cycle")
 				}
 			}
 
+			if bars := kAgg.ExtractBars(); len(bars) > 0 {
+				if err := store.SaveBars(bars); err != nil {
+					log.Errorf("❌ Failed to save bars: %v", err)
+				}
+			}
+
+			if len(subIntervals) > 0 && time.Since(lastSubFlush) >= time.Duration(subCfg.FlushSeconds)*time.Second {
+				lastSubFlush = time.Now()
+				if bars := kAgg.ExtractSubMinute(lastSubFlush.UnixMilli()); len(bars) > 0 {
+					if err := store.SaveSecondKlines(bars); err != nil {
+						log.Errorf("❌ Failed to save sub-minute klines: %v", err)
+					}
+				}
+				if dropped, late := kAgg.SubMinuteDrops(); dropped > 0 || late > 0 {
+					log.WithFields(logrus.Fields{
+						"dropped_bars": dropped,
+						"late_ticks":   late,
+					}).Warn("⚠️ Sub-minute data lost so far")
+				}
+			}
+			if len(subIntervals) > 0 && time.Since(lastSubPurge) >= time.Hour {
+				lastSubPurge = time.Now()
+				cutoff := lastSubPurge.Add(-time.Duration(subCfg.RetentionHours) * time.Hour).UnixMilli()
+				if purged, err := store.PurgeSecondKlines(cutoff); err != nil {
+					log.Errorf("❌ Failed to purge sub-minute klines: %v", err)
+				} else if purged > 0 {
+					log.Infof("🧹 Purged %d sub-minute klines older than %dh", purged, subCfg.RetentionHours)
+				}
+			}
+
+			if time.Since(lastPartitionCheck) >= time.Hour {
+				lastPartitionCheck = time.Now()
+				if err := store.MaintainPartitions(lastPartitionCheck); err != nil {
+					log.Errorf("❌ Failed to maintain kline partitions: %v", err)
+				}
+			}
+
+			if retentionJob != nil && time.Since(lastRetention) >= retentionJob.Every() {
+				lastRetention = time.Now()
+				go retentionJob.RunBackground(lastRetention)
+			}
+
+			if tickFilter != nil {
+				if quarantined := tickFilter.ExtractQuarantined(); len(quarantined) > 0 {
+					if err := store.SaveQuarantinedTicks(quarantined); err != nil {
+						log.Errorf("❌ Failed to save quarantined ticks: %v", err)
+					}
+				}
+			}
+
+			if aggCfg.EnableBatchStats {
+				stats.Log(log)
+				if writeQueue != nil {
+					writeQueue.Stats().Log(log)
+				}
+			}
+
+			if journalCfg.Enabled {
+				if err := kAgg.SyncJournal(); err != nil {
+					log.Errorf("❌ Failed to sync aggregator journal: %v", err)
+				}
+				if time.Since(lastSnapshot) >= time.Duration(journalCfg.SnapshotSeconds)*time.Second {
+					if err := kAgg.Snapshot(); err != nil {
+						log.Errorf("❌ Failed to snapshot aggregator state: %v", err)
+					}
+					lastSnapshot = time.Now()
+				}
+			}
+
 		case <-stop:
 			log.Info("🛑 Shutdown signal received")
 			break loop
 		}
 	}
 
+	stopSource()
+	stopOfficial()
+	<-officialDone
+
+	if writeQueue != nil {
+		if err := writeQueue.Close(); err != nil {
+			log.Errorf("❌ Failed to drain write queue: %v", err)
+		} else if spilled := writeQueue.Stats().Spilled; spilled > 0 {
+			log.Warnf("📂 %d klines stay spilled on disk until the next start", spilled)
+		}
+	}
+
+	if parquetSink != nil {
+		if err := parquetSink.Close(); err != nil {
+			log.Errorf("❌ Failed to commit parquet files: %v", err)
+		}
+	}
+
+	if journalCfg.Enabled {
+		if err := kAgg.CloseJournal(); err != nil {
+			log.Errorf("❌ Failed to save aggregator state: %v", err)
+		} else {
+			log.Info("💾 Aggregator state saved")
+		}
+	}
+
 	log.Info("👋 App shutdown complete")
 }
 
+// fetchPerSymbol requests each symbol's ticker separately. Symbols whose request failed
+// are returned upper-cased so they are not mistaken for delisted ones.
+func fetchPerSymbol(exchange string, batch []string, log *logrus.Logger) ([]*exchanges.TickerInfo, map[string]bool) {
+	tickers := make([]*exchanges.TickerInfo, 0, len(batch))
+	failed := make(map[string]bool)
+	for _, sym := range batch {
+		t, err := exchanges.CoreFuturesTicker(exchange, sym)
+		if err != nil {
+			log.WithField("symbol", sym).Warnf("⚠️ Failed to fetch ticker: %v", err)
+			failed[strings.ToUpper(sym)] = true
+			continue
+		}
+		tickers = append(tickers, t)
+	}
+	return tickers, failed
+}
+
+// runOfficialKlines fetches the due official klines every interval until ctx is cancelled.
+func runOfficialKlines(ctx context.Context, exchange string, queue *aggregator.OfficialKlineQueue, repo storage.KlineRepository, every time.Duration, log *logrus.Logger) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			fetchOfficialKlines(exchange, queue, repo, now, log)
+		}
+	}
+}
+
+// fetchOfficialKlines stores the exchange's own version of the closed candles that are due.
+func fetchOfficialKlines(exchange string, queue *aggregator.OfficialKlineQueue, repo storage.KlineRepository, now time.Time, log *logrus.Logger) {
+	var official []models.SymbolKlineData
+	for _, req := range queue.Due(now.UnixMilli()) {
+		data, err := exchanges.CoreFuturesKlines(exchange, req.Symbol, req.Interval, req.From, req.To, 100)
+		if errors.Is(err, exchanges.ErrUnsupportedInterval) {
+			queue.Done(req)
+			continue
+		}
+		if err != nil {
+			fields := logrus.Fields{"symbol": req.Symbol, "interval": req.Interval}
+			if queue.Failed(req) {
+				log.WithFields(fields).Warnf("⚠️ Giving up on official klines: %v", err)
+			} else {
+				log.WithFields(fields).Debugf("Failed to fetch official klines: %v", err)
+			}
+			continue
+		}
+		queue.Done(req)
+		for _, k := range data {
+			kline, err := k.ToKlineData()
+			if err != nil {
+				log.Warnf("⚠️ Skipping official kline: %v", err)
+				continue
+			}
+			official = append(official, kline)
+		}
+	}
+
+	if len(official) == 0 {
+		return
+	}
+	if err := repo.SaveOfficialKlines(official); err != nil {
+		log.Errorf("❌ Failed to save official klines: %v", err)
+		return
+	}
+	log.Infof("🏛️ Saved %d official klines", len(official))
+}
+
 func ConvertToAggregatorTicker(t *exchanges.TickerInfo) aggregator.TickerInfo {
 	return aggregator.TickerInfo{
 		Symbol:             t.Symbol,
@@ -217,13 +701,46 @@ func ConvertToAggregatorTicker(t *exchanges.TickerInfo) aggregator.TickerInfo {
 	}
 }
 
-func getFlushIntervals(now time.Time, log *logrus.Logger) []string {
+func convertToRawTicks(tickers []*exchanges.TickerInfo) []aggregator.RawTick {
+	receivedAt := time.Now().UnixMilli()
+	raw := make([]aggregator.RawTick, 0, len(tickers))
+	for _, t := range tickers {
+		raw = append(raw, aggregator.RawTick{
+			Exchange:    t.Exchange,
+			Symbol:      t.Symbol,
+			LastPrice:   t.LastPrice,
+			High24h:     t.High24h,
+			Low24h:      t.Low24h,
+			Vol24h:      t.Vol24h,
+			QuoteVol24h: t.QuoteVol24h,
+			Change24h:   t.Change24h,
+			Timestamp:   t.Timestamp,
+			ReceivedAt:  receivedAt,
+		})
+	}
+	return raw
+}
+
+// splitAmended separates first-time candles from candles re-emitted after late ticks.
+func splitAmended(klines []models.SymbolKlineData) (fresh, amended []models.SymbolKlineData) {
+	for _, k := range klines {
+		if k.Amendments > 0 {
+			amended = append(amended, k)
+		} else {
+			fresh = append(fresh, k)
+		}
+	}
+	return fresh, amended
+}
+
+func getFlushIntervals(kAgg *aggregator.KlineAggregator, now time.Time, log *logrus.Logger) []string {
 	aligned := now.Truncate(time.Minute).UnixMilli()
+	intervals := kAgg.DueIntervals(now)
 
 	log.Infof("⏱️ Now: %s (%d)", now.UTC().Format("15:04:05"), aligned)
-	log.Infof("📦 Returning intervals: [1m]")
+	log.Infof("📦 Returning intervals: %v", intervals)
 
-	return []string{"1m"}
+	return intervals
 }
 
 // uniqueStrings returns a deduplicated slice
@@ -238,3 +755,55 @@ func getFlushIntervals(now time.Time, log *logrus.Logger) []string {
 	}
 	return result
 }*/
+
+// decimalScale picks the decimals stored for prices and volumes. Configured scales win;
+// otherwise they follow the finest tick and lot sizes among the collected symbols, or among
+// all of the exchange's contracts when none of them is listed.
+func decimalScale(exchange string, symbols []string, settings config.DatabaseSettings, log *logrus.Logger) db.DecimalScale {
+	if settings.PriceScale > 0 && settings.VolumeScale > 0 {
+		return db.DecimalScale{Price: settings.PriceScale, Volume: settings.VolumeScale}
+	}
+
+	scale := db.DefaultDecimalScale
+	instruments, err := exchanges.CoreFuturesInstruments(exchange)
+	if err == nil && len(instruments) == 0 {
+		err = fmt.Errorf("%s listed no instruments", exchange)
+	}
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"price_decimals":  scale.Price,
+			"volume_decimals": scale.Volume,
+		}).Warnf("⚠️ Could not size the decimal columns from the instruments, falling back to the default; set priceScale and volumeScale to choose: %v", err)
+	} else {
+		wanted := make(map[string]bool, len(symbols))
+		for _, s := range symbols {
+			wanted[strings.ToUpper(s)] = true
+		}
+		selected := instruments[:0:0]
+		for _, i := range instruments {
+			if wanted[strings.ToUpper(i.Symbol)] {
+				selected = append(selected, i)
+			}
+		}
+		if len(selected) == 0 {
+			selected = instruments
+		}
+
+		price, step := 0, 0
+		for _, i := range selected {
+			price = max(price, exchanges.DecimalPlaces(i.TickSize))
+			step = max(step, exchanges.DecimalPlaces(i.StepSize))
+		}
+		// A volume's quote side carries the price's decimals on top of the lot size's.
+		scale = db.DecimalScale{Price: price, Volume: price + step}
+	}
+
+	if settings.PriceScale > 0 {
+		scale.Price = settings.PriceScale
+	}
+	if settings.VolumeScale > 0 {
+		scale.Volume = settings.VolumeScale
+	}
+	log.Infof("📐 Instruments need %d price and %d volume decimals", scale.Price, scale.Volume)
+	return scale
+}
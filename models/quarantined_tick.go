@@ -0,0 +1,22 @@
+// models/quarantined_tick.go
+package models
+
+import "github.com/shopspring/decimal"
+
+func (QuarantinedTick) TableName() string {
+	return TablePrefix + "QuarantinedTicks"
+}
+
+// QuarantinedTick is a tick the filter rejected, kept for review.
+type QuarantinedTick struct {
+	ID          int64           `gorm:"primaryKey;autoIncrement"`
+	Symbol      string          `gorm:"size:50;index"`
+	Price       decimal.Decimal `gorm:"type:decimal(38,18)"`
+	Volume      decimal.Decimal `gorm:"type:decimal(42,18)"` // as received, usually a rolling 24h total
+	QuoteVolume decimal.Decimal `gorm:"type:decimal(42,18)"`
+	TickTime    int64           // unix ms reported by the exchange
+	Rule        string          `gorm:"size:20;index"`
+	Reason      string          `gorm:"size:255"`
+	ReceivedAt  int64           `gorm:"index"`
+	Instance    string          `gorm:"index"`
+}
@@ -0,0 +1,29 @@
+// models/symbol_bar_data.go
+package models
+
+import "github.com/shopspring/decimal"
+
+func (SymbolBarData) TableName() string {
+	return TablePrefix + "SymbolBarData"
+}
+
+// SymbolBarData is an information-driven bar (volume, dollar, tick, range or renko).
+// Bars of one exchange, symbol and type are numbered consecutively from zero.
+type SymbolBarData struct {
+	ID        int64           `gorm:"primaryKey;autoIncrement"`
+	Symbol    string          `gorm:"size:50;uniqueIndex:idx_symbol_bar_sequence_exchange"`
+	BarType   string          `gorm:"size:10;uniqueIndex:idx_symbol_bar_sequence_exchange"`
+	Sequence  int64           `gorm:"uniqueIndex:idx_symbol_bar_sequence_exchange"`
+	Threshold float64         // threshold the bar was closed against
+	Open      decimal.Decimal `gorm:"type:decimal(38,18)"`
+	High      decimal.Decimal `gorm:"type:decimal(38,18)"`
+	Low       decimal.Decimal `gorm:"type:decimal(38,18)"`
+	Close     decimal.Decimal `gorm:"type:decimal(38,18)"`
+	Volume    decimal.Decimal `gorm:"type:decimal(42,18)"`
+	Turnover  decimal.Decimal `gorm:"type:decimal(42,18)"` // quote-currency volume
+	TickCount int64
+	OpenTime  int64  `gorm:"index"` // unix ms of the first tick
+	CloseTime int64  // unix ms of the last tick
+	Instance  string `gorm:"index"`
+	Exchange  string `gorm:"size:20;uniqueIndex:idx_symbol_bar_sequence_exchange"`
+}
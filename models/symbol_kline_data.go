@@ -1,20 +1,48 @@
 // models/symbol_kline_data.go
 package models
 
+import "github.com/shopspring/decimal"
+
+// Kline sources: candles built by the collector and candles published by the exchange.
+const (
+	SourceAggregated = "aggregated"
+	SourceExchange   = "exchange"
+)
+
 func (SymbolKlineData) TableName() string {
-	return "Dev_SymbolKlineData"
+	return TablePrefix + "SymbolKlineData"
 }
 
+// Prices and volumes are exact decimals. Migrations size the columns from the instruments'
+// tick and step sizes.
 type SymbolKlineData struct {
-	ID         int64   `gorm:"primaryKey;autoIncrement"`
-	Symbol     string  `gorm:"size:50;uniqueIndex:idx_symbol_interval_time"`
-	Interval   string  `gorm:"size:10;default:1m;uniqueIndex:idx_symbol_interval_time"`
-	Open       float64 `gorm:"type:decimal(18,8)"`
-	High       float64 `gorm:"type:decimal(18,8)"`
-	Low        float64 `gorm:"type:decimal(18,8)"`
-	Close      float64 `gorm:"type:decimal(18,8)"`
-	OpenTime   int64   `gorm:"uniqueIndex:idx_symbol_interval_time"`
-	Instance   string  `gorm:"index"`
-	Volume     float64
-	TradeCount int64
+	ID           int64           `gorm:"primaryKey;autoIncrement"`
+	Symbol       string          `gorm:"size:50;uniqueIndex:idx_symbol_interval_time_source_exchange"`
+	Interval     string          `gorm:"size:10;default:1m;uniqueIndex:idx_symbol_interval_time_source_exchange"`
+	Open         decimal.Decimal `gorm:"type:decimal(38,18)"`
+	High         decimal.Decimal `gorm:"type:decimal(38,18)"`
+	Low          decimal.Decimal `gorm:"type:decimal(38,18)"`
+	Close        decimal.Decimal `gorm:"type:decimal(38,18)"`
+	OpenTime     int64           `gorm:"uniqueIndex:idx_symbol_interval_time_source_exchange"`
+	Source       string          `gorm:"size:10;default:aggregated;uniqueIndex:idx_symbol_interval_time_source_exchange"` // "aggregated" or "exchange"
+	Superseded   bool            `gorm:"default:false"`                                                                   // an exchange candle replaced this aggregated one
+	Exchange     string          `gorm:"size:20;index;uniqueIndex:idx_symbol_interval_time_source_exchange"`
+	Instance     string          `gorm:"index"`
+	Volume       decimal.Decimal `gorm:"type:decimal(42,18)"`
+	Turnover     decimal.Decimal `gorm:"type:decimal(42,18)"` // quote-currency volume
+	VWAP         decimal.Decimal `gorm:"column:vwap;type:decimal(38,18)"`
+	TypicalPrice decimal.Decimal `gorm:"type:decimal(38,18)"` // (high + low + close) / 3
+	TradeCount   int64
+	Amendments   int    `gorm:"default:0"` // times the candle was re-emitted after late ticks
+	FillPolicy   string `gorm:"size:10"`   // empty for sampled candles, "flat" or "synthetic" when filled
+	Synthetic    bool   `gorm:"default:false"`
+	Alignment    string `gorm:"size:64;default:UTC"` // timezone and session open the boundaries follow, e.g. "Asia/Shanghai@17:00"
+
+	// Quality metadata
+	SampleCount   int64   // ticks that went into the candle
+	FirstSampleAt int64   // unix ms of the earliest sample
+	LastSampleAt  int64   // unix ms of the latest sample
+	Coverage      float64 // share of the interval between the first and last sample
+	MaxGapMs      int64   // longest stretch without samples, including from open and up to close
+	Partial       bool    `gorm:"default:false"` // a gap exceeded the configured partial threshold
 }
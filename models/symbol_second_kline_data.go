@@ -0,0 +1,26 @@
+// models/symbol_second_kline_data.go
+package models
+
+import "github.com/shopspring/decimal"
+
+func (SymbolSecondKlineData) TableName() string {
+	return TablePrefix + "SymbolSecondKlineData"
+}
+
+// SymbolSecondKlineData is a sub-minute candle (1s, 5s, 15s, ...) built from streamed ticks.
+// It lives apart from SymbolKlineData and has its own, shorter retention.
+type SymbolSecondKlineData struct {
+	ID         int64           `gorm:"primaryKey;autoIncrement"`
+	Symbol     string          `gorm:"size:50;uniqueIndex:idx_symbol_second_interval_time_exchange"`
+	Interval   string          `gorm:"size:10;uniqueIndex:idx_symbol_second_interval_time_exchange"`
+	OpenTime   int64           `gorm:"uniqueIndex:idx_symbol_second_interval_time_exchange;index"`
+	Open       decimal.Decimal `gorm:"type:decimal(38,18)"`
+	High       decimal.Decimal `gorm:"type:decimal(38,18)"`
+	Low        decimal.Decimal `gorm:"type:decimal(38,18)"`
+	Close      decimal.Decimal `gorm:"type:decimal(38,18)"`
+	Volume     decimal.Decimal `gorm:"type:decimal(42,18)"`
+	Turnover   decimal.Decimal `gorm:"type:decimal(42,18)"` // quote-currency volume
+	TradeCount int64
+	Instance   string `gorm:"index"`
+	Exchange   string `gorm:"size:20;uniqueIndex:idx_symbol_second_interval_time_exchange"`
+}
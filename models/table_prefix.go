@@ -0,0 +1,7 @@
+package models
+
+// LegacyTablePrefix is the prefix tables were created with before it became configurable.
+const LegacyTablePrefix = "Dev_"
+
+// TablePrefix is prepended to every table name. Set it once at startup, before the first query.
+var TablePrefix = LegacyTablePrefix
@@ -1,204 +1,399 @@
 package aggregator
 
 import (
-	"sort"
 	"sync"
 	"time"
 
+	"github.com/shopspring/decimal"
 	"github.com/sirupsen/logrus"
+	"scanner.magictradebot.com/config"
 	"scanner.magictradebot.com/models"
 )
 
+// TickData is one price sample. Prices and volumes are exact decimals as quoted by the exchange.
 type TickData struct {
-	Price  float64
-	Time   int64
-	Volume float64
+	Price       decimal.Decimal
+	Time        int64
+	Volume      decimal.Decimal
+	QuoteVolume decimal.Decimal // quote-currency turnover; price × volume is used when zero
 }
 
-func (a *KlineAggregator) AddPrice(symbol string, price float64, volume float64) {
-	a.lock.Lock()
-	defer a.lock.Unlock()
+// Equal reports whether two ticks carry the same values. Decimals cannot be compared with ==.
+func (t TickData) Equal(other TickData) bool {
+	return t.Time == other.Time && t.Price.Equal(other.Price) &&
+		t.Volume.Equal(other.Volume) && t.QuoteVolume.Equal(other.QuoteVolume)
+}
 
+type lateTick struct {
+	symbol string
+	tick   TickData
+}
+
+// AddPrice applies a tick stamped with the current time, truncated to the second.
+func (a *KlineAggregator) AddPrice(symbol string, price, volume decimal.Decimal) {
 	now := time.Now().UnixMilli()
 	truncated := now - (now % 1000)
 
-	tick := TickData{
+	a.AddTick(symbol, TickData{
 		Price:  price,
 		Time:   truncated,
 		Volume: volume,
+	})
+}
+
+// AddTick applies a tick to the running state of the base bucket its timestamp falls in.
+// Only the symbol's shard is locked, so concurrent feeds for different symbols do not contend.
+// Ticks for a candle that was already emitted amend it while it is within the allowed lateness.
+func (a *KlineAggregator) AddTick(symbol string, tick TickData) {
+	a.ingest(symbol, tick, false)
+}
+
+// AddTicker applies a ticker whose base and quote volumes are rolling 24h totals. The candle
+// receives the volume traded since the symbol's previous ticker rather than the 24h figures.
+// quoteVolume24h may be zero when the exchange does not report it.
+func (a *KlineAggregator) AddTicker(symbol string, price, volume24h, quoteVolume24h decimal.Decimal, timestamp int64) {
+	a.ingest(symbol, TickData{
+		Price:       price,
+		Time:        timestamp,
+		Volume:      volume24h,
+		QuoteVolume: quoteVolume24h,
+	}, true)
+}
+
+func (a *KlineAggregator) ingest(symbol string, tick TickData, cumulative bool) {
+	watermark := time.Now().UnixMilli() - a.allowedLateness
+
+	shard := a.state.shardFor(symbol)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	a.applyLocked(shard, symbol, tick, cumulative, watermark)
+
+	if a.journal != nil {
+		if err := a.journal.append(symbol, tick, cumulative); err != nil {
+			a.Logger.WithError(err).WithField("symbol", symbol).Error("❌ Failed to journal tick")
+		}
 	}
+}
 
-	// Ensure symbol entry exists
-	if _, exists := a.tickBuffer[symbol]; !exists {
-		a.tickBuffer[symbol] = make(map[string][]TickData)
+// applyLocked applies a tick while the symbol's shard lock is held.
+func (a *KlineAggregator) applyLocked(shard *stateShard, symbol string, tick TickData, cumulative bool, watermark int64) {
+	state := shard.symbol(symbol)
+	previousPrice := state.lastPrice
+	if cumulative {
+		tick.Volume = rollingDelta(&state.volume24h, tick.Volume)
+		tick.QuoteVolume = rollingDelta(&state.quoteVolume24h, tick.QuoteVolume)
+	}
+	if tick.QuoteVolume.IsZero() {
+		tick.QuoteVolume = tick.Price.Mul(tick.Volume)
 	}
 
-	// Add tick to every interval for that symbol
-	for interval := range a.intervalToMs {
-		intervalTicks := a.tickBuffer[symbol][interval]
-		// Check if tick already exists
-		exists := false
-		for _, t := range intervalTicks {
-			if t.Time == truncated {
-				exists = true
-				break
-			}
+	// Ticks only feed the base interval; higher intervals are rolled up from base candles
+	openTime := tick.Time - (tick.Time % baseIntervalMs)
+
+	result := state.apply(openTime, tick, watermark)
+	if result != tickDuplicate && len(a.subIntervals) > 0 {
+		a.applySubMinute(symbol, state, tick)
+	}
+	if result != tickDuplicate {
+		// Bars follow arrival order and are independent of candle lateness
+		if bars := a.applyBars(symbol, state, tick, previousPrice); len(bars) > 0 {
+			a.barLock.Lock()
+			a.completedBars = append(a.completedBars, bars...)
+			a.barLock.Unlock()
 		}
-		if !exists {
-			a.tickBuffer[symbol][interval] = append(intervalTicks, tick)
-			if a.Debug {
-				//a.Logger.Printf("[DEBUG] Added tick to %s [%s]: %.2f", symbol, interval, price)
-			}
+	}
+
+	switch result {
+	case tickAmended:
+		// Rollups already merged the emitted base candle, so the tick is applied to them separately
+		a.lateLock.Lock()
+		a.lateTicks = append(a.lateTicks, lateTick{symbol: symbol, tick: tick})
+		a.lateLock.Unlock()
+	case tickTooLate:
+		a.lateLock.Lock()
+		a.droppedLate++
+		a.lateLock.Unlock()
+		if a.Debug {
+			a.Logger.Printf("[DEBUG] Dropped late tick for %s at %d", symbol, tick.Time)
 		}
 	}
 }
 
 type KlineAggregator struct {
-	tickBuffer    map[string]map[string][]TickData // ✅ Changed
-	intervalToMs  map[string]int64
-	maxIntervalMs int64
-	lock          sync.Mutex
-	Debug         bool
-	Logger        *logrus.Logger
+	state           *shardedState
+	rollups         map[string]map[string]map[int64]*candleState // interval -> symbol -> bucket start
+	boundaries      map[string]boundary
+	intervals       []string
+	subIntervals    []string // built from ticks and kept apart from the minute candles
+	lastFlushed     map[string]int64
+	startedAt       int64
+	allowedLateness int64
+	partialGap      int64
+	fills           map[string]*fillState // interval -> empty-candle state, absent when skipping
+	lock            sync.Mutex
+	lateLock        sync.Mutex
+	lateTicks       []lateTick
+	droppedLate     int64
+	barSpecs        []barSpec
+	barSequence     func(symbol, barType string) int64 // first sequence of a new bar builder
+	barLock         sync.Mutex
+	completedBars   []models.SymbolBarData
+	subLock         sync.Mutex
+	sub             *subMinuteState
+	journal         *journal
+	Debug           bool
+	Logger          *logrus.Logger
 }
 
-func NewKlineAggregator(logger *logrus.Logger, debugMode bool) *KlineAggregator {
-	intervals := map[string]int64{
-		"1m": 60_000,
+// NewKlineAggregator builds an aggregator for the configured intervals. Ticks may arrive up to
+// AllowedLatenessSeconds after their candle closed and will amend the already emitted candle.
+func NewKlineAggregator(logger *logrus.Logger, debugMode bool, cfg config.AggregatorSettings) (*KlineAggregator, error) {
+	intervalToMs, err := parseIntervals(cfg.Intervals)
+	if err != nil {
+		return nil, err
 	}
 
-	return &KlineAggregator{
-		tickBuffer:    map[string]map[string][]TickData{"1m": {}},
-		intervalToMs:  intervals,
-		maxIntervalMs: 60_000,
-		Logger:        logger,
-		Debug:         debugMode,
+	minutes, seconds := splitSubMinute(intervalToMs)
+
+	fills, err := parseFillPolicies(cfg.EmptyCandlePolicy, minutes)
+	if err != nil {
+		return nil, err
 	}
-}
 
-/*
-func NewKlineAggregator(logger *logrus.Logger, debugMode bool) *KlineAggregator {
-	intervals := map[string]int64{
-		"1m": 60_000,
+	boundaries, err := parseBoundaries(intervalToMs, cfg.Alignment)
+	if err != nil {
+		return nil, err
 	}
 
-	// Find max interval
-	var maxIntervalMs int64
-	for _, ms := range intervals {
-		if ms > maxIntervalMs {
-			maxIntervalMs = ms
-		}
+	barSpecs, err := parseBarSpecs(cfg.Bars)
+	if err != nil {
+		return nil, err
+	}
+
+	allowedLateness := time.Duration(cfg.AllowedLatenessSeconds) * time.Second
+
+	partialGap := time.Duration(cfg.PartialGapSeconds) * time.Second
+	if partialGap <= 0 {
+		partialGap = 30 * time.Second
 	}
 
 	return &KlineAggregator{
-		tickBuffer:    make(map[string]map[string][]TickData),
-		intervalToMs:  intervals,
-		maxIntervalMs: maxIntervalMs,
-		Logger:        logger,
-		Debug:         debugMode,
+		state:           newShardedState(),
+		rollups:         make(map[string]map[string]map[int64]*candleState),
+		boundaries:      boundaries,
+		intervals:       sortedIntervals(minutes),
+		subIntervals:    sortedIntervals(seconds),
+		sub:             newSubMinuteState(cfg.SubMinute),
+		lastFlushed:     make(map[string]int64),
+		startedAt:       time.Now().UnixMilli(),
+		allowedLateness: allowedLateness.Milliseconds(),
+		partialGap:      partialGap.Milliseconds(),
+		fills:           fills,
+		barSpecs:        barSpecs,
+		Logger:          logger,
+		Debug:           debugMode,
+	}, nil
+}
+
+// Intervals returns the configured intervals ordered from shortest to longest.
+func (a *KlineAggregator) Intervals() []string {
+	return append([]string(nil), a.intervals...)
+}
+
+// DroppedLateTicks returns how many ticks arrived after their candle could no longer be amended.
+func (a *KlineAggregator) DroppedLateTicks() int64 {
+	a.lateLock.Lock()
+	defer a.lateLock.Unlock()
+	return a.droppedLate
+}
+
+// DueIntervals returns the intervals whose boundary has been crossed since they were last flushed.
+// The base interval is always due so every closed minute is finalized as soon as possible.
+func (a *KlineAggregator) DueIntervals(now time.Time) []string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	nowMs := now.UnixMilli()
+	due := []string{BaseInterval}
+	for _, interval := range a.intervals {
+		if interval == BaseInterval {
+			continue
+		}
+		candleEnd := a.boundaries[interval].floor(nowMs)
+		if candleEnd > a.lastFlushed[interval] {
+			due = append(due, interval)
+		}
 	}
-}*/
+	return due
+}
 
+// ExtractOhlc finalizes the base candles that closed before now and rolls up any
+// requested higher intervals whose boundary has been crossed. Candles amended by
+// late ticks are returned again with an increased Amendments counter.
 func (a *KlineAggregator) ExtractOhlc(intervals ...string) []models.SymbolKlineData {
 	a.lock.Lock()
 	defer a.lock.Unlock()
 
 	now := time.Now().UnixMilli()
+	watermark := now - a.allowedLateness
+
+	requested := make(map[string]bool, len(intervals))
+	for _, interval := range intervals {
+		requested[interval] = true
+	}
+
 	var result []models.SymbolKlineData
 
-	for symbol, intervalMap := range a.tickBuffer {
-		for _, interval := range intervals {
-			ticks, exists := intervalMap[interval]
-			if !exists || len(ticks) == 0 {
-				continue
-			}
+	// Base candles are always finalized because higher intervals are built from them
+	a.state.flushClosed(now, watermark, func(symbol string, candle *candleState, amended bool) {
+		if !amended {
+			a.mergeIntoRollups(symbol, *candle)
+		}
+		a.trackReal(BaseInterval, symbol, candle)
+		if requested[BaseInterval] {
+			result = append(result, candle.toKline(symbol, BaseInterval, a.boundaries[BaseInterval], a.partialGap))
+		}
+	})
+	baseEnd := now - (now % baseIntervalMs)
+	if requested[BaseInterval] {
+		result = append(result, a.fillEmpty(BaseInterval, a.lastFlushed[BaseInterval], baseEnd, watermark)...)
+	}
+	a.lastFlushed[BaseInterval] = baseEnd
 
-			sort.Slice(ticks, func(i, j int) bool {
-				return ticks[i].Time < ticks[j].Time
-			})
+	a.applyLateTicks()
 
-			intervalMs, ok := a.intervalToMs[interval]
-			if !ok {
-				continue
-			}
+	for _, interval := range a.intervals {
+		if interval == BaseInterval {
+			continue
+		}
+		result = append(result, a.rollup(interval, now, watermark, requested[interval])...)
+	}
+
+	return result
+}
 
-			candleEnd := now - (now % intervalMs)
-			candleStart := candleEnd - intervalMs
+// mergeIntoRollups folds a finalized base candle into its bucket of every higher interval.
+func (a *KlineAggregator) mergeIntoRollups(symbol string, base candleState) {
+	for _, interval := range a.intervals {
+		if interval == BaseInterval {
+			continue
+		}
+		openTime := a.boundaries[interval].floor(base.OpenTime)
 
-			startIdx := sort.Search(len(ticks), func(i int) bool {
-				return ticks[i].Time >= candleStart
-			})
+		buckets := a.rollupBuckets(interval, symbol)
+		if candle, ok := buckets[openTime]; ok {
+			candle.merge(base)
+		} else {
+			buckets[openTime] = newRollupState(openTime, base)
+		}
+	}
+}
 
-			endIdx := sort.Search(len(ticks), func(i int) bool {
-				return ticks[i].Time >= candleEnd
-			})
+// applyLateTicks applies ticks that amended an emitted base candle to the matching rollup buckets.
+func (a *KlineAggregator) applyLateTicks() {
+	a.lateLock.Lock()
+	pending := a.lateTicks
+	a.lateTicks = nil
+	a.lateLock.Unlock()
 
-			if startIdx == endIdx {
+	for _, late := range pending {
+		for _, interval := range a.intervals {
+			if interval == BaseInterval {
 				continue
 			}
+			openTime := a.boundaries[interval].floor(late.tick.Time)
 
-			group := ticks[startIdx:endIdx]
-			kline := a.buildKline(symbol, interval, group, candleStart)
-			result = append(result, kline)
-
-			// Remove used ticks for this interval
-			a.tickBuffer[symbol][interval] = ticks[endIdx:]
+			// A missing bucket was already dropped past the watermark
+			if candle, ok := a.rollups[interval][late.symbol][openTime]; ok {
+				candle.update(late.tick)
+			}
 		}
-
-		// ✅ Clean up old ticks for this symbol
-		a.cleanupOldTicks(symbol, now)
 	}
+}
 
-	return result
+func (a *KlineAggregator) rollupBuckets(interval, symbol string) map[int64]*candleState {
+	bySymbol, ok := a.rollups[interval]
+	if !ok {
+		bySymbol = make(map[string]map[int64]*candleState)
+		a.rollups[interval] = bySymbol
+	}
+	buckets, ok := bySymbol[symbol]
+	if !ok {
+		buckets = make(map[int64]*candleState)
+		bySymbol[symbol] = buckets
+	}
+	return buckets
 }
 
-func (a *KlineAggregator) cleanupOldTicks(symbol string, now int64) {
-	retention := a.maxIntervalMs * 3
-	oldestValid := now - retention
+// rollup emits the buckets of a higher interval that closed before now when the interval
+// is due, plus any already emitted bucket that was amended since.
+func (a *KlineAggregator) rollup(interval string, now, watermark int64, due bool) []models.SymbolKlineData {
+	b := a.boundaries[interval]
+	candleEnd := b.floor(now)
+
+	prevFlushed := a.lastFlushed[interval]
+	if due && candleEnd > prevFlushed {
+		a.lastFlushed[interval] = candleEnd
+	}
+
+	// A bucket that opened before the collector started would only hold part of its minutes
+	firstComplete := a.startedAt - (a.startedAt % baseIntervalMs)
 
-	for interval, ticks := range a.tickBuffer[symbol] {
-		filtered := []TickData{}
-		for _, tick := range ticks {
-			if tick.Time >= oldestValid {
-				filtered = append(filtered, tick)
+	var result []models.SymbolKlineData
+	for symbol, buckets := range a.rollups[interval] {
+		for openTime, candle := range buckets {
+			closeTime := b.next(openTime)
+			if closeTime > a.lastFlushed[interval] {
+				continue
+			}
+
+			if !candle.finalized && openTime < firstComplete {
+				candle.incomplete = true
+				if a.Debug {
+					a.Logger.Printf("[DEBUG] Skipping incomplete %s bucket for %s starting at %d", interval, symbol, openTime)
+				}
+			}
+			if emit, _ := candle.flush(); emit {
+				a.trackReal(interval, symbol, candle)
+				result = append(result, candle.toKline(symbol, interval, b, a.partialGap))
 			}
-		}
-		a.tickBuffer[symbol][interval] = filtered
 
-		if a.Debug && len(ticks) != len(filtered) {
-			a.Logger.Printf("[DEBUG] Cleaned %d old ticks from %s [%s]", len(ticks)-len(filtered), symbol, interval)
+			if closeTime <= watermark {
+				delete(buckets, openTime)
+			}
+		}
+		if len(buckets) == 0 {
+			delete(a.rollups[interval], symbol)
 		}
 	}
 
-}
+	if a.lastFlushed[interval] > prevFlushed {
+		result = append(result, a.fillEmpty(interval, prevFlushed, a.lastFlushed[interval], watermark)...)
+	}
 
-// In buildKline function - use sorted group directly
-func (a *KlineAggregator) buildKline(symbol, interval string, group []TickData, openTime int64) models.SymbolKlineData {
-	open := group[0].Price
-	close := group[len(group)-1].Price
-	high := group[0].Price
-	low := group[0].Price
-	volumeSum := 0.0
+	return result
+}
 
-	for _, tick := range group {
-		if tick.Price > high {
-			high = tick.Price
-		}
-		if tick.Price < low {
-			low = tick.Price
-		}
-		volumeSum += tick.Volume
+// trackReal records an emitted candle for its interval's empty-candle policy. A real candle
+// replacing a filled bucket counts as an amendment so the stored fill gets overwritten.
+func (a *KlineAggregator) trackReal(interval, symbol string, candle *candleState) {
+	fill, ok := a.fills[interval]
+	if !ok {
+		return
 	}
+	if fill.recordReal(symbol, candle.OpenTime, candle.Close) {
+		candle.Amendments++
+	}
+}
 
-	return models.SymbolKlineData{
-		Symbol:     symbol,
-		Interval:   interval,
-		Open:       open,
-		Close:      close,
-		High:       high,
-		Low:        low,
-		OpenTime:   openTime,
-		Volume:     volumeSum,
-		TradeCount: int64(len(group)),
+// fillEmpty applies the interval's empty-candle policy to buckets closed in [from, to).
+func (a *KlineAggregator) fillEmpty(interval string, from, to, watermark int64) []models.SymbolKlineData {
+	fill, ok := a.fills[interval]
+	if !ok || from == 0 {
+		return nil
 	}
+	b := a.boundaries[interval]
+	fill.forget(b, watermark)
+	return fill.fill(interval, b, from, to)
 }
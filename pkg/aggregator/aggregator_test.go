@@ -0,0 +1,225 @@
+package aggregator
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/models"
+)
+
+// newTestAggregator builds an aggregator that accepts ticks up to two hours old and treats
+// every bucket as complete, so tests can feed ticks at fixed past times.
+func newTestAggregator(t testing.TB, cfg config.AggregatorSettings) *KlineAggregator {
+	t.Helper()
+	if cfg.AllowedLatenessSeconds == 0 {
+		cfg.AllowedLatenessSeconds = 7200
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	agg, err := NewKlineAggregator(logger, false, cfg)
+	if err != nil {
+		t.Fatalf("NewKlineAggregator: %v", err)
+	}
+	agg.startedAt = 0
+	return agg
+}
+
+// testHour is the start of a UTC hour that closed at least an hour ago.
+func testHour() int64 {
+	now := time.Now().UnixMilli()
+	return now - now%3_600_000 - 3_600_000
+}
+
+func tick(price, volume string, at int64) TickData {
+	return TickData{Price: decimal.RequireFromString(price), Volume: decimal.RequireFromString(volume), Time: at}
+}
+
+// byInterval indexes extracted klines by interval, symbol and open time.
+func byInterval(klines []models.SymbolKlineData) map[string]map[string]map[int64]models.SymbolKlineData {
+	result := make(map[string]map[string]map[int64]models.SymbolKlineData)
+	for _, k := range klines {
+		if result[k.Interval] == nil {
+			result[k.Interval] = make(map[string]map[int64]models.SymbolKlineData)
+		}
+		if result[k.Interval][k.Symbol] == nil {
+			result[k.Interval][k.Symbol] = make(map[int64]models.SymbolKlineData)
+		}
+		result[k.Interval][k.Symbol][k.OpenTime] = k
+	}
+	return result
+}
+
+func assertDecimal(t *testing.T, name string, got decimal.Decimal, want string) {
+	t.Helper()
+	if !got.Equal(decimal.RequireFromString(want)) {
+		t.Errorf("%s = %s, want %s", name, got, want)
+	}
+}
+
+func TestRollupFromBaseCandles(t *testing.T) {
+	agg := newTestAggregator(t, config.AggregatorSettings{Intervals: []string{"1m", "5m", "15m"}})
+	start := testHour()
+
+	// Three ticks in each of the first five minutes; prices rise to a peak in minute 2
+	prices := [][]string{
+		{"100", "101", "99"},
+		{"99", "104", "103"},
+		{"103", "110", "108"},
+		{"108", "96", "97"},
+		{"97", "98", "102"},
+	}
+	for m, row := range prices {
+		for i, price := range row {
+			agg.AddTick("BTCUSDT", tick(price, "2", start+int64(m)*60_000+int64(i)*15_000))
+		}
+	}
+
+	got := byInterval(agg.ExtractOhlc("1m", "5m", "15m"))
+
+	if n := len(got["1m"]["BTCUSDT"]); n != 5 {
+		t.Fatalf("got %d base candles, want 5", n)
+	}
+	for _, interval := range []string{"5m", "15m"} {
+		k, ok := got[interval]["BTCUSDT"][start]
+		if !ok {
+			t.Fatalf("no %s candle at %d", interval, start)
+		}
+		assertDecimal(t, interval+" open", k.Open, "100")
+		assertDecimal(t, interval+" high", k.High, "110")
+		assertDecimal(t, interval+" low", k.Low, "96")
+		assertDecimal(t, interval+" close", k.Close, "102")
+		assertDecimal(t, interval+" volume", k.Volume, "30")
+		if k.TradeCount != 15 {
+			t.Errorf("%s trade count = %d, want 15", interval, k.TradeCount)
+		}
+		if k.FirstSampleAt != start || k.LastSampleAt != start+4*60_000+30_000 {
+			t.Errorf("%s samples span [%d, %d]", interval, k.FirstSampleAt, k.LastSampleAt)
+		}
+	}
+}
+
+// bufferedKline is the candle the original aggregator built from its per-symbol tick buffer:
+// a tick was dropped if one with the same time was already buffered, and the rest were sorted
+// by time before taking open, high, low, close, volume and count.
+func bufferedKline(ticks []TickData) (open, high, low, close, volume decimal.Decimal, count int64) {
+	var buffer []TickData
+	for _, t := range ticks {
+		exists := false
+		for _, b := range buffer {
+			if b.Time == t.Time {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			buffer = append(buffer, t)
+		}
+	}
+	sort.SliceStable(buffer, func(i, j int) bool { return buffer[i].Time < buffer[j].Time })
+
+	open, close = buffer[0].Price, buffer[len(buffer)-1].Price
+	high, low = buffer[0].Price, buffer[0].Price
+	for _, t := range buffer {
+		high = decimal.Max(high, t.Price)
+		low = decimal.Min(low, t.Price)
+		volume = volume.Add(t.Volume)
+	}
+	return open, high, low, close, volume, int64(len(buffer))
+}
+
+func TestMatchesBufferedAggregation(t *testing.T) {
+	type sample struct {
+		offset int64 // ms into the minute
+		price  string
+		volume string
+	}
+	tests := []struct {
+		name  string
+		ticks []sample
+	}{
+		{name: "single tick", ticks: []sample{{0, "100", "1"}}},
+		{name: "in order", ticks: []sample{{0, "100", "1"}, {15_000, "103", "2"}, {30_000, "98", "0.5"}, {45_000, "101", "1.25"}}},
+		{name: "out of order", ticks: []sample{{30_000, "98", "1"}, {0, "100", "1"}, {59_999, "104", "3"}, {15_000, "99", "2"}}},
+		{name: "repeated time keeps the first", ticks: []sample{{0, "100", "1"}, {10_000, "105", "1"}, {10_000, "90", "7"}, {20_000, "101", "1"}}},
+		{name: "repeated earlier time", ticks: []sample{{0, "100", "1"}, {10_000, "102", "1"}, {20_000, "101", "1"}, {0, "80", "9"}}},
+		{name: "flat price", ticks: []sample{{1_000, "50", "0"}, {2_000, "50", "0"}, {3_000, "50", "4"}}},
+		{name: "fractional volume", ticks: []sample{{0, "0.00001234", "1000000.1"}, {500, "0.00001299", "0.0000001"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agg := newTestAggregator(t, config.AggregatorSettings{Intervals: []string{"1m"}})
+			start := testHour()
+
+			ticks := make([]TickData, len(tt.ticks))
+			for i, s := range tt.ticks {
+				ticks[i] = tick(s.price, s.volume, start+s.offset)
+				agg.AddTick("BTCUSDT", ticks[i])
+			}
+
+			k, ok := byInterval(agg.ExtractOhlc("1m"))["1m"]["BTCUSDT"][start]
+			if !ok {
+				t.Fatal("no candle emitted")
+			}
+			open, high, low, close, volume, count := bufferedKline(ticks)
+			assertDecimal(t, "open", k.Open, open.String())
+			assertDecimal(t, "high", k.High, high.String())
+			assertDecimal(t, "low", k.Low, low.String())
+			assertDecimal(t, "close", k.Close, close.String())
+			assertDecimal(t, "volume", k.Volume, volume.String())
+			if k.TradeCount != count {
+				t.Errorf("trade count = %d, want %d", k.TradeCount, count)
+			}
+		})
+	}
+}
+
+// BenchmarkIngest feeds 5,000 symbols at 10 updates a second each. One op is one simulated
+// second, so ns/op must stay well below a second for the collector to keep up. Candles are
+// extracted at every simulated minute, and the watermark follows the simulated clock with the
+// shipped 30 second lateness so closed buckets are released as they would be live.
+func BenchmarkIngest(b *testing.B) {
+	const symbols, perSecond = 5000, 10
+	intervals := []string{"1m", "5m", "15m", "1h"}
+
+	names := make([]string, symbols)
+	prices := make([]decimal.Decimal, symbols)
+	for i := range names {
+		names[i] = fmt.Sprintf("SYM%dUSDT", i)
+		prices[i] = decimal.NewFromInt(int64(100 + i%50))
+	}
+	volume := decimal.RequireFromString("0.5")
+
+	var agg *KlineAggregator
+	var start int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		second := int64(n % 3600)
+		if second == 0 {
+			// Simulated time stays in the past, so each simulated hour starts a fresh aggregator
+			b.StopTimer()
+			agg = newTestAggregator(b, config.AggregatorSettings{Intervals: intervals})
+			start = testHour()
+			b.StartTimer()
+		}
+		now := start + second*1000
+		agg.allowedLateness = time.Now().UnixMilli() - now + 30_000
+
+		for u := int64(0); u < perSecond; u++ {
+			at := now + u*1000/perSecond
+			for i, symbol := range names {
+				agg.AddTick(symbol, TickData{Price: prices[i], Volume: volume, Time: at})
+			}
+		}
+		if second%60 == 59 {
+			agg.ExtractOhlc(intervals...)
+		}
+	}
+	b.ReportMetric(float64(b.N)*symbols*perSecond/b.Elapsed().Seconds(), "ticks/s")
+}
@@ -0,0 +1,218 @@
+package aggregator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"scanner.magictradebot.com/config"
+)
+
+// DefaultAlignment is recorded for candles on plain UTC epoch boundaries.
+const DefaultAlignment = "UTC"
+
+// epochMonday is the number of days from 1970-01-01 (a Thursday) to the first Monday.
+const epochMonday = 4
+
+// boundary places the buckets of one interval. Without a location, buckets start at
+// multiples of ms since the epoch. With a location, buckets follow the local calendar:
+// each session opens offset after local midnight, so days may last 23 or 25 hours
+// across DST changes and months follow the calendar.
+type boundary struct {
+	ms       int64
+	unit     byte
+	count    int
+	location *time.Location
+	offset   int // minutes after local midnight a session opens
+	label    string
+}
+
+func newBoundary(label string, ms int64, cfg config.AlignmentSettings) (boundary, error) {
+	b := boundary{
+		ms:    ms,
+		unit:  label[len(label)-1],
+		label: DefaultAlignment,
+	}
+	b.count, _ = strconv.Atoi(label[:len(label)-1])
+
+	aligned := cfg.Timezone != "" || cfg.Offset != ""
+	if !aligned && b.unit != 'w' && b.unit != 'M' {
+		return b, nil
+	}
+	if aligned && ms <= baseIntervalMs {
+		return b, fmt.Errorf("interval %s cannot be aligned; only intervals above %s can", label, BaseInterval)
+	}
+
+	b.location = time.UTC
+	if cfg.Timezone != "" {
+		location, err := parseLocation(cfg.Timezone)
+		if err != nil {
+			return b, fmt.Errorf("interval %s: %w", label, err)
+		}
+		b.location = location
+		b.label = cfg.Timezone
+	}
+
+	if cfg.Offset != "" {
+		offset, err := time.ParseDuration(cfg.Offset)
+		if err != nil {
+			return b, fmt.Errorf("interval %s: invalid offset %q: %w", label, cfg.Offset, err)
+		}
+		if offset < 0 || offset >= 24*time.Hour || offset%time.Minute != 0 {
+			return b, fmt.Errorf("interval %s: offset %q must be whole minutes within a day", label, cfg.Offset)
+		}
+		b.offset = int(offset / time.Minute)
+		if b.offset > 0 {
+			b.label += fmt.Sprintf("@%02d:%02d", b.offset/60, b.offset%60)
+		}
+	}
+
+	return b, nil
+}
+
+// Boundary places the buckets of one interval for code outside the aggregator, such as
+// readers resampling stored candles.
+type Boundary struct {
+	b boundary
+}
+
+// NewBoundary validates an interval label and its alignment.
+func NewBoundary(label string, cfg config.AlignmentSettings) (Boundary, error) {
+	ms, err := ParseInterval(label)
+	if err != nil {
+		return Boundary{}, err
+	}
+	b, err := newBoundary(label, ms, cfg)
+	if err != nil {
+		return Boundary{}, err
+	}
+	return Boundary{b: b}, nil
+}
+
+// Floor returns the start of the bucket containing ts (unix ms).
+func (b Boundary) Floor(ts int64) int64 { return b.b.floor(ts) }
+
+// Next returns the end of the bucket starting at openTime.
+func (b Boundary) Next(openTime int64) int64 { return b.b.next(openTime) }
+
+// Label is the alignment recorded on candles of these buckets.
+func (b Boundary) Label() string { return b.b.label }
+
+// parseLocation accepts IANA names such as "Asia/Shanghai" and fixed offsets such as "UTC+08:00".
+func parseLocation(name string) (*time.Location, error) {
+	if rest, ok := strings.CutPrefix(name, "UTC"); ok && rest != "" {
+		sign := 1
+		switch rest[0] {
+		case '+':
+		case '-':
+			sign = -1
+		default:
+			return nil, fmt.Errorf("invalid timezone %q", name)
+		}
+		parts := strings.SplitN(rest[1:], ":", 2)
+		hours, err := strconv.Atoi(parts[0])
+		if err != nil || hours > 14 {
+			return nil, fmt.Errorf("invalid timezone %q", name)
+		}
+		minutes := 0
+		if len(parts) == 2 {
+			if minutes, err = strconv.Atoi(parts[1]); err != nil || minutes >= 60 {
+				return nil, fmt.Errorf("invalid timezone %q", name)
+			}
+		}
+		return time.FixedZone(name, sign*(hours*3600+minutes*60)), nil
+	}
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %w", name, err)
+	}
+	return location, nil
+}
+
+// floor returns the start of the bucket containing ts.
+func (b boundary) floor(ts int64) int64 {
+	if b.location == nil {
+		return ts - (ts % b.ms)
+	}
+
+	year, month, day := b.sessionDate(ts)
+	switch b.unit {
+	case 'd':
+		day -= int(daysSinceEpoch(year, month, day) % int64(b.count))
+		return b.session(year, month, day)
+	case 'w':
+		index := daysSinceEpoch(year, month, day) - epochMonday
+		day -= int(index%7 + (index/7%int64(b.count))*7)
+		return b.session(year, month, day)
+	case 'M':
+		index := year*12 + int(month) - 1
+		index -= index % b.count
+		return b.session(index/12, time.Month(index%12+1), 1)
+	default:
+		// Sub-day buckets restart at every session open
+		start := b.session(year, month, day)
+		return start + (ts-start)/b.ms*b.ms
+	}
+}
+
+// next returns the end of the bucket starting at openTime.
+func (b boundary) next(openTime int64) int64 {
+	if b.location == nil {
+		return openTime + b.ms
+	}
+
+	year, month, day := b.sessionDate(openTime)
+	switch b.unit {
+	case 'd':
+		return b.session(year, month, day+b.count)
+	case 'w':
+		return b.session(year, month, day+7*b.count)
+	case 'M':
+		return b.session(year, month+time.Month(b.count), 1)
+	default:
+		end := openTime + b.ms
+		if nextSession := b.session(year, month, day+1); end > nextSession {
+			return nextSession
+		}
+		return end
+	}
+}
+
+// session returns the unix ms at which the session of a local date opens.
+func (b boundary) session(year int, month time.Month, day int) int64 {
+	return time.Date(year, month, day, 0, b.offset, 0, 0, b.location).UnixMilli()
+}
+
+// sessionDate returns the local date of the session ts falls in.
+func (b boundary) sessionDate(ts int64) (int, time.Month, int) {
+	local := time.UnixMilli(ts).In(b.location)
+	year, month, day := local.Date()
+	if b.session(year, month, day) > ts {
+		return local.AddDate(0, 0, -1).Date()
+	}
+	return year, month, day
+}
+
+func daysSinceEpoch(year int, month time.Month, day int) int64 {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Unix() / 86_400
+}
+
+func parseBoundaries(intervals map[string]int64, alignment map[string]config.AlignmentSettings) (map[string]boundary, error) {
+	for interval := range alignment {
+		if _, ok := intervals[interval]; !ok {
+			return nil, fmt.Errorf("alignment set for unconfigured interval %q", interval)
+		}
+	}
+
+	result := make(map[string]boundary, len(intervals))
+	for interval, ms := range intervals {
+		b, err := newBoundary(interval, ms, alignment[interval])
+		if err != nil {
+			return nil, err
+		}
+		result[interval] = b
+	}
+	return result, nil
+}
@@ -0,0 +1,112 @@
+package aggregator
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // the zones below do not depend on the host's database
+
+	"scanner.magictradebot.com/config"
+)
+
+func utcMs(value string) int64 {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+	return t.UnixMilli()
+}
+
+func TestBoundaryAcrossSessionsAndDST(t *testing.T) {
+	tests := []struct {
+		name      string
+		interval  string
+		alignment config.AlignmentSettings
+		at        string
+		wantOpen  string
+		wantClose string
+	}{
+		{
+			name:     "UTC epoch hour",
+			interval: "1h", at: "2024-03-10T10:30:00Z",
+			wantOpen: "2024-03-10T10:00:00Z", wantClose: "2024-03-10T11:00:00Z",
+		},
+		{
+			name:     "New York day losing an hour",
+			interval: "1d", alignment: config.AlignmentSettings{Timezone: "America/New_York"}, at: "2024-03-10T16:00:00Z",
+			wantOpen: "2024-03-10T05:00:00Z", wantClose: "2024-03-11T04:00:00Z",
+		},
+		{
+			name:     "New York day gaining an hour",
+			interval: "1d", alignment: config.AlignmentSettings{Timezone: "America/New_York"}, at: "2024-11-03T16:00:00Z",
+			wantOpen: "2024-11-03T04:00:00Z", wantClose: "2024-11-04T05:00:00Z",
+		},
+		{
+			name:     "17:00 New York session across spring forward",
+			interval: "1d", alignment: config.AlignmentSettings{Timezone: "America/New_York", Offset: "17h"}, at: "2024-03-10T20:00:00Z",
+			wantOpen: "2024-03-09T22:00:00Z", wantClose: "2024-03-10T21:00:00Z",
+		},
+		{
+			name:     "17:00 New York session after spring forward",
+			interval: "1d", alignment: config.AlignmentSettings{Timezone: "America/New_York", Offset: "17h"}, at: "2024-03-10T21:00:00Z",
+			wantOpen: "2024-03-10T21:00:00Z", wantClose: "2024-03-11T21:00:00Z",
+		},
+		{
+			name:     "4h bucket inside a New York session",
+			interval: "4h", alignment: config.AlignmentSettings{Timezone: "America/New_York"}, at: "2024-03-10T10:30:00Z",
+			wantOpen: "2024-03-10T09:00:00Z", wantClose: "2024-03-10T13:00:00Z",
+		},
+		{
+			name:     "last 4h bucket of a 23 hour day is cut at the next session",
+			interval: "4h", alignment: config.AlignmentSettings{Timezone: "America/New_York"}, at: "2024-03-11T02:00:00Z",
+			wantOpen: "2024-03-11T01:00:00Z", wantClose: "2024-03-11T04:00:00Z",
+		},
+		{
+			name:     "Shanghai week starts on Monday",
+			interval: "1w", alignment: config.AlignmentSettings{Timezone: "Asia/Shanghai"}, at: "2024-01-03T12:00:00Z",
+			wantOpen: "2023-12-31T16:00:00Z", wantClose: "2024-01-07T16:00:00Z",
+		},
+		{
+			name:     "London month ending in summer time",
+			interval: "1M", alignment: config.AlignmentSettings{Timezone: "Europe/London"}, at: "2024-03-15T00:00:00Z",
+			wantOpen: "2024-03-01T00:00:00Z", wantClose: "2024-03-31T23:00:00Z",
+		},
+		{
+			name:     "fixed half-hour offset",
+			interval: "1d", alignment: config.AlignmentSettings{Timezone: "UTC+05:30"}, at: "2024-01-01T20:00:00Z",
+			wantOpen: "2024-01-01T18:30:00Z", wantClose: "2024-01-02T18:30:00Z",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := NewBoundary(tt.interval, tt.alignment)
+			if err != nil {
+				t.Fatalf("NewBoundary: %v", err)
+			}
+			open := b.Floor(utcMs(tt.at))
+			if want := utcMs(tt.wantOpen); open != want {
+				t.Errorf("open = %s, want %s", time.UnixMilli(open).UTC().Format(time.RFC3339), tt.wantOpen)
+			}
+			if close, want := b.Next(open), utcMs(tt.wantClose); close != want {
+				t.Errorf("close = %s, want %s", time.UnixMilli(close).UTC().Format(time.RFC3339), tt.wantClose)
+			}
+		})
+	}
+}
+
+func TestBoundaryRejectsInvalidAlignment(t *testing.T) {
+	tests := []struct {
+		interval  string
+		alignment config.AlignmentSettings
+	}{
+		{interval: "1m", alignment: config.AlignmentSettings{Timezone: "Asia/Tokyo"}},
+		{interval: "1d", alignment: config.AlignmentSettings{Timezone: "Mars/Olympus"}},
+		{interval: "1d", alignment: config.AlignmentSettings{Timezone: "UTC+15"}},
+		{interval: "1d", alignment: config.AlignmentSettings{Offset: "24h"}},
+		{interval: "1d", alignment: config.AlignmentSettings{Offset: "90s"}},
+	}
+	for _, tt := range tests {
+		if _, err := NewBoundary(tt.interval, tt.alignment); err == nil {
+			t.Errorf("%s with %+v was accepted", tt.interval, tt.alignment)
+		}
+	}
+}
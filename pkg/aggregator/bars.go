@@ -0,0 +1,313 @@
+package aggregator
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/models"
+)
+
+// Information-driven bar types built alongside the time candles.
+const (
+	BarVolume = "volume" // closes after a fixed base volume
+	BarDollar = "dollar" // closes after a fixed quote turnover
+	BarTick   = "tick"   // closes after a fixed number of ticks
+	BarRange  = "range"  // closes once high - low reaches the threshold
+	BarRenko  = "renko"  // emits a brick each time price moves one threshold from the last brick
+)
+
+const minutesPerDay = 1440
+
+// barSpec is the validated configuration of one bar type.
+type barSpec struct {
+	barType    string
+	threshold  float64
+	symbols    map[string]float64
+	adaptive   bool
+	barsPerDay float64
+	alpha      float64 // EWMA weight of the latest minute of activity
+}
+
+// barState is the open bar of one type for one symbol.
+type barState struct {
+	Sequence  int64
+	Threshold float64
+	Open      decimal.Decimal
+	High      decimal.Decimal
+	Low       decimal.Decimal
+	Close     decimal.Decimal
+	Volume    decimal.Decimal
+	Turnover  decimal.Decimal
+	TickCount int64
+	OpenTime  int64
+	CloseTime int64
+	Progress  float64         // volume, turnover or ticks accumulated toward the threshold
+	Anchor    decimal.Decimal // renko: close of the last brick
+
+	// Adaptive threshold: EWMA of activity per minute
+	Minute         int64
+	MinuteActivity float64
+	Ewma           float64
+}
+
+func parseBarSpecs(settings []config.BarSettings) ([]barSpec, error) {
+	specs := make([]barSpec, 0, len(settings))
+	seen := make(map[string]bool)
+
+	for _, s := range settings {
+		barType := strings.ToLower(strings.TrimSpace(s.Type))
+		switch barType {
+		case BarVolume, BarDollar, BarTick, BarRange, BarRenko:
+		default:
+			return nil, fmt.Errorf("unknown bar type %q (use volume, dollar, tick, range or renko)", s.Type)
+		}
+		if seen[barType] {
+			return nil, fmt.Errorf("bar type %q configured twice", barType)
+		}
+		seen[barType] = true
+
+		if s.Threshold <= 0 {
+			return nil, fmt.Errorf("bar type %q needs a positive Threshold", barType)
+		}
+
+		spec := barSpec{
+			barType:   barType,
+			threshold: s.Threshold,
+			symbols:   make(map[string]float64, len(s.Symbols)),
+			adaptive:  s.Adaptive.Enabled,
+		}
+		for symbol, threshold := range s.Symbols {
+			if threshold <= 0 {
+				return nil, fmt.Errorf("bar type %q has a non-positive threshold for %s", barType, symbol)
+			}
+			spec.symbols[strings.ToUpper(symbol)] = threshold
+		}
+
+		if spec.adaptive {
+			if s.Adaptive.BarsPerDay <= 0 {
+				return nil, fmt.Errorf("bar type %q is adaptive but BarsPerDay is not set", barType)
+			}
+			halfLife := s.Adaptive.HalfLifeMinutes
+			if halfLife <= 0 {
+				halfLife = 60
+			}
+			spec.barsPerDay = float64(s.Adaptive.BarsPerDay)
+			spec.alpha = 1 - math.Pow(0.5, 1/float64(halfLife))
+		}
+
+		specs = append(specs, spec)
+	}
+
+	return specs, nil
+}
+
+// thresholdFor picks the threshold for a new bar: a per-symbol override, else the adaptive
+// estimate once it has warmed up, else the fixed default.
+func (s barSpec) thresholdFor(symbol string, state *barState) float64 {
+	if threshold, ok := s.symbols[strings.ToUpper(symbol)]; ok {
+		return threshold
+	}
+	if s.adaptive && state.Ewma > 0 {
+		return state.Ewma * minutesPerDay / s.barsPerDay
+	}
+	return s.threshold
+}
+
+// activity measures how much a tick moves the bar toward its threshold. Range and renko
+// bars adapt to the absolute price path travelled. Activity only drives thresholds, so it
+// does not need exact decimals.
+func (s barSpec) activity(tick TickData, previousPrice decimal.Decimal) float64 {
+	switch s.barType {
+	case BarVolume:
+		return tick.Volume.InexactFloat64()
+	case BarDollar:
+		return tick.QuoteVolume.InexactFloat64()
+	case BarTick:
+		return 1
+	default:
+		if previousPrice.IsZero() {
+			return 0
+		}
+		return tick.Price.Sub(previousPrice).Abs().InexactFloat64()
+	}
+}
+
+// trackActivity folds finished minutes into the EWMA, including empty ones.
+func (b *barState) trackActivity(spec barSpec, minute int64, activity float64) {
+	if !spec.adaptive {
+		return
+	}
+	if b.Minute == 0 {
+		b.Minute = minute
+	}
+	if minute > b.Minute {
+		if b.Ewma == 0 {
+			b.Ewma = b.MinuteActivity
+		} else {
+			b.Ewma = spec.alpha*b.MinuteActivity + (1-spec.alpha)*b.Ewma
+		}
+		if skipped := (minute-b.Minute)/baseIntervalMs - 1; skipped > 0 {
+			b.Ewma *= math.Pow(1-spec.alpha, float64(skipped))
+		}
+		b.Minute = minute
+		b.MinuteActivity = 0
+	}
+	b.MinuteActivity += activity
+}
+
+func (b *barState) start(tick TickData, threshold float64) {
+	b.Threshold = threshold
+	b.Open = tick.Price
+	b.High = tick.Price
+	b.Low = tick.Price
+	b.Close = tick.Price
+	b.Volume = decimal.Zero
+	b.Turnover = decimal.Zero
+	b.TickCount = 0
+	b.Progress = 0
+	b.OpenTime = tick.Time
+}
+
+func (b *barState) add(tick TickData) {
+	if tick.Price.GreaterThan(b.High) {
+		b.High = tick.Price
+	}
+	if tick.Price.LessThan(b.Low) {
+		b.Low = tick.Price
+	}
+	b.Close = tick.Price
+	b.Volume = b.Volume.Add(tick.Volume)
+	b.Turnover = b.Turnover.Add(tick.QuoteVolume)
+	b.TickCount++
+	b.CloseTime = tick.Time
+}
+
+func (b *barState) toBar(symbol, barType string) models.SymbolBarData {
+	return models.SymbolBarData{
+		Symbol:    symbol,
+		BarType:   barType,
+		Sequence:  b.Sequence,
+		Threshold: b.Threshold,
+		Open:      b.Open,
+		High:      b.High,
+		Low:       b.Low,
+		Close:     b.Close,
+		Volume:    b.Volume,
+		Turnover:  b.Turnover,
+		TickCount: b.TickCount,
+		OpenTime:  b.OpenTime,
+		CloseTime: b.CloseTime,
+	}
+}
+
+// applyBars feeds a tick to every configured bar type of a symbol and returns the bars it
+// completed. The symbol's shard lock must be held.
+func (a *KlineAggregator) applyBars(symbol string, state *symbolState, tick TickData, previousPrice decimal.Decimal) []models.SymbolBarData {
+	if len(a.barSpecs) == 0 {
+		return nil
+	}
+	if state.bars == nil {
+		state.bars = make(map[string]*barState, len(a.barSpecs))
+	}
+
+	var completed []models.SymbolBarData
+	minute := tick.Time - (tick.Time % baseIntervalMs)
+
+	for _, spec := range a.barSpecs {
+		bar, ok := state.bars[spec.barType]
+		if !ok {
+			bar = &barState{}
+			if a.barSequence != nil {
+				bar.Sequence = a.barSequence(symbol, spec.barType)
+			}
+			state.bars[spec.barType] = bar
+		}
+
+		activity := spec.activity(tick, previousPrice)
+		bar.trackActivity(spec, minute, activity)
+
+		if bar.TickCount == 0 {
+			bar.start(tick, spec.thresholdFor(symbol, bar))
+			if spec.barType == BarRenko && bar.Anchor.IsZero() {
+				bar.Anchor = tick.Price
+			}
+		}
+		bar.add(tick)
+
+		switch spec.barType {
+		case BarRange:
+			if bar.High.Sub(bar.Low).GreaterThanOrEqual(decimal.NewFromFloat(bar.Threshold)) {
+				completed = append(completed, bar.toBar(symbol, spec.barType))
+				bar.Sequence++
+				bar.TickCount = 0
+			}
+		case BarRenko:
+			completed = append(completed, renkoBricks(symbol, bar)...)
+		default:
+			bar.Progress += activity
+			if bar.Progress >= bar.Threshold {
+				completed = append(completed, bar.toBar(symbol, spec.barType))
+				bar.Sequence++
+				bar.TickCount = 0
+			}
+		}
+	}
+
+	return completed
+}
+
+// renkoBricks emits one brick per full threshold the price moved away from the last brick.
+// The first brick carries the accumulated volume; further bricks from the same tick carry none.
+func renkoBricks(symbol string, bar *barState) []models.SymbolBarData {
+	size := decimal.NewFromFloat(bar.Threshold)
+	moved := bar.Close.Sub(bar.Anchor)
+	count := int(moved.Abs().Div(size).IntPart())
+	if count == 0 {
+		return nil
+	}
+
+	if moved.IsNegative() {
+		size = size.Neg()
+	}
+
+	bricks := make([]models.SymbolBarData, 0, count)
+	for i := 0; i < count; i++ {
+		brick := bar.toBar(symbol, BarRenko)
+		brick.Open = bar.Anchor
+		brick.Close = bar.Anchor.Add(size)
+		brick.High = decimal.Max(brick.Open, brick.Close)
+		brick.Low = decimal.Min(brick.Open, brick.Close)
+		if i > 0 {
+			brick.Volume = decimal.Zero
+			brick.Turnover = decimal.Zero
+			brick.TickCount = 0
+		}
+		bricks = append(bricks, brick)
+
+		bar.Anchor = brick.Close
+		bar.Sequence++
+	}
+	bar.TickCount = 0
+
+	return bricks
+}
+
+// SeedBarSequences sets where the numbering of bar builders created from now on starts,
+// so bars stored by earlier runs keep their sequence. Builders restored from a snapshot
+// keep theirs. It must be called before ticks are applied.
+func (a *KlineAggregator) SeedBarSequences(next func(symbol, barType string) int64) {
+	a.barSequence = next
+}
+
+// ExtractBars returns the bars completed since the previous call.
+func (a *KlineAggregator) ExtractBars() []models.SymbolBarData {
+	a.barLock.Lock()
+	defer a.barLock.Unlock()
+
+	bars := a.completedBars
+	a.completedBars = nil
+	return bars
+}
@@ -0,0 +1,59 @@
+package aggregator
+
+import (
+	"testing"
+
+	"scanner.magictradebot.com/config"
+)
+
+func TestBarSequencesSurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.AggregatorSettings{
+		Intervals: []string{"1m"},
+		Bars:      []config.BarSettings{{Type: BarTick, Threshold: 3}},
+	}
+	stored := map[string]int64{"BTCUSDT": 5, "ETHUSDT": 7}
+	seed := func(symbol, barType string) int64 { return stored[symbol] }
+	start := testHour()
+
+	before := newTestAggregator(t, cfg)
+	before.SeedBarSequences(seed)
+	if _, err := before.OpenJournal(dir); err != nil {
+		t.Fatalf("OpenJournal: %v", err)
+	}
+	for i := int64(0); i < 5; i++ {
+		before.AddTick("BTCUSDT", tick("100", "1", start+i*1_000))
+	}
+	bars := before.ExtractBars()
+	if len(bars) != 1 || bars[0].Sequence != 5 {
+		t.Fatalf("bars before restart = %+v, want one with sequence 5", bars)
+	}
+	if err := before.CloseJournal(); err != nil {
+		t.Fatalf("CloseJournal: %v", err)
+	}
+
+	// The next run sees the bar stored, but the open one is restored from the snapshot
+	stored["BTCUSDT"] = 6
+	after := newTestAggregator(t, cfg)
+	after.SeedBarSequences(seed)
+	if _, err := after.OpenJournal(dir); err != nil {
+		t.Fatalf("OpenJournal after restart: %v", err)
+	}
+	defer func() { _ = after.CloseJournal() }()
+
+	after.AddTick("BTCUSDT", tick("101", "1", start+5_000))
+	for i := int64(0); i < 3; i++ {
+		after.AddTick("ETHUSDT", tick("10", "1", start+i*1_000))
+	}
+
+	got := make(map[string]int64)
+	for _, b := range after.ExtractBars() {
+		got[b.Symbol] = b.Sequence
+		if b.Symbol == "BTCUSDT" && (b.TickCount != 3 || b.OpenTime != start+3_000) {
+			t.Errorf("BTCUSDT bar did not continue the open one: %+v", b)
+		}
+	}
+	if got["BTCUSDT"] != 6 || got["ETHUSDT"] != 7 {
+		t.Errorf("sequences after restart = %v, want BTCUSDT 6 and ETHUSDT 7", got)
+	}
+}
@@ -0,0 +1,77 @@
+package aggregator
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"scanner.magictradebot.com/models"
+)
+
+// BatchStats collects per-cycle figures for one fetch/aggregate/flush round.
+type BatchStats struct {
+	Started       time.Time
+	FetchLatency  time.Duration
+	Requested     int
+	Fetched       int
+	Missing       int
+	ParseFailures int
+	Rejected      int
+	Applied       int
+	Candles       int
+	Filled        int
+	minSamples    int64
+	maxSamples    int64
+	totalSamples  int64
+	sampled       int
+}
+
+// NewBatchStats starts the stats for a cycle.
+func NewBatchStats() *BatchStats {
+	return &BatchStats{Started: time.Now()}
+}
+
+// RecordCandles tracks how many samples went into each extracted base candle.
+func (s *BatchStats) RecordCandles(klines []models.SymbolKlineData) {
+	for _, k := range klines {
+		s.Candles++
+		if k.FillPolicy != "" {
+			s.Filled++
+			continue
+		}
+		if k.Interval != BaseInterval {
+			continue
+		}
+		if s.sampled == 0 || k.SampleCount < s.minSamples {
+			s.minSamples = k.SampleCount
+		}
+		if k.SampleCount > s.maxSamples {
+			s.maxSamples = k.SampleCount
+		}
+		s.totalSamples += k.SampleCount
+		s.sampled++
+	}
+}
+
+// Log writes the cycle's stats as a single structured entry.
+func (s *BatchStats) Log(log *logrus.Logger) {
+	avgSamples := 0.0
+	if s.sampled > 0 {
+		avgSamples = float64(s.totalSamples) / float64(s.sampled)
+	}
+
+	log.WithFields(logrus.Fields{
+		"fetch_ms":       s.FetchLatency.Milliseconds(),
+		"cycle_ms":       time.Since(s.Started).Milliseconds(),
+		"requested":      s.Requested,
+		"fetched":        s.Fetched,
+		"missing":        s.Missing,
+		"parse_failures": s.ParseFailures,
+		"rejected":       s.Rejected,
+		"applied":        s.Applied,
+		"candles":        s.Candles,
+		"filled":         s.Filled,
+		"samples_min":    s.minSamples,
+		"samples_avg":    avgSamples,
+		"samples_max":    s.maxSamples,
+	}).Info("📈 Batch stats")
+}
@@ -0,0 +1,60 @@
+package aggregator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"scanner.magictradebot.com/models"
+)
+
+func TestBatchStatsSamplesOnlyBaseCandles(t *testing.T) {
+	s := NewBatchStats()
+	s.RecordCandles([]models.SymbolKlineData{
+		{Interval: BaseInterval, SampleCount: 4},
+		{Interval: BaseInterval, SampleCount: 12},
+		{Interval: BaseInterval, SampleCount: 8},
+		{Interval: BaseInterval, SampleCount: 0, FillPolicy: "carry"}, // a filled gap was never sampled
+		{Interval: "5m", SampleCount: 24},
+	})
+	s.Requested, s.Fetched, s.Missing = 3, 2, 1
+	s.FetchLatency = 250 * time.Millisecond
+
+	log, hook := test.NewNullLogger()
+	s.Log(log)
+
+	entry := hook.LastEntry()
+	if entry == nil || entry.Message != "📈 Batch stats" || entry.Level != logrus.InfoLevel {
+		t.Fatalf("logged %+v", entry)
+	}
+	want := logrus.Fields{
+		"fetch_ms":    int64(250),
+		"requested":   3,
+		"fetched":     2,
+		"missing":     1,
+		"candles":     5,
+		"filled":      1,
+		"samples_min": int64(4),
+		"samples_avg": 8.0,
+		"samples_max": int64(12), // the 5m candle is not a sample of the base interval
+	}
+	for field, value := range want {
+		if entry.Data[field] != value {
+			t.Errorf("%s = %v (%T), want %v (%T)", field, entry.Data[field], entry.Data[field], value, value)
+		}
+	}
+}
+
+func TestBatchStatsWithoutCandles(t *testing.T) {
+	log, hook := test.NewNullLogger()
+	NewBatchStats().Log(log)
+
+	entry := hook.LastEntry()
+	if entry == nil {
+		t.Fatal("nothing was logged")
+	}
+	if entry.Data["samples_avg"] != 0.0 || entry.Data["samples_min"] != int64(0) || entry.Data["candles"] != 0 {
+		t.Errorf("stats of an empty cycle = %v", entry.Data)
+	}
+}
@@ -0,0 +1,360 @@
+package aggregator
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+
+	"github.com/shopspring/decimal"
+	"scanner.magictradebot.com/models"
+)
+
+const shardCount = 64
+
+// candleState is the running OHLCV state of a single bucket.
+// Every tick updates it in O(1); only the sample times of base candles are kept, to drop
+// redelivered ticks.
+type candleState struct {
+	OpenTime   int64
+	Open       decimal.Decimal
+	High       decimal.Decimal
+	Low        decimal.Decimal
+	Close      decimal.Decimal
+	Volume     decimal.Decimal
+	Turnover   decimal.Decimal
+	TradeCount int64
+	FirstTick  int64
+	LastTick   int64
+	MaxGap     int64 // largest gap between consecutive samples seen so far
+	Amendments int
+
+	finalized  bool    // already emitted once
+	dirty      bool    // changed since it was emitted
+	incomplete bool    // opened before the collector started, never emitted
+	seen       []int64 // sorted sample times applied to a base candle, so replays are not counted twice
+}
+
+func newCandleState(openTime int64, tick TickData) *candleState {
+	return &candleState{
+		OpenTime:   openTime,
+		Open:       tick.Price,
+		High:       tick.Price,
+		Low:        tick.Price,
+		Close:      tick.Price,
+		Volume:     tick.Volume,
+		Turnover:   tick.QuoteVolume,
+		TradeCount: 1,
+		FirstTick:  tick.Time,
+		LastTick:   tick.Time,
+		seen:       []int64{tick.Time},
+	}
+}
+
+// newRollupState starts a higher-interval bucket from a finalized base candle.
+func newRollupState(openTime int64, base candleState) *candleState {
+	return &candleState{
+		OpenTime:   openTime,
+		Open:       base.Open,
+		High:       base.High,
+		Low:        base.Low,
+		Close:      base.Close,
+		Volume:     base.Volume,
+		Turnover:   base.Turnover,
+		TradeCount: base.TradeCount,
+		FirstTick:  base.FirstTick,
+		LastTick:   base.LastTick,
+		MaxGap:     base.MaxGap,
+	}
+}
+
+// update applies a tick. Ticks may arrive out of order, so open and close follow tick time.
+// A tick landing between existing samples cannot be placed without history, so it leaves
+// the recorded gap unchanged.
+func (c *candleState) update(tick TickData) {
+	c.trackGap(tick.Time, tick.Time, 0)
+	if tick.Price.GreaterThan(c.High) {
+		c.High = tick.Price
+	}
+	if tick.Price.LessThan(c.Low) {
+		c.Low = tick.Price
+	}
+	if tick.Time < c.FirstTick {
+		c.Open = tick.Price
+		c.FirstTick = tick.Time
+	}
+	if tick.Time >= c.LastTick {
+		c.Close = tick.Price
+		c.LastTick = tick.Time
+	}
+	c.Volume = c.Volume.Add(tick.Volume)
+	c.Turnover = c.Turnover.Add(tick.QuoteVolume)
+	c.TradeCount++
+	if c.finalized {
+		c.dirty = true
+	}
+}
+
+// markSeen records a sample time and reports false when it was already recorded.
+// Ticks mostly arrive in order, so the time is usually appended.
+func (c *candleState) markSeen(at int64) bool {
+	n := len(c.seen)
+	if n == 0 || at > c.seen[n-1] {
+		c.seen = append(c.seen, at)
+		return true
+	}
+	i := sort.Search(n, func(i int) bool { return c.seen[i] >= at })
+	if c.seen[i] == at {
+		return false
+	}
+	c.seen = append(c.seen, 0)
+	copy(c.seen[i+1:], c.seen[i:])
+	c.seen[i] = at
+	return true
+}
+
+// merge folds a finalized base candle into a rollup bucket.
+// Base candles may be merged in any order, so open and close follow their tick times.
+func (c *candleState) merge(base candleState) {
+	c.trackGap(base.FirstTick, base.LastTick, base.MaxGap)
+	if base.High.GreaterThan(c.High) {
+		c.High = base.High
+	}
+	if base.Low.LessThan(c.Low) {
+		c.Low = base.Low
+	}
+	if base.FirstTick < c.FirstTick {
+		c.Open = base.Open
+		c.FirstTick = base.FirstTick
+	}
+	if base.LastTick >= c.LastTick {
+		c.Close = base.Close
+		c.LastTick = base.LastTick
+	}
+	c.Volume = c.Volume.Add(base.Volume)
+	c.Turnover = c.Turnover.Add(base.Turnover)
+	c.TradeCount += base.TradeCount
+	if c.finalized {
+		c.dirty = true
+	}
+}
+
+// trackGap widens MaxGap for samples spanning [first, last] that are about to be added.
+// It must run before FirstTick and LastTick are moved.
+func (c *candleState) trackGap(first, last, innerGap int64) {
+	gap := innerGap
+	if first >= c.LastTick && first-c.LastTick > gap {
+		gap = first - c.LastTick
+	}
+	if last <= c.FirstTick && c.FirstTick-last > gap {
+		gap = c.FirstTick - last
+	}
+	if gap > c.MaxGap {
+		c.MaxGap = gap
+	}
+}
+
+// flush reports whether the bucket should be emitted now and whether it is an amendment.
+// A finalized bucket is only emitted again after it changed.
+func (c *candleState) flush() (emit bool, amended bool) {
+	if !c.finalized {
+		c.finalized = true
+		return !c.incomplete, false
+	}
+	if !c.dirty {
+		return false, false
+	}
+	c.dirty = false
+	if c.incomplete {
+		return false, false
+	}
+	c.Amendments++
+	return true, true
+}
+
+// toKline builds the stored candle with its quality metadata. The gap before the first
+// and after the last sample counts toward MaxGapMs, and any gap above partialGap marks
+// the candle as partial.
+func (c *candleState) toKline(symbol, interval string, b boundary, partialGap int64) models.SymbolKlineData {
+	closeTime := b.next(c.OpenTime)
+	intervalMs := closeTime - c.OpenTime
+
+	maxGap := c.MaxGap
+	if lead := c.FirstTick - c.OpenTime; lead > maxGap {
+		maxGap = lead
+	}
+	if trail := closeTime - c.LastTick; trail > maxGap {
+		maxGap = trail
+	}
+
+	typical := typicalPrice(c.High, c.Low, c.Close)
+	vwap := typical
+	if c.Volume.IsPositive() {
+		vwap = c.Turnover.DivRound(c.Volume, derivedScale)
+	}
+
+	coverage := float64(c.LastTick-c.FirstTick) / float64(intervalMs)
+	if coverage > 1 {
+		coverage = 1
+	}
+
+	return models.SymbolKlineData{
+		Symbol:        symbol,
+		Interval:      interval,
+		Open:          c.Open,
+		High:          c.High,
+		Low:           c.Low,
+		Close:         c.Close,
+		OpenTime:      c.OpenTime,
+		Volume:        c.Volume,
+		Turnover:      c.Turnover,
+		VWAP:          vwap,
+		TypicalPrice:  typical,
+		TradeCount:    c.TradeCount,
+		Amendments:    c.Amendments,
+		SampleCount:   c.TradeCount,
+		FirstSampleAt: c.FirstTick,
+		LastSampleAt:  c.LastTick,
+		Coverage:      coverage,
+		MaxGapMs:      maxGap,
+		Partial:       maxGap > partialGap,
+		Alignment:     b.label,
+		Source:        models.SourceAggregated,
+	}
+}
+
+// derivedScale is the number of decimals kept for prices derived by division. It exceeds any
+// exchange tick size, so the storage column's own rounding is the one that applies.
+const derivedScale = 18
+
+var three = decimal.NewFromInt(3)
+
+// typicalPrice is (high + low + close) / 3.
+func typicalPrice(high, low, close decimal.Decimal) decimal.Decimal {
+	return high.Add(low).Add(close).DivRound(three, derivedScale)
+}
+
+// symbolState holds the base buckets of one symbol keyed by bucket start,
+// plus the last ticker values seen for it and its open information bars.
+type symbolState struct {
+	buckets        map[int64]*candleState
+	volume24h      decimal.Decimal // baselines for turning rolling 24h volumes into per-tick volumes
+	quoteVolume24h decimal.Decimal
+	lastPrice      decimal.Decimal
+	lastSeen       int64
+	bars           map[string]*barState    // bar type -> open bar
+	seconds        map[string]*candleState // sub-minute interval -> open bar
+	secondsClosed  map[string]int64        // sub-minute interval -> open time of the last closed bar
+}
+
+// stateShard guards a subset of symbols so updates for different symbols rarely contend.
+type stateShard struct {
+	lock    sync.Mutex
+	symbols map[string]*symbolState
+}
+
+type shardedState struct {
+	shards [shardCount]*stateShard
+}
+
+type applyResult int
+
+const (
+	tickApplied   applyResult = iota
+	tickDuplicate             // a sample with the same timestamp was already applied
+	tickAmended               // applied to a candle that was already emitted
+	tickTooLate               // bucket closed beyond the allowed lateness
+)
+
+func newShardedState() *shardedState {
+	s := &shardedState{}
+	for i := range s.shards {
+		s.shards[i] = &stateShard{symbols: make(map[string]*symbolState)}
+	}
+	return s
+}
+
+func (s *shardedState) shardFor(symbol string) *stateShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(symbol))
+	return s.shards[h.Sum32()%shardCount]
+}
+
+// symbol returns the state of a symbol, creating it on first use. The shard lock must be held.
+func (s *stateShard) symbol(symbol string) *symbolState {
+	state, ok := s.symbols[symbol]
+	if !ok {
+		state = &symbolState{buckets: make(map[int64]*candleState)}
+		s.symbols[symbol] = state
+	}
+	return state
+}
+
+// rollingDelta converts a rolling 24h total into the amount traded since the previous ticker
+// and moves the baseline. The first ticker only sets the baseline, and a shrinking window
+// never yields negative volume. The shard lock must be held.
+func rollingDelta(baseline *decimal.Decimal, total decimal.Decimal) decimal.Decimal {
+	previous := *baseline
+	*baseline = total
+	if previous.IsZero() || total.LessThanOrEqual(previous) {
+		return decimal.Zero
+	}
+	return total.Sub(previous)
+}
+
+// apply adds a tick to the bucket starting at openTime, creating it on first use.
+// Buckets that closed at or before the watermark no longer accept ticks.
+// The shard lock must be held.
+func (s *symbolState) apply(openTime int64, tick TickData, watermark int64) applyResult {
+	result := tickApplied
+
+	candle, ok := s.buckets[openTime]
+	if !ok {
+		if openTime+baseIntervalMs <= watermark {
+			return tickTooLate
+		}
+		s.buckets[openTime] = newCandleState(openTime, tick)
+	} else {
+		if candle.seen == nil {
+			// Restored from a snapshot that predates the sample times
+			candle.seen = []int64{candle.FirstTick}
+			candle.markSeen(candle.LastTick)
+		}
+		if !candle.markSeen(tick.Time) {
+			return tickDuplicate
+		}
+		candle.update(tick)
+		if candle.finalized {
+			result = tickAmended
+		}
+	}
+
+	if tick.Time >= s.lastSeen {
+		s.lastSeen = tick.Time
+		s.lastPrice = tick.Price
+	}
+	return result
+}
+
+// flushClosed visits every bucket that closed at or before now and needs emitting.
+// The callback runs under the shard lock, so it must copy the candle to keep it.
+// Buckets that closed at or before the watermark can no longer be amended and are dropped.
+func (s *shardedState) flushClosed(now, watermark int64, fn func(symbol string, candle *candleState, amended bool)) {
+	for _, shard := range s.shards {
+		shard.lock.Lock()
+		for symbol, state := range shard.symbols {
+			for openTime, candle := range state.buckets {
+				closeTime := openTime + baseIntervalMs
+				if closeTime > now {
+					continue
+				}
+				if emit, amended := candle.flush(); emit {
+					fn(symbol, candle, amended)
+				}
+				if closeTime <= watermark {
+					delete(state.buckets, openTime)
+				}
+			}
+		}
+		shard.lock.Unlock()
+	}
+}
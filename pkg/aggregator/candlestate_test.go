@@ -0,0 +1,91 @@
+package aggregator
+
+import (
+	"testing"
+
+	"scanner.magictradebot.com/config"
+)
+
+func TestReplayedTicksAreNotCountedTwice(t *testing.T) {
+	agg := newTestAggregator(t, config.AggregatorSettings{Intervals: []string{"1m"}})
+	start := testHour()
+
+	agg.AddTick("BTCUSDT", tick("100", "1", start))
+	agg.AddTick("BTCUSDT", tick("102", "1", start+20_000))
+	agg.AddTick("BTCUSDT", tick("105", "1", start+10_000))
+	// Redelivered: the first matches an earlier sample, not the latest one
+	agg.AddTick("BTCUSDT", tick("105", "1", start+10_000))
+	agg.AddTick("BTCUSDT", tick("100", "1", start))
+
+	k, ok := byInterval(agg.ExtractOhlc("1m"))["1m"]["BTCUSDT"][start]
+	if !ok {
+		t.Fatal("no candle emitted")
+	}
+	assertDecimal(t, "volume", k.Volume, "3")
+	if k.TradeCount != 3 {
+		t.Errorf("trade count = %d, want 3", k.TradeCount)
+	}
+
+	// A replay after the candle was emitted must not amend it either
+	agg.AddTick("BTCUSDT", tick("102", "1", start+20_000))
+	if got := agg.ExtractOhlc("1m"); len(got) != 0 {
+		t.Errorf("replayed tick re-emitted %d candles", len(got))
+	}
+}
+
+func TestLateTicks(t *testing.T) {
+	tests := []struct {
+		name          string
+		lateness      int
+		wantAmended   bool
+		wantDropped   int64
+		wantVolume    string
+		wantRollupVol string
+	}{
+		{name: "inside the watermark amends", lateness: 7200, wantAmended: true, wantVolume: "3", wantRollupVol: "3"},
+		{name: "outside the watermark is dropped", lateness: 60, wantDropped: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agg := newTestAggregator(t, config.AggregatorSettings{
+				Intervals:              []string{"1m", "5m"},
+				AllowedLatenessSeconds: tt.lateness,
+			})
+			start := testHour()
+
+			// Seed the candle directly so it exists regardless of the watermark
+			agg.state.shardFor("BTCUSDT").symbol("BTCUSDT").buckets[start] = newCandleState(start, tick("100", "1", start))
+			agg.AddTick("BTCUSDT", tick("101", "1", start+30_000))
+			first := byInterval(agg.ExtractOhlc("1m", "5m"))
+			if _, ok := first["1m"]["BTCUSDT"][start]; !ok {
+				t.Fatal("candle was not emitted")
+			}
+
+			agg.AddTick("BTCUSDT", tick("99", "1", start+45_000))
+			if got := agg.DroppedLateTicks(); got != tt.wantDropped {
+				t.Errorf("dropped late ticks = %d, want %d", got, tt.wantDropped)
+			}
+
+			second := byInterval(agg.ExtractOhlc("1m", "5m"))
+			k, amended := second["1m"]["BTCUSDT"][start]
+			if amended != tt.wantAmended {
+				t.Fatalf("amended = %v, want %v", amended, tt.wantAmended)
+			}
+			if !amended {
+				return
+			}
+			if k.Amendments != 1 {
+				t.Errorf("amendments = %d, want 1", k.Amendments)
+			}
+			assertDecimal(t, "volume", k.Volume, tt.wantVolume)
+			assertDecimal(t, "low", k.Low, "99")
+
+			rollup, ok := second["5m"]["BTCUSDT"][start]
+			if !ok {
+				t.Fatal("5m candle was not amended")
+			}
+			assertDecimal(t, "5m volume", rollup.Volume, tt.wantRollupVol)
+			assertDecimal(t, "5m low", rollup.Low, "99")
+		})
+	}
+}
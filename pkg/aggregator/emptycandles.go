@@ -0,0 +1,122 @@
+package aggregator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	"scanner.magictradebot.com/models"
+)
+
+// Empty-candle policies for buckets in which a symbol received no ticks.
+const (
+	FillSkip      = "skip"      // write nothing
+	FillFlat      = "flat"      // carry the last close forward with zero volume
+	FillSynthetic = "synthetic" // like flat, but the row is flagged as synthetic
+)
+
+// fillState remembers the latest real candle of each symbol for one interval
+// and which buckets were filled, so a late real candle can replace its fill.
+type fillState struct {
+	policy    string
+	lastClose map[string]decimal.Decimal
+	lastOpen  map[string]int64
+	filled    map[string]map[int64]bool
+}
+
+func parseFillPolicies(policies map[string]string, intervals map[string]int64) (map[string]*fillState, error) {
+	result := make(map[string]*fillState)
+	for interval, policy := range policies {
+		if _, ok := intervals[interval]; !ok {
+			return nil, fmt.Errorf("empty-candle policy set for unconfigured interval %q", interval)
+		}
+
+		policy = strings.ToLower(strings.TrimSpace(policy))
+		switch policy {
+		case "", FillSkip:
+			continue
+		case FillFlat, FillSynthetic:
+			result[interval] = &fillState{
+				policy:    policy,
+				lastClose: make(map[string]decimal.Decimal),
+				lastOpen:  make(map[string]int64),
+				filled:    make(map[string]map[int64]bool),
+			}
+		default:
+			return nil, fmt.Errorf("unknown empty-candle policy %q for %s (use skip, flat or synthetic)", policy, interval)
+		}
+	}
+	return result, nil
+}
+
+// recordReal tracks an emitted real candle and reports whether it replaces a filled bucket.
+func (f *fillState) recordReal(symbol string, openTime int64, close decimal.Decimal) bool {
+	if last, ok := f.lastOpen[symbol]; !ok || openTime >= last {
+		f.lastOpen[symbol] = openTime
+		f.lastClose[symbol] = close
+	}
+
+	if f.filled[symbol][openTime] {
+		delete(f.filled[symbol], openTime)
+		return true
+	}
+	return false
+}
+
+// fill emits a candle for every bucket in [from, to) that follows a symbol's latest real
+// candle and has none of its own.
+func (f *fillState) fill(interval string, b boundary, from, to int64) []models.SymbolKlineData {
+	var result []models.SymbolKlineData
+
+	for symbol, lastOpen := range f.lastOpen {
+		start := b.next(lastOpen)
+		if start < from {
+			start = from
+		}
+		close := f.lastClose[symbol]
+
+		for openTime, closeTime := start, b.next(start); closeTime <= to; openTime, closeTime = closeTime, b.next(closeTime) {
+			if f.filled[symbol] == nil {
+				f.filled[symbol] = make(map[int64]bool)
+			}
+			if f.filled[symbol][openTime] {
+				continue
+			}
+			f.filled[symbol][openTime] = true
+
+			result = append(result, models.SymbolKlineData{
+				Symbol:       symbol,
+				Interval:     interval,
+				Open:         close,
+				High:         close,
+				Low:          close,
+				Close:        close,
+				OpenTime:     openTime,
+				VWAP:         close,
+				TypicalPrice: close,
+				MaxGapMs:     closeTime - openTime,
+				Partial:      true,
+				Alignment:    b.label,
+				Source:       models.SourceAggregated,
+				FillPolicy:   f.policy,
+				Synthetic:    f.policy == FillSynthetic,
+			})
+		}
+	}
+
+	return result
+}
+
+// forget drops filled buckets that closed at or before the watermark; they can no longer be replaced.
+func (f *fillState) forget(b boundary, watermark int64) {
+	for symbol, buckets := range f.filled {
+		for openTime := range buckets {
+			if b.next(openTime) <= watermark {
+				delete(buckets, openTime)
+			}
+		}
+		if len(buckets) == 0 {
+			delete(f.filled, symbol)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package aggregator
+
+import (
+	"testing"
+
+	"scanner.magictradebot.com/config"
+)
+
+func TestEmptyCandlePolicy(t *testing.T) {
+	tests := []struct {
+		policy        string
+		wantFill      bool
+		wantSynthetic bool
+	}{
+		{policy: FillSkip},
+		{policy: FillFlat, wantFill: true},
+		{policy: FillSynthetic, wantFill: true, wantSynthetic: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			agg := newTestAggregator(t, config.AggregatorSettings{
+				Intervals:         []string{"1m"},
+				EmptyCandlePolicy: map[string]string{"1m": tt.policy},
+			})
+			start := testHour()
+			// Pretend the minutes before the test hour were already flushed
+			agg.lastFlushed[BaseInterval] = start
+
+			// Only the first minute has ticks; every later closed minute is empty
+			agg.AddTick("BTCUSDT", tick("100", "1", start))
+			agg.AddTick("BTCUSDT", tick("104", "1", start+30_000))
+
+			got := byInterval(agg.ExtractOhlc("1m"))["1m"]["BTCUSDT"]
+			if k := got[start]; k.FillPolicy != "" || k.TradeCount != 2 {
+				t.Errorf("first candle is not the sampled one: %+v", k)
+			}
+
+			for _, openTime := range []int64{start + 60_000, start + 2*60_000} {
+				gap, filled := got[openTime]
+				if filled != tt.wantFill {
+					t.Fatalf("bucket at %d filled = %v, want %v", openTime, filled, tt.wantFill)
+				}
+				if !filled {
+					continue
+				}
+				for name, price := range map[string]string{"open": gap.Open.String(), "high": gap.High.String(), "low": gap.Low.String(), "close": gap.Close.String()} {
+					if price != "104" {
+						t.Errorf("fill %s = %s, want the previous close 104", name, price)
+					}
+				}
+				assertDecimal(t, "fill volume", gap.Volume, "0")
+				if gap.FillPolicy != tt.policy || gap.Synthetic != tt.wantSynthetic || gap.TradeCount != 0 {
+					t.Errorf("fill policy = %q synthetic = %v trades = %d", gap.FillPolicy, gap.Synthetic, gap.TradeCount)
+				}
+			}
+			if !tt.wantFill {
+				if len(got) != 1 {
+					t.Errorf("got %d candles, want only the sampled one", len(got))
+				}
+				return
+			}
+
+			// A late tick inside the watermark replaces the fill
+			agg.AddTick("BTCUSDT", tick("101", "2", start+60_000+5_000))
+			real, ok := byInterval(agg.ExtractOhlc("1m"))["1m"]["BTCUSDT"][start+60_000]
+			if !ok {
+				t.Fatal("late candle did not replace the fill")
+			}
+			if real.FillPolicy != "" || real.Synthetic || real.Amendments != 1 {
+				t.Errorf("replacement = policy %q synthetic %v amendments %d", real.FillPolicy, real.Synthetic, real.Amendments)
+			}
+			assertDecimal(t, "replacement volume", real.Volume, "2")
+		})
+	}
+}
+
+func TestEmptyCandlePolicyRejectsUnknown(t *testing.T) {
+	cfg := config.AggregatorSettings{
+		Intervals:         []string{"1m"},
+		EmptyCandlePolicy: map[string]string{"1m": "zero"},
+	}
+	if _, err := NewKlineAggregator(nil, false, cfg); err == nil {
+		t.Error("unknown policy was accepted")
+	}
+}
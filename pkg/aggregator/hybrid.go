@@ -0,0 +1,209 @@
+package aggregator
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/models"
+)
+
+// officialKlineLimit caps how many candles one request asks for.
+const officialKlineLimit = 100
+
+// OfficialKlineRequest asks for the exchange's own candles of one symbol and interval
+// opening within [From, To] (unix ms).
+type OfficialKlineRequest struct {
+	Symbol   string
+	Interval string
+	From     int64
+	To       int64
+}
+
+// pendingOfficial is the range of closed candles still waiting for their official counterpart.
+type pendingOfficial struct {
+	symbol   string
+	from     int64
+	to       int64
+	readyAt  int64 // close time of the latest candle plus the fetch delay
+	attempts int
+}
+
+// OfficialKlineQueue tracks closed candles whose exchange-published version has not been
+// stored yet. Symbols are visited in SymbolRotator batches so each cycle makes a bounded
+// number of requests.
+type OfficialKlineQueue struct {
+	lock        sync.Mutex
+	rotator     *SymbolRotator
+	boundaries  map[string]boundary
+	delay       int64
+	maxAttempts int
+	pending     map[string]map[string]*pendingOfficial // upper-cased symbol -> interval
+}
+
+// NewOfficialKlineQueue builds a queue for the given symbols. Intervals default to all
+// configured intervals of a minute or longer.
+func NewOfficialKlineQueue(symbols []string, cfg config.HybridSettings, defaultIntervals []string) (*OfficialKlineQueue, error) {
+	labels := cfg.Intervals
+	explicit := len(labels) > 0
+	if !explicit {
+		labels = defaultIntervals
+	}
+
+	intervals := make(map[string]int64, len(labels))
+	for _, label := range labels {
+		ms, err := ParseInterval(label)
+		if err != nil {
+			return nil, err
+		}
+		if ms < baseIntervalMs {
+			if explicit {
+				return nil, fmt.Errorf("exchanges publish no %s klines", label)
+			}
+			continue
+		}
+		intervals[label] = ms
+	}
+	boundaries, err := parseBoundaries(intervals, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = 20
+	}
+	delay := cfg.DelaySeconds
+	if delay <= 0 {
+		delay = 5
+	}
+	maxAttempts := cfg.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 5
+	}
+
+	return &OfficialKlineQueue{
+		rotator:     NewSymbolRotator(symbols, batchSize),
+		boundaries:  boundaries,
+		delay:       int64(delay) * 1000,
+		maxAttempts: maxAttempts,
+		pending:     make(map[string]map[string]*pendingOfficial),
+	}, nil
+}
+
+// Enqueue records emitted candles that need their official version. Candles on custom
+// alignments are skipped because exchanges only publish UTC-aligned klines.
+func (q *OfficialKlineQueue) Enqueue(klines []models.SymbolKlineData) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for _, k := range klines {
+		b, ok := q.boundaries[k.Interval]
+		if !ok || k.Source == models.SourceExchange || (k.Alignment != "" && k.Alignment != DefaultAlignment) {
+			continue
+		}
+
+		key := strings.ToUpper(k.Symbol)
+		byInterval, ok := q.pending[key]
+		if !ok {
+			byInterval = make(map[string]*pendingOfficial)
+			q.pending[key] = byInterval
+		}
+
+		readyAt := b.next(k.OpenTime) + q.delay
+		p, ok := byInterval[k.Interval]
+		if !ok {
+			byInterval[k.Interval] = &pendingOfficial{symbol: k.Symbol, from: k.OpenTime, to: k.OpenTime, readyAt: readyAt}
+			continue
+		}
+		if k.OpenTime < p.from {
+			p.from = k.OpenTime
+		}
+		if k.OpenTime > p.to {
+			p.to = k.OpenTime
+		}
+		if readyAt > p.readyAt {
+			p.readyAt = readyAt
+		}
+	}
+}
+
+// Due returns the requests for the next batch of symbols whose pending candles have closed
+// long enough ago. A long backlog is split so each request stays within the page limit.
+func (q *OfficialKlineQueue) Due(nowMs int64) []OfficialKlineRequest {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	var requests []OfficialKlineRequest
+	for _, symbol := range q.rotator.NextBatch() {
+		for interval, p := range q.pending[strings.ToUpper(symbol)] {
+			if p.readyAt > nowMs {
+				continue
+			}
+			to := p.from
+			b := q.boundaries[interval]
+			for i := 1; i < officialKlineLimit && to < p.to; i++ {
+				to = b.next(to)
+			}
+			requests = append(requests, OfficialKlineRequest{
+				Symbol:   p.symbol,
+				Interval: interval,
+				From:     p.from,
+				To:       to,
+			})
+		}
+	}
+	return requests
+}
+
+// Done removes the range a request covered, whether or not the exchange returned every candle.
+func (q *OfficialKlineQueue) Done(req OfficialKlineRequest) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	key := strings.ToUpper(req.Symbol)
+	p, ok := q.pending[key][req.Interval]
+	if !ok {
+		return
+	}
+	if req.To >= p.to {
+		delete(q.pending[key], req.Interval)
+		if len(q.pending[key]) == 0 {
+			delete(q.pending, key)
+		}
+		return
+	}
+	p.from = q.boundaries[req.Interval].next(req.To)
+	p.attempts = 0
+}
+
+// Failed counts a failed request and reports whether its range was given up.
+func (q *OfficialKlineQueue) Failed(req OfficialKlineRequest) bool {
+	q.lock.Lock()
+	p, ok := q.pending[strings.ToUpper(req.Symbol)][req.Interval]
+	if !ok {
+		q.lock.Unlock()
+		return false
+	}
+	p.attempts++
+	giveUp := p.attempts >= q.maxAttempts
+	q.lock.Unlock()
+
+	if giveUp {
+		q.Done(req)
+	}
+	return giveUp
+}
+
+// Pending returns the number of symbol and interval ranges still waiting.
+func (q *OfficialKlineQueue) Pending() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	count := 0
+	for _, byInterval := range q.pending {
+		count += len(byInterval)
+	}
+	return count
+}
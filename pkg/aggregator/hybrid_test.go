@@ -0,0 +1,119 @@
+package aggregator
+
+import (
+	"testing"
+
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/models"
+)
+
+func emitted(symbol, interval string, from, count, stepMs int64) []models.SymbolKlineData {
+	klines := make([]models.SymbolKlineData, count)
+	for i := range klines {
+		klines[i] = models.SymbolKlineData{Symbol: symbol, Interval: interval, OpenTime: from + int64(i)*stepMs, Source: models.SourceAggregated}
+	}
+	return klines
+}
+
+func TestOfficialKlineQueuePagesTheBacklog(t *testing.T) {
+	q, err := NewOfficialKlineQueue([]string{"BTCUSDT"}, config.HybridSettings{DelaySeconds: 5}, []string{"1s", "1m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Enqueue(emitted("BTCUSDT", "1m", 0, 250, 60_000))
+	lastClose := int64(250 * 60_000)
+
+	if got := q.Due(lastClose + 4_999); len(got) != 0 {
+		t.Fatalf("requested %+v before the fetch delay passed", got)
+	}
+
+	var pages []OfficialKlineRequest
+	for len(pages) < 5 {
+		due := q.Due(lastClose + 5_000)
+		if len(due) == 0 {
+			break
+		}
+		if len(due) != 1 {
+			t.Fatalf("due = %+v, want one request per symbol and interval", due)
+		}
+		pages = append(pages, due[0])
+		q.Done(due[0])
+	}
+
+	want := []OfficialKlineRequest{
+		{Symbol: "BTCUSDT", Interval: "1m", From: 0, To: 99 * 60_000},
+		{Symbol: "BTCUSDT", Interval: "1m", From: 100 * 60_000, To: 199 * 60_000},
+		{Symbol: "BTCUSDT", Interval: "1m", From: 200 * 60_000, To: 249 * 60_000},
+	}
+	if len(pages) != len(want) {
+		t.Fatalf("pages = %+v, want %+v", pages, want)
+	}
+	for i := range want {
+		if pages[i] != want[i] {
+			t.Errorf("page %d = %+v, want %+v", i, pages[i], want[i])
+		}
+	}
+	if n := q.Pending(); n != 0 {
+		t.Errorf("%d ranges pending after every page was done", n)
+	}
+}
+
+func TestOfficialKlineQueueGivesUpAfterMaxAttempts(t *testing.T) {
+	q, err := NewOfficialKlineQueue([]string{"BTCUSDT"}, config.HybridSettings{Intervals: []string{"1m"}, MaxAttempts: 3}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Enqueue(emitted("BTCUSDT", "1m", 0, 150, 60_000))
+	now := int64(24 * 60 * 60_000)
+
+	first := q.Due(now)[0]
+	if q.Failed(first) || q.Failed(first) {
+		t.Fatal("gave up before the last attempt")
+	}
+	// A page that succeeds resets the count for the rest of the range
+	q.Done(first)
+	second := q.Due(now)[0]
+	if second.From != 100*60_000 {
+		t.Fatalf("second page starts at %d, want where the first one ended", second.From)
+	}
+	if q.Failed(second) || q.Failed(second) {
+		t.Fatal("attempts of the previous page were counted against this one")
+	}
+	if !q.Failed(second) {
+		t.Fatal("did not give up after the third failed attempt")
+	}
+	if n := q.Pending(); n != 0 {
+		t.Errorf("%d ranges pending after giving up", n)
+	}
+	if q.Failed(second) {
+		t.Error("gave up a range that is no longer pending")
+	}
+}
+
+func TestOfficialKlineQueueSkipsWhatExchangesDoNotPublish(t *testing.T) {
+	if _, err := NewOfficialKlineQueue(nil, config.HybridSettings{Intervals: []string{"5s"}}, nil); err == nil {
+		t.Error("accepted sub-minute official klines")
+	}
+
+	q, err := NewOfficialKlineQueue([]string{"BTCUSDT", "ethusdt"}, config.HybridSettings{}, []string{"5s", "1m", "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	official := emitted("BTCUSDT", "1m", 0, 1, 60_000)
+	official[0].Source = models.SourceExchange
+	shifted := emitted("BTCUSDT", "1h", 0, 1, 3_600_000)
+	shifted[0].Alignment = "Asia/Tokyo"
+	q.Enqueue(official)
+	q.Enqueue(shifted)
+	q.Enqueue(emitted("BTCUSDT", "5s", 0, 1, 5_000))
+	if n := q.Pending(); n != 0 {
+		t.Fatalf("%d ranges pending for candles exchanges do not publish", n)
+	}
+
+	// Candles are matched to the configured symbol whatever its case
+	q.Enqueue(emitted("ETHUSDT", "1h", 0, 2, 3_600_000))
+	got := q.Due(24 * 3_600_000)
+	if len(got) != 1 || got[0].Symbol != "ETHUSDT" || got[0].From != 0 || got[0].To != 3_600_000 {
+		t.Errorf("due = %+v", got)
+	}
+}
@@ -0,0 +1,105 @@
+package aggregator
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BaseInterval is the only interval built directly from ticks.
+// Every higher interval is rolled up from finalized base candles.
+const BaseInterval = "1m"
+
+const baseIntervalMs int64 = 60_000
+
+// ParseInterval converts an interval label such as "5s", "5m", "4h", "1d", "1w" or "1M" to
+// milliseconds. Months have no fixed length and count as 30 days.
+func ParseInterval(label string) (int64, error) {
+	label = strings.TrimSpace(label)
+	if len(label) < 2 {
+		return 0, fmt.Errorf("invalid interval %q", label)
+	}
+
+	n, err := strconv.Atoi(label[:len(label)-1])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid interval %q", label)
+	}
+
+	var unit int64
+	switch label[len(label)-1] {
+	case 's':
+		unit = 1_000
+	case 'm':
+		unit = 60_000
+	case 'h':
+		unit = 3_600_000
+	case 'd':
+		unit = 86_400_000
+	case 'w':
+		unit = 7 * 86_400_000
+	case 'M':
+		unit = 30 * 86_400_000
+	default:
+		return 0, fmt.Errorf("invalid interval unit in %q (use s, m, h, d, w or M)", label)
+	}
+
+	return int64(n) * unit, nil
+}
+
+// parseIntervals validates the configured interval labels and always includes the base interval.
+// Sub-minute intervals must divide a minute evenly; they are built from ticks, not rolled up.
+// Two labels of the same length, such as "60m" and "1h", would build the same series twice
+// and are rejected.
+func parseIntervals(labels []string) (map[string]int64, error) {
+	result := map[string]int64{BaseInterval: baseIntervalMs}
+	byLength := map[int64]string{baseIntervalMs: BaseInterval}
+
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		ms, err := ParseInterval(label)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := byLength[ms]; ok && other != label {
+			return nil, fmt.Errorf("intervals %q and %q have the same length; configure only one", other, label)
+		}
+		byLength[ms] = label
+		if ms < baseIntervalMs {
+			if baseIntervalMs%ms != 0 {
+				return nil, fmt.Errorf("sub-minute interval %q does not divide %s evenly", label, BaseInterval)
+			}
+		} else if ms%baseIntervalMs != 0 {
+			return nil, fmt.Errorf("interval %q is not a multiple of %s", label, BaseInterval)
+		}
+		result[label] = ms
+	}
+
+	return result, nil
+}
+
+// sortedIntervals returns interval labels ordered from shortest to longest.
+func sortedIntervals(intervals map[string]int64) []string {
+	labels := make([]string, 0, len(intervals))
+	for label := range intervals {
+		labels = append(labels, label)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return intervals[labels[i]] < intervals[labels[j]]
+	})
+	return labels
+}
+
+// splitSubMinute separates intervals shorter than the base interval from the rest.
+func splitSubMinute(intervals map[string]int64) (minutes, seconds map[string]int64) {
+	minutes = make(map[string]int64)
+	seconds = make(map[string]int64)
+	for label, ms := range intervals {
+		if ms < baseIntervalMs {
+			seconds[label] = ms
+		} else {
+			minutes[label] = ms
+		}
+	}
+	return minutes, seconds
+}
@@ -0,0 +1,72 @@
+package aggregator
+
+import "testing"
+
+func TestParseInterval(t *testing.T) {
+	tests := []struct {
+		label   string
+		want    int64
+		wantErr bool
+	}{
+		{label: "5s", want: 5_000},
+		{label: "1m", want: 60_000},
+		{label: "60m", want: 3_600_000},
+		{label: "4h", want: 14_400_000},
+		{label: "1d", want: 86_400_000},
+		{label: "1w", want: 604_800_000},
+		{label: "1M", want: 2_592_000_000},
+		{label: " 15m ", want: 900_000},
+		{label: "m", wantErr: true},
+		{label: "0m", wantErr: true},
+		{label: "-5m", wantErr: true},
+		{label: "5x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseInterval(tt.label)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseInterval(%q) error = %v, wantErr %v", tt.label, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseInterval(%q) = %d, want %d", tt.label, got, tt.want)
+		}
+	}
+}
+
+func TestParseIntervals(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "base is always included", labels: []string{"5m"}, want: []string{"1m", "5m"}},
+		{name: "repeated label", labels: []string{"1h", "1h"}, want: []string{"1m", "1h"}},
+		{name: "sub-minute", labels: []string{"15s", "1h"}, want: []string{"15s", "1m", "1h"}},
+		{name: "60m and 1h", labels: []string{"60m", "1h"}, wantErr: true},
+		{name: "1440m and 1d", labels: []string{"1d", "1440m"}, wantErr: true},
+		{name: "60s and the base", labels: []string{"60s"}, wantErr: true},
+		{name: "uneven sub-minute", labels: []string{"7s"}, wantErr: true},
+		{name: "not a multiple of the base", labels: []string{"90s"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseIntervals(tt.labels)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			sorted := sortedIntervals(got)
+			if len(sorted) != len(tt.want) {
+				t.Fatalf("got %v, want %v", sorted, tt.want)
+			}
+			for i := range sorted {
+				if sorted[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", sorted, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,521 @@
+package aggregator
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"scanner.magictradebot.com/models"
+)
+
+const (
+	journalFile  = "aggregator.journal"
+	snapshotFile = "aggregator.snapshot.json"
+)
+
+// journalEntry is one ingested tick as received, before volume baselines are applied.
+type journalEntry struct {
+	Seq        uint64          `json:"q"`
+	Symbol     string          `json:"s"`
+	Price      decimal.Decimal `json:"p"` // decimals read both the current strings and older float journals
+	Time       int64           `json:"t"`
+	Volume     decimal.Decimal `json:"v"`
+	Quote      decimal.Decimal `json:"qv"`
+	Cumulative bool            `json:"c,omitempty"` // volumes are rolling 24h totals
+}
+
+// journal is an append-only log of ticks received since the last snapshot.
+type journal struct {
+	lock   sync.Mutex
+	dir    string
+	file   *os.File
+	writer *bufio.Writer
+	seq    uint64
+}
+
+type candleSnapshot struct {
+	OpenTime   int64
+	Open       decimal.Decimal
+	High       decimal.Decimal
+	Low        decimal.Decimal
+	Close      decimal.Decimal
+	Volume     decimal.Decimal
+	Turnover   decimal.Decimal
+	TradeCount int64
+	FirstTick  int64
+	LastTick   int64
+	MaxGap     int64
+	Amendments int
+	Finalized  bool
+	Dirty      bool
+	Incomplete bool
+	Seen       []int64 `json:",omitempty"` // sorted sample times of base candles
+}
+
+type symbolSnapshot struct {
+	Buckets        []candleSnapshot
+	Volume24h      decimal.Decimal
+	QuoteVolume24h decimal.Decimal
+	LastPrice      decimal.Decimal
+	LastSeen       int64
+	Bars           map[string]barState `json:",omitempty"` // open bar of each type
+}
+
+type fillSnapshot struct {
+	LastClose map[string]decimal.Decimal
+	LastOpen  map[string]int64
+	Filled    map[string][]int64
+}
+
+type lateTickSnapshot struct {
+	Symbol string
+	Tick   TickData
+}
+
+// aggregatorSnapshot is the full aggregator state at the time journal entry Seq was written.
+type aggregatorSnapshot struct {
+	Seq         uint64
+	TakenAt     int64
+	StartedAt   int64
+	LastFlushed map[string]int64
+	Symbols     map[string]symbolSnapshot
+	Rollups     map[string]map[string][]candleSnapshot
+	Fills       map[string]fillSnapshot
+	LateTicks   []lateTickSnapshot
+	DroppedLate int64
+	PendingBars []models.SymbolBarData `json:",omitempty"` // completed but not yet extracted
+}
+
+// OpenJournal restores the aggregator from the snapshot and journal in dir, then keeps
+// journaling every tick there. It returns the number of journal entries replayed.
+func (a *KlineAggregator) OpenJournal(dir string) (int, error) {
+	if dir == "" {
+		return 0, errors.New("journal directory is not set")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, fmt.Errorf("create journal directory: %w", err)
+	}
+
+	var seq uint64
+	snap, err := readSnapshot(filepath.Join(dir, snapshotFile))
+	if err != nil {
+		return 0, err
+	}
+	if snap != nil {
+		a.restoreSnapshot(snap)
+		seq = snap.Seq
+	}
+
+	entries, err := readJournal(filepath.Join(dir, journalFile))
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for _, e := range entries {
+		// Entries up to the snapshot are already part of it
+		if e.Seq <= seq {
+			continue
+		}
+		tick := TickData{Price: e.Price, Time: e.Time, Volume: e.Volume, QuoteVolume: e.Quote}
+		shard := a.state.shardFor(e.Symbol)
+		shard.lock.Lock()
+		// Replay accepts every tick; buckets past the watermark are emitted once and dropped
+		a.applyLocked(shard, e.Symbol, tick, e.Cumulative, math.MinInt64)
+		shard.lock.Unlock()
+		seq = e.Seq
+		replayed++
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return replayed, fmt.Errorf("open journal: %w", err)
+	}
+	a.journal = &journal{
+		dir:    dir,
+		file:   file,
+		writer: bufio.NewWriter(file),
+		seq:    seq,
+	}
+
+	// Compact right away so the replayed entries are not replayed again
+	return replayed, a.Snapshot()
+}
+
+// SyncJournal flushes buffered journal entries to disk.
+func (a *KlineAggregator) SyncJournal() error {
+	if a.journal == nil {
+		return nil
+	}
+	a.journal.lock.Lock()
+	defer a.journal.lock.Unlock()
+	return a.journal.sync()
+}
+
+// Snapshot writes the full aggregator state to disk and truncates the journal.
+func (a *KlineAggregator) Snapshot() error {
+	if a.journal == nil {
+		return nil
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	// Shards are locked before the journal, matching the order ticks are ingested in.
+	// Holding the journal lock keeps new ticks out until the journal is truncated.
+	for _, shard := range a.state.shards {
+		shard.lock.Lock()
+	}
+	j := a.journal
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	snap := a.captureSnapshot(j.seq)
+	for _, shard := range a.state.shards {
+		shard.lock.Unlock()
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("marshal snapshot: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(j.dir, snapshotFile), data); err != nil {
+		return err
+	}
+
+	if err := j.writer.Flush(); err != nil {
+		return fmt.Errorf("flush journal: %w", err)
+	}
+	if err := j.file.Truncate(0); err != nil {
+		return fmt.Errorf("truncate journal: %w", err)
+	}
+	return j.file.Sync()
+}
+
+// CloseJournal takes a final snapshot and closes the journal.
+func (a *KlineAggregator) CloseJournal() error {
+	if a.journal == nil {
+		return nil
+	}
+	err := a.Snapshot()
+
+	a.journal.lock.Lock()
+	defer a.journal.lock.Unlock()
+	if closeErr := a.journal.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// append records a tick. The caller holds the symbol's shard lock, so entries of one
+// symbol are journaled in the order they were applied.
+func (j *journal) append(symbol string, tick TickData, cumulative bool) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.seq++
+	data, err := json.Marshal(journalEntry{
+		Seq:        j.seq,
+		Symbol:     symbol,
+		Price:      tick.Price,
+		Time:       tick.Time,
+		Volume:     tick.Volume,
+		Quote:      tick.QuoteVolume,
+		Cumulative: cumulative,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := j.writer.Write(data); err != nil {
+		return err
+	}
+	return j.writer.WriteByte('\n')
+}
+
+func (j *journal) sync() error {
+	if err := j.writer.Flush(); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// captureSnapshot copies the aggregator state. The caller holds a.lock and every shard lock.
+func (a *KlineAggregator) captureSnapshot(seq uint64) *aggregatorSnapshot {
+	snap := &aggregatorSnapshot{
+		Seq:         seq,
+		TakenAt:     time.Now().UnixMilli(),
+		StartedAt:   a.startedAt,
+		LastFlushed: make(map[string]int64, len(a.lastFlushed)),
+		Symbols:     make(map[string]symbolSnapshot),
+		Rollups:     make(map[string]map[string][]candleSnapshot),
+		Fills:       make(map[string]fillSnapshot),
+	}
+
+	for interval, ms := range a.lastFlushed {
+		snap.LastFlushed[interval] = ms
+	}
+
+	for _, shard := range a.state.shards {
+		for symbol, state := range shard.symbols {
+			s := symbolSnapshot{
+				Buckets:        make([]candleSnapshot, 0, len(state.buckets)),
+				Volume24h:      state.volume24h,
+				QuoteVolume24h: state.quoteVolume24h,
+				LastPrice:      state.lastPrice,
+				LastSeen:       state.lastSeen,
+			}
+			for _, candle := range state.buckets {
+				s.Buckets = append(s.Buckets, candle.snapshot())
+			}
+			if len(state.bars) > 0 {
+				s.Bars = make(map[string]barState, len(state.bars))
+				for barType, bar := range state.bars {
+					s.Bars[barType] = *bar
+				}
+			}
+			snap.Symbols[symbol] = s
+		}
+	}
+
+	for interval, bySymbol := range a.rollups {
+		snap.Rollups[interval] = make(map[string][]candleSnapshot, len(bySymbol))
+		for symbol, buckets := range bySymbol {
+			for _, candle := range buckets {
+				snap.Rollups[interval][symbol] = append(snap.Rollups[interval][symbol], candle.snapshot())
+			}
+		}
+	}
+
+	for interval, fill := range a.fills {
+		f := fillSnapshot{
+			LastClose: fill.lastClose,
+			LastOpen:  fill.lastOpen,
+			Filled:    make(map[string][]int64, len(fill.filled)),
+		}
+		for symbol, buckets := range fill.filled {
+			for openTime := range buckets {
+				f.Filled[symbol] = append(f.Filled[symbol], openTime)
+			}
+		}
+		snap.Fills[interval] = f
+	}
+
+	a.lateLock.Lock()
+	for _, late := range a.lateTicks {
+		snap.LateTicks = append(snap.LateTicks, lateTickSnapshot{Symbol: late.symbol, Tick: late.tick})
+	}
+	snap.DroppedLate = a.droppedLate
+	a.lateLock.Unlock()
+
+	a.barLock.Lock()
+	snap.PendingBars = append(snap.PendingBars, a.completedBars...)
+	a.barLock.Unlock()
+
+	return snap
+}
+
+// restoreSnapshot loads a snapshot into a freshly built aggregator. State for intervals
+// that are no longer configured is ignored.
+func (a *KlineAggregator) restoreSnapshot(snap *aggregatorSnapshot) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if snap.StartedAt > 0 {
+		a.startedAt = snap.StartedAt
+	}
+	for interval, ms := range snap.LastFlushed {
+		if _, ok := a.boundaries[interval]; ok {
+			a.lastFlushed[interval] = ms
+		}
+	}
+
+	for symbol, s := range snap.Symbols {
+		shard := a.state.shardFor(symbol)
+		shard.lock.Lock()
+		state := shard.symbol(symbol)
+		state.volume24h = s.Volume24h
+		state.quoteVolume24h = s.QuoteVolume24h
+		state.lastPrice = s.LastPrice
+		state.lastSeen = s.LastSeen
+		for _, c := range s.Buckets {
+			state.buckets[c.OpenTime] = c.restore()
+		}
+		for _, spec := range a.barSpecs {
+			bar, ok := s.Bars[spec.barType]
+			if !ok {
+				continue
+			}
+			if state.bars == nil {
+				state.bars = make(map[string]*barState, len(a.barSpecs))
+			}
+			state.bars[spec.barType] = &bar
+		}
+		shard.lock.Unlock()
+	}
+
+	for interval, bySymbol := range snap.Rollups {
+		if _, ok := a.boundaries[interval]; !ok || interval == BaseInterval {
+			continue
+		}
+		for symbol, candles := range bySymbol {
+			buckets := a.rollupBuckets(interval, symbol)
+			for _, c := range candles {
+				buckets[c.OpenTime] = c.restore()
+			}
+		}
+	}
+
+	for interval, f := range snap.Fills {
+		fill, ok := a.fills[interval]
+		if !ok {
+			continue
+		}
+		for symbol, close := range f.LastClose {
+			fill.lastClose[symbol] = close
+		}
+		for symbol, openTime := range f.LastOpen {
+			fill.lastOpen[symbol] = openTime
+		}
+		for symbol, openTimes := range f.Filled {
+			fill.filled[symbol] = make(map[int64]bool, len(openTimes))
+			for _, openTime := range openTimes {
+				fill.filled[symbol][openTime] = true
+			}
+		}
+	}
+
+	a.lateLock.Lock()
+	for _, late := range snap.LateTicks {
+		a.lateTicks = append(a.lateTicks, lateTick{symbol: late.Symbol, tick: late.Tick})
+	}
+	a.droppedLate = snap.DroppedLate
+	a.lateLock.Unlock()
+
+	a.barLock.Lock()
+	a.completedBars = append(a.completedBars, snap.PendingBars...)
+	a.barLock.Unlock()
+}
+
+func (c *candleState) snapshot() candleSnapshot {
+	return candleSnapshot{
+		OpenTime:   c.OpenTime,
+		Open:       c.Open,
+		High:       c.High,
+		Low:        c.Low,
+		Close:      c.Close,
+		Volume:     c.Volume,
+		Turnover:   c.Turnover,
+		TradeCount: c.TradeCount,
+		FirstTick:  c.FirstTick,
+		LastTick:   c.LastTick,
+		MaxGap:     c.MaxGap,
+		Amendments: c.Amendments,
+		Finalized:  c.finalized,
+		Dirty:      c.dirty,
+		Incomplete: c.incomplete,
+		Seen:       append([]int64(nil), c.seen...), // copied, as it is encoded after the shard lock is released
+	}
+}
+
+func (c candleSnapshot) restore() *candleState {
+	return &candleState{
+		OpenTime:   c.OpenTime,
+		Open:       c.Open,
+		High:       c.High,
+		Low:        c.Low,
+		Close:      c.Close,
+		Volume:     c.Volume,
+		Turnover:   c.Turnover,
+		TradeCount: c.TradeCount,
+		FirstTick:  c.FirstTick,
+		LastTick:   c.LastTick,
+		MaxGap:     c.MaxGap,
+		Amendments: c.Amendments,
+		finalized:  c.Finalized,
+		dirty:      c.Dirty,
+		incomplete: c.Incomplete,
+		seen:       c.Seen,
+	}
+}
+
+func readSnapshot(path string) (*aggregatorSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot: %w", err)
+	}
+
+	var snap aggregatorSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("parse snapshot: %w", err)
+	}
+	return &snap, nil
+}
+
+// readJournal loads all complete entries. A torn last line from a crash is skipped; a
+// malformed line anywhere else means the journal is corrupt and is reported.
+func readJournal(path string) ([]journalEntry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open journal: %w", err)
+	}
+	defer file.Close()
+
+	var entries []journalEntry
+	var torn error // the previous line failed to parse
+	line := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if torn != nil {
+			return nil, fmt.Errorf("journal line %d is corrupt: %w", line, torn)
+		}
+		line++
+		var e journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			torn = err
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read journal: %w", err)
+	}
+	return entries, nil
+}
+
+// writeFileAtomic replaces path with data so readers never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", tmp, err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("write %s: %w", tmp, err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("sync %s: %w", tmp, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("replace %s: %w", path, err)
+	}
+	return nil
+}
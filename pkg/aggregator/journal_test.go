@@ -0,0 +1,148 @@
+package aggregator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"scanner.magictradebot.com/config"
+)
+
+func TestRestartReplaysSnapshotAndJournal(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.AggregatorSettings{Intervals: []string{"1m", "5m"}}
+	start := testHour()
+
+	before := newTestAggregator(t, cfg)
+	if _, err := before.OpenJournal(dir); err != nil {
+		t.Fatalf("OpenJournal: %v", err)
+	}
+	before.AddTick("BTCUSDT", tick("100", "1", start))
+	before.AddTick("BTCUSDT", tick("104", "1", start+30_000))
+	before.AddTicker("ETHUSDT", decimal.RequireFromString("2000"), decimal.RequireFromString("1000"), decimal.Zero, start)
+	if got := before.ExtractOhlc("1m"); len(got) != 2 {
+		t.Fatalf("emitted %d candles before the snapshot, want 2", len(got))
+	}
+	if err := before.Snapshot(); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	// Journaled after the snapshot: a late tick for the emitted minute, a redelivery,
+	// the next minute and a ticker that only has a volume because of the restored baseline
+	before.AddTick("BTCUSDT", tick("98", "1", start+45_000))
+	before.AddTick("BTCUSDT", tick("104", "1", start+30_000))
+	before.AddTick("BTCUSDT", tick("101", "2", start+60_000))
+	before.AddTicker("ETHUSDT", decimal.RequireFromString("2010"), decimal.RequireFromString("1010"), decimal.Zero, start+10_000)
+	if err := before.SyncJournal(); err != nil {
+		t.Fatalf("SyncJournal: %v", err)
+	}
+
+	// Crash: the journal is never compacted and its last line is torn
+	_ = before.journal.file.Close()
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"q":99,"s":"BTCUSDT","p":"1`)
+	_ = f.Close()
+
+	after := newTestAggregator(t, cfg)
+	replayed, err := after.OpenJournal(dir)
+	if err != nil {
+		t.Fatalf("OpenJournal after crash: %v", err)
+	}
+	if replayed != 4 {
+		t.Errorf("replayed %d entries, want 4", replayed)
+	}
+
+	got := byInterval(after.ExtractOhlc("1m", "5m"))
+
+	amended, ok := got["1m"]["BTCUSDT"][start]
+	if !ok {
+		t.Fatal("the minute emitted before the crash was not amended")
+	}
+	if amended.Amendments != 1 || amended.TradeCount != 3 {
+		t.Errorf("amended minute: amendments = %d, trade count = %d, want 1 and 3", amended.Amendments, amended.TradeCount)
+	}
+	assertDecimal(t, "amended low", amended.Low, "98")
+	assertDecimal(t, "amended volume", amended.Volume, "3")
+
+	next, ok := got["1m"]["BTCUSDT"][start+60_000]
+	if !ok || next.Amendments != 0 {
+		t.Fatalf("next minute = %+v, %v", next, ok)
+	}
+	assertDecimal(t, "next volume", next.Volume, "2")
+
+	rollup, ok := got["5m"]["BTCUSDT"][start]
+	if !ok {
+		t.Fatal("no 5m candle")
+	}
+	assertDecimal(t, "5m volume", rollup.Volume, "5")
+	assertDecimal(t, "5m low", rollup.Low, "98")
+	if rollup.TradeCount != 4 {
+		t.Errorf("5m trade count = %d, want 4", rollup.TradeCount)
+	}
+
+	eth, ok := got["1m"]["ETHUSDT"][start]
+	if !ok {
+		t.Fatal("no ETHUSDT candle")
+	}
+	assertDecimal(t, "ETHUSDT volume", eth.Volume, "10")
+
+	// The replay was compacted into a new snapshot, so a second restart replays nothing
+	if err := after.CloseJournal(); err != nil {
+		t.Fatalf("CloseJournal: %v", err)
+	}
+	again := newTestAggregator(t, cfg)
+	replayed, err = again.OpenJournal(dir)
+	if err != nil {
+		t.Fatalf("OpenJournal again: %v", err)
+	}
+	defer func() { _ = again.CloseJournal() }()
+	if replayed != 0 {
+		t.Errorf("second restart replayed %d entries, want 0", replayed)
+	}
+	if got := again.ExtractOhlc("1m", "5m"); len(got) != 0 {
+		t.Errorf("second restart re-emitted %d candles", len(got))
+	}
+}
+
+func TestReadJournalToleratesOnlyATornLastLine(t *testing.T) {
+	entry := `{"q":1,"s":"BTCUSDT","p":"100","t":1}` + "\n"
+	tests := []struct {
+		name    string
+		content string
+		entries int
+		wantErr bool
+	}{
+		{name: "complete", content: entry + entry, entries: 2},
+		{name: "torn last line", content: entry + `{"q":2,"s":"BTC`, entries: 1},
+		{name: "torn line before others", content: entry + `{"q":2,"s":"BTC` + "\n" + entry, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), journalFile)
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			entries, err := readJournal(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readJournal error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(entries) != tt.entries {
+				t.Errorf("read %d entries, want %d", len(entries), tt.entries)
+			}
+		})
+	}
+
+	// OpenJournal refuses to start from a corrupt journal rather than replaying part of it
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, journalFile), []byte("garbage\n"+entry), 0644); err != nil {
+		t.Fatal(err)
+	}
+	agg := newTestAggregator(t, config.AggregatorSettings{Intervals: []string{"1m"}})
+	if _, err := agg.OpenJournal(dir); err == nil {
+		t.Error("OpenJournal accepted a corrupt journal")
+	}
+}
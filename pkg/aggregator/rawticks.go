@@ -0,0 +1,72 @@
+package aggregator
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/pkg/global"
+	"scanner.magictradebot.com/pkg/utils"
+)
+
+// RawTick is a ticker exactly as received from the exchange, before parsing.
+type RawTick struct {
+	Exchange    string `json:"exchange"`
+	Symbol      string `json:"symbol"`
+	LastPrice   string `json:"lastPrice"`
+	High24h     string `json:"high24h"`
+	Low24h      string `json:"low24h"`
+	Vol24h      string `json:"vol24h"`
+	QuoteVol24h string `json:"quoteVol24h"`
+	Change24h   string `json:"change24h"`
+	Timestamp   int64  `json:"timestamp"`
+	ReceivedAt  int64  `json:"receivedAt"`
+}
+
+// PersistRawTicks writes a batch of raw ticks to the configured Redis stream or Kafka topic.
+func PersistRawTicks(ticks []RawTick, cfg config.RawTickSettings, log *logrus.Logger) {
+	if !cfg.Enabled || len(ticks) == 0 {
+		return
+	}
+
+	symbols := make([]string, 0, len(ticks))
+	payloads := make([][]byte, 0, len(ticks))
+	for _, t := range ticks {
+		payload, err := json.Marshal(t)
+		if err != nil {
+			log.WithError(err).WithField("symbol", t.Symbol).Error("❌ Failed to marshal raw tick")
+			continue
+		}
+		symbols = append(symbols, t.Symbol)
+		payloads = append(payloads, payload)
+	}
+
+	switch cfg.Output {
+	case "redis":
+		ctx := context.Background()
+		pipe := global.RedisClient.Pipeline()
+		for i, payload := range payloads {
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: cfg.Target,
+				Values: utils.CreateRedisStreamEntry(symbols[i], payload),
+			})
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.WithError(err).Error("❌ Redis raw tick write error")
+		} else {
+			log.WithField("count", len(payloads)).Debug("💾 Raw ticks written to Redis")
+		}
+
+	case "kafka":
+		if err := utils.WriteKafkaBatch(global.RawTickWriter, symbols, payloads); err != nil {
+			log.WithError(err).Error("❌ Kafka raw tick write error")
+		} else {
+			log.WithField("count", len(payloads)).Debug("💾 Raw ticks written to Kafka")
+		}
+
+	default:
+		log.Warnf("⚠️ Unknown raw tick output: %s", cfg.Output)
+	}
+}
@@ -0,0 +1,127 @@
+package aggregator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/pkg/global"
+)
+
+// recordingHook captures pipelined commands instead of sending them to Redis.
+type recordingHook struct {
+	cmds []redis.Cmder
+	err  error
+}
+
+func (h *recordingHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h *recordingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook { return next }
+
+func (h *recordingHook) ProcessPipelineHook(redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(_ context.Context, cmds []redis.Cmder) error {
+		h.cmds = append(h.cmds, cmds...)
+		return h.err
+	}
+}
+
+func useRedis(t *testing.T, hook *recordingHook) {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"})
+	client.AddHook(hook)
+	previous := global.RedisClient
+	global.RedisClient = client
+	t.Cleanup(func() {
+		global.RedisClient = previous
+		_ = client.Close()
+	})
+}
+
+var rawTicks = []RawTick{
+	{Exchange: "bybit", Symbol: "BTCUSDT", LastPrice: "65000.5", Timestamp: 1_700_000_000_000},
+	{Exchange: "bybit", Symbol: "ETHUSDT", LastPrice: "3500.25", Timestamp: 1_700_000_000_000},
+}
+
+func TestPersistRawTicksSkipsWhenDisabledOrEmpty(t *testing.T) {
+	hook := &recordingHook{}
+	useRedis(t, hook)
+	log, logged := test.NewNullLogger()
+
+	PersistRawTicks(rawTicks, config.RawTickSettings{Output: "redis", Target: "ticks"}, log)
+	PersistRawTicks(nil, config.RawTickSettings{Enabled: true, Output: "redis", Target: "ticks"}, log)
+	if len(hook.cmds) != 0 || len(logged.AllEntries()) != 0 {
+		t.Errorf("sent %d commands and logged %d entries", len(hook.cmds), len(logged.AllEntries()))
+	}
+}
+
+func TestPersistRawTicksToRedis(t *testing.T) {
+	hook := &recordingHook{}
+	useRedis(t, hook)
+	log, logged := test.NewNullLogger()
+	log.SetLevel(logrus.DebugLevel)
+
+	PersistRawTicks(rawTicks, config.RawTickSettings{Enabled: true, Output: "redis", Target: "ticks"}, log)
+
+	if len(hook.cmds) != len(rawTicks) {
+		t.Fatalf("sent %d commands, want one per tick", len(hook.cmds))
+	}
+	for i, cmd := range hook.cmds {
+		args := cmd.Args()
+		if args[0] != "xadd" || args[1] != "ticks" {
+			t.Fatalf("command %d = %v, want an XADD to the target stream", i, args)
+		}
+		values := map[string]interface{}{}
+		for j := 3; j+1 < len(args); j += 2 {
+			values[args[j].(string)] = args[j+1]
+		}
+		if values["symbol"] != rawTicks[i].Symbol {
+			t.Errorf("entry %d symbol = %v, want %s", i, values["symbol"], rawTicks[i].Symbol)
+		}
+		var got RawTick
+		if err := json.Unmarshal(values["payload"].([]byte), &got); err != nil || got != rawTicks[i] {
+			t.Errorf("entry %d payload = %s (%v), want %+v", i, values["payload"], err, rawTicks[i])
+		}
+	}
+	if entry := logged.LastEntry(); entry == nil || entry.Level != logrus.DebugLevel || entry.Data["count"] != 2 {
+		t.Errorf("logged %+v", entry)
+	}
+
+	hook.err = errors.New("connection refused")
+	PersistRawTicks(rawTicks, config.RawTickSettings{Enabled: true, Output: "redis", Target: "ticks"}, log)
+	if entry := logged.LastEntry(); entry == nil || entry.Level != logrus.ErrorLevel || entry.Data[logrus.ErrorKey] != hook.err {
+		t.Errorf("a failed write logged %+v", entry)
+	}
+}
+
+func TestPersistRawTicksReportsKafkaErrors(t *testing.T) {
+	previous := global.RawTickWriter
+	writer := &kafka.Writer{Addr: kafka.TCP("127.0.0.1:1"), Topic: "ticks", MaxAttempts: 1, WriteTimeout: time.Second}
+	global.RawTickWriter = writer
+	t.Cleanup(func() {
+		global.RawTickWriter = previous
+		_ = writer.Close()
+	})
+	log, logged := test.NewNullLogger()
+
+	PersistRawTicks(rawTicks, config.RawTickSettings{Enabled: true, Output: "kafka", Target: "ticks"}, log)
+	if entry := logged.LastEntry(); entry == nil || entry.Level != logrus.ErrorLevel || entry.Message != "❌ Kafka raw tick write error" {
+		t.Errorf("an unreachable broker logged %+v", entry)
+	}
+}
+
+func TestPersistRawTicksWarnsOnUnknownOutput(t *testing.T) {
+	log, logged := test.NewNullLogger()
+	PersistRawTicks(rawTicks, config.RawTickSettings{Enabled: true, Output: "nats"}, log)
+
+	entry := logged.LastEntry()
+	if entry == nil || entry.Level != logrus.WarnLevel || entry.Message != "⚠️ Unknown raw tick output: nats" {
+		t.Errorf("logged %+v", entry)
+	}
+}
@@ -0,0 +1,94 @@
+package aggregator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/pkg/global"
+)
+
+// StreamTick is one tick read from the streaming source.
+type StreamTick struct {
+	Symbol      string          `json:"symbol"`
+	Price       decimal.Decimal `json:"price"` // JSON numbers or strings; strings keep every digit
+	Volume      decimal.Decimal `json:"volume"`
+	QuoteVolume decimal.Decimal `json:"quoteVolume"`
+	Timestamp   int64           `json:"timestamp"`  // unix ms
+	Cumulative  bool            `json:"cumulative"` // volumes are rolling 24h totals rather than per trade
+}
+
+// ConsumeStream reads ticks from the configured Redis stream or Kafka topic and passes each
+// one to handle until ctx is cancelled. Malformed messages are logged and skipped.
+func ConsumeStream(ctx context.Context, cfg config.StreamSourceSettings, handle func(StreamTick), log *logrus.Logger) {
+	decode := func(data []byte) {
+		var tick StreamTick
+		if err := json.Unmarshal(data, &tick); err != nil || tick.Symbol == "" {
+			log.WithError(err).Warn("⚠️ Skipping malformed streamed tick")
+			return
+		}
+		if tick.Timestamp == 0 {
+			tick.Timestamp = time.Now().UnixMilli()
+		}
+		handle(tick)
+	}
+
+	switch cfg.Input {
+	case "redis":
+		consumeRedis(ctx, cfg.Target, decode, log)
+	case "kafka":
+		consumeKafka(ctx, decode, log)
+	default:
+		log.Warnf("⚠️ Unknown stream source input: %s", cfg.Input)
+	}
+}
+
+// consumeRedis follows the stream from entries added after startup.
+func consumeRedis(ctx context.Context, stream string, decode func([]byte), log *logrus.Logger) {
+	lastID := "$"
+	for ctx.Err() == nil {
+		result, err := global.RedisClient.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{stream, lastID},
+			Count:   500,
+			Block:   5 * time.Second,
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) || ctx.Err() != nil {
+				continue
+			}
+			log.WithError(err).Error("❌ Redis stream read error")
+			time.Sleep(time.Second)
+			continue
+		}
+
+		for _, s := range result {
+			for _, msg := range s.Messages {
+				lastID = msg.ID
+				// Entries follow CreateRedisStreamEntry: the tick JSON is in "payload"
+				if payload, ok := msg.Values["payload"].(string); ok {
+					decode([]byte(payload))
+				}
+			}
+		}
+	}
+}
+
+func consumeKafka(ctx context.Context, decode func([]byte), log *logrus.Logger) {
+	for ctx.Err() == nil {
+		msg, err := global.StreamSourceReader.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.WithError(err).Error("❌ Kafka read error")
+			time.Sleep(time.Second)
+			continue
+		}
+		decode(msg.Value)
+	}
+}
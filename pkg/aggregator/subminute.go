@@ -0,0 +1,202 @@
+package aggregator
+
+import (
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/models"
+)
+
+// subMinuteGrace is how long a sub-minute bar stays open after its close time so ticks
+// still in flight from the stream can land in it.
+const subMinuteGrace int64 = 2_000
+
+// subMinuteState holds the closed sub-minute bars waiting to be written and the recent
+// bars kept in memory. Guarded by KlineAggregator.subLock.
+type subMinuteState struct {
+	ringSize   int
+	maxPending int
+	rings      map[string]map[string]*barRing // symbol -> interval -> recent bars
+	pending    []models.SymbolSecondKlineData
+	dropped    int64 // bars dropped because the pending buffer was full
+	late       int64 // ticks for a bar that was already closed
+}
+
+// barRing keeps the latest bars in a fixed-size circular buffer.
+type barRing struct {
+	bars []models.SymbolSecondKlineData
+	next int
+	full bool
+}
+
+func newSubMinuteState(cfg config.SubMinuteSettings) *subMinuteState {
+	s := &subMinuteState{
+		ringSize:   cfg.RingSize,
+		maxPending: cfg.MaxPending,
+		rings:      make(map[string]map[string]*barRing),
+	}
+	if s.ringSize <= 0 {
+		s.ringSize = 900
+	}
+	if s.maxPending <= 0 {
+		s.maxPending = 100_000
+	}
+	return s
+}
+
+func (r *barRing) push(bar models.SymbolSecondKlineData) {
+	r.bars[r.next] = bar
+	r.next = (r.next + 1) % len(r.bars)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// latest returns up to n bars, oldest first.
+func (r *barRing) latest(n int) []models.SymbolSecondKlineData {
+	count := r.next
+	if r.full {
+		count = len(r.bars)
+	}
+	if n <= 0 || n > count {
+		n = count
+	}
+
+	result := make([]models.SymbolSecondKlineData, 0, n)
+	for i := n; i > 0; i-- {
+		idx := (r.next - i + len(r.bars)) % len(r.bars)
+		result = append(result, r.bars[idx])
+	}
+	return result
+}
+
+// record stores a closed bar in the symbol's ring and queues it for writing.
+func (s *subMinuteState) record(bar models.SymbolSecondKlineData) {
+	byInterval, ok := s.rings[bar.Symbol]
+	if !ok {
+		byInterval = make(map[string]*barRing)
+		s.rings[bar.Symbol] = byInterval
+	}
+	ring, ok := byInterval[bar.Interval]
+	if !ok {
+		ring = &barRing{bars: make([]models.SymbolSecondKlineData, s.ringSize)}
+		byInterval[bar.Interval] = ring
+	}
+	ring.push(bar)
+
+	if len(s.pending) >= s.maxPending {
+		s.pending = s.pending[1:]
+		s.dropped++
+	}
+	s.pending = append(s.pending, bar)
+}
+
+func toSecondKline(symbol, interval string, c *candleState) models.SymbolSecondKlineData {
+	return models.SymbolSecondKlineData{
+		Symbol:     symbol,
+		Interval:   interval,
+		OpenTime:   c.OpenTime,
+		Open:       c.Open,
+		High:       c.High,
+		Low:        c.Low,
+		Close:      c.Close,
+		Volume:     c.Volume,
+		Turnover:   c.Turnover,
+		TradeCount: c.TradeCount,
+	}
+}
+
+// applySubMinute feeds a tick to the symbol's open sub-minute bars. A tick for a later bucket
+// closes the open bar; a tick for an earlier one, or for a bar already closed, is counted as
+// late and dropped, since sub-minute bars are never amended. The symbol's shard lock must be held.
+func (a *KlineAggregator) applySubMinute(symbol string, state *symbolState, tick TickData) {
+	if state.seconds == nil {
+		state.seconds = make(map[string]*candleState, len(a.subIntervals))
+		state.secondsClosed = make(map[string]int64, len(a.subIntervals))
+	}
+
+	for _, interval := range a.subIntervals {
+		openTime := a.boundaries[interval].floor(tick.Time)
+		if closed, ok := state.secondsClosed[interval]; ok && openTime <= closed {
+			a.subLock.Lock()
+			a.sub.late++
+			a.subLock.Unlock()
+			continue
+		}
+
+		bar, ok := state.seconds[interval]
+		switch {
+		case !ok:
+			state.seconds[interval] = newCandleState(openTime, tick)
+		case openTime == bar.OpenTime:
+			bar.update(tick)
+		case openTime > bar.OpenTime:
+			a.closeSubMinute(symbol, interval, state, bar)
+			state.seconds[interval] = newCandleState(openTime, tick)
+		default:
+			a.subLock.Lock()
+			a.sub.late++
+			a.subLock.Unlock()
+		}
+	}
+}
+
+// closeSubMinute records a closed bar and remembers its open time, so ticks for it that
+// arrive later are rejected rather than opening it again. The symbol's shard lock must be held.
+func (a *KlineAggregator) closeSubMinute(symbol, interval string, state *symbolState, bar *candleState) {
+	a.subLock.Lock()
+	a.sub.record(toSecondKline(symbol, interval, bar))
+	a.subLock.Unlock()
+	delete(state.seconds, interval)
+	state.secondsClosed[interval] = bar.OpenTime
+}
+
+// SubMinuteIntervals returns the configured intervals shorter than a minute, shortest first.
+func (a *KlineAggregator) SubMinuteIntervals() []string {
+	return append([]string(nil), a.subIntervals...)
+}
+
+// ExtractSubMinute closes sub-minute bars whose close time passed before nowMs and
+// returns every bar closed since the previous call.
+func (a *KlineAggregator) ExtractSubMinute(nowMs int64) []models.SymbolSecondKlineData {
+	if len(a.subIntervals) == 0 {
+		return nil
+	}
+
+	for _, shard := range a.state.shards {
+		shard.lock.Lock()
+		for symbol, state := range shard.symbols {
+			for interval, bar := range state.seconds {
+				if a.boundaries[interval].next(bar.OpenTime)+subMinuteGrace > nowMs {
+					continue
+				}
+				a.closeSubMinute(symbol, interval, state, bar)
+			}
+		}
+		shard.lock.Unlock()
+	}
+
+	a.subLock.Lock()
+	defer a.subLock.Unlock()
+	bars := a.sub.pending
+	a.sub.pending = nil
+	return bars
+}
+
+// RecentSubMinute returns up to n of a symbol's latest closed bars for an interval, oldest first.
+func (a *KlineAggregator) RecentSubMinute(symbol, interval string, n int) []models.SymbolSecondKlineData {
+	a.subLock.Lock()
+	defer a.subLock.Unlock()
+
+	ring, ok := a.sub.rings[symbol][interval]
+	if !ok {
+		return nil
+	}
+	return ring.latest(n)
+}
+
+// SubMinuteDrops returns how many closed bars were dropped from a full write buffer and how
+// many ticks arrived for a bar that was already closed.
+func (a *KlineAggregator) SubMinuteDrops() (bars, lateTicks int64) {
+	a.subLock.Lock()
+	defer a.subLock.Unlock()
+	return a.sub.dropped, a.sub.late
+}
@@ -0,0 +1,102 @@
+package aggregator
+
+import (
+	"testing"
+
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/models"
+)
+
+func openTimes(bars []models.SymbolSecondKlineData, start int64) []int64 {
+	times := make([]int64, len(bars))
+	for i, b := range bars {
+		times[i] = b.OpenTime - start
+	}
+	return times
+}
+
+func equalTimes(got, want []int64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSubMinuteRingKeepsTheLatestBars(t *testing.T) {
+	agg := newTestAggregator(t, config.AggregatorSettings{
+		Intervals: []string{"1m", "1s"},
+		SubMinute: config.SubMinuteSettings{RingSize: 3},
+	})
+	start := testHour()
+
+	// Each tick opens the next second and closes the previous one
+	for i := int64(0); i < 6; i++ {
+		agg.AddTick("BTCUSDT", tick("100", "1", start+i*1_000))
+	}
+
+	if got := openTimes(agg.RecentSubMinute("BTCUSDT", "1s", 0), start); !equalTimes(got, []int64{2_000, 3_000, 4_000}) {
+		t.Errorf("ring holds bars opened at %v, want the latest three oldest first", got)
+	}
+	if got := openTimes(agg.RecentSubMinute("BTCUSDT", "1s", 2), start); !equalTimes(got, []int64{3_000, 4_000}) {
+		t.Errorf("latest two bars opened at %v", got)
+	}
+	if got := agg.RecentSubMinute("ETHUSDT", "1s", 0); got != nil {
+		t.Errorf("unknown symbol returned %v", got)
+	}
+}
+
+func TestSubMinutePendingCap(t *testing.T) {
+	agg := newTestAggregator(t, config.AggregatorSettings{
+		Intervals: []string{"1m", "1s"},
+		SubMinute: config.SubMinuteSettings{MaxPending: 2},
+	})
+	start := testHour()
+
+	for i := int64(0); i < 5; i++ {
+		agg.AddTick("BTCUSDT", tick("100", "1", start+i*1_000))
+	}
+
+	// Four bars closed; the buffer keeps the newest two and the open bar stays open
+	if got := openTimes(agg.ExtractSubMinute(start), start); !equalTimes(got, []int64{2_000, 3_000}) {
+		t.Errorf("extracted bars opened at %v, want the newest two", got)
+	}
+	if bars, late := agg.SubMinuteDrops(); bars != 2 || late != 0 {
+		t.Errorf("drops = %d bars and %d late ticks, want 2 and 0", bars, late)
+	}
+	if got := agg.ExtractSubMinute(start); len(got) != 0 {
+		t.Errorf("second extraction returned %d bars", len(got))
+	}
+}
+
+func TestSubMinuteRejectsTicksForClosedBars(t *testing.T) {
+	agg := newTestAggregator(t, config.AggregatorSettings{Intervals: []string{"1m", "5s"}})
+	start := testHour()
+
+	agg.AddTick("BTCUSDT", tick("100", "1", start+1_000))
+	agg.AddTick("BTCUSDT", tick("101", "1", start+6_000)) // closes the bar at 0
+	agg.AddTick("BTCUSDT", tick("99", "1", start+2_000))  // behind the open bar
+	if got := openTimes(agg.ExtractSubMinute(start+10_000+subMinuteGrace), start); !equalTimes(got, []int64{0, 5_000}) {
+		t.Fatalf("extracted bars opened at %v, want 0 and 5000", got)
+	}
+
+	// The bar at 5000 was closed by extraction; a straggler must not open it again
+	agg.AddTick("BTCUSDT", tick("102", "1", start+7_000))
+	if got := agg.ExtractSubMinute(start + 60_000); len(got) != 0 {
+		t.Errorf("a late tick reopened a closed bar: %+v", got)
+	}
+	if _, late := agg.SubMinuteDrops(); late != 2 {
+		t.Errorf("late ticks = %d, want 2", late)
+	}
+
+	agg.AddTick("BTCUSDT", tick("103", "1", start+11_000))
+	got := agg.ExtractSubMinute(start + 60_000)
+	if len(got) != 1 || got[0].OpenTime != start+10_000 || got[0].TradeCount != 1 {
+		t.Fatalf("next bar = %+v, want one opened at 10000", got)
+	}
+	assertDecimal(t, "close", got[0].Close, "103")
+}
@@ -0,0 +1,197 @@
+package aggregator
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"scanner.magictradebot.com/config"
+)
+
+// SymbolScheduler picks the symbols to request next from per-symbol endpoints. Symbols that
+// move or trade more are due more often, down to MinInterval; every symbol is due at least
+// every MaxInterval. Batches shrink as the exchange's remaining rate-limit budget runs low.
+type SymbolScheduler struct {
+	lock        sync.Mutex
+	symbols     map[string]*scheduledSymbol
+	batchSize   int
+	minInterval time.Duration
+	maxInterval time.Duration
+	reserve     float64 // share of the rate-limit budget kept unused
+}
+
+type scheduledSymbol struct {
+	lastFetched time.Time
+	lastPrice   float64
+	lastSeen    time.Time
+	volatility  float64 // EWMA of absolute log returns per square-root second
+	turnover    float64 // latest rolling 24h quote volume
+	observed    bool
+}
+
+// volatilityWeight is the EWMA weight of the latest return.
+const volatilityWeight = 0.2
+
+// NewSymbolScheduler schedules the given symbols. refresh is the fetch loop period and the
+// default for the shortest interval.
+func NewSymbolScheduler(symbols []string, cfg config.SchedulerSettings, refresh time.Duration) *SymbolScheduler {
+	s := &SymbolScheduler{
+		symbols:     make(map[string]*scheduledSymbol, len(symbols)),
+		batchSize:   cfg.BatchSize,
+		minInterval: time.Duration(cfg.MinIntervalSeconds) * time.Second,
+		maxInterval: time.Duration(cfg.MaxIntervalSeconds) * time.Second,
+		reserve:     float64(cfg.ReservePercent) / 100,
+	}
+	if s.batchSize <= 0 {
+		s.batchSize = 50
+	}
+	if s.minInterval < refresh {
+		s.minInterval = refresh
+	}
+	if s.maxInterval <= 0 {
+		s.maxInterval = time.Minute
+	}
+	if s.maxInterval < s.minInterval {
+		s.maxInterval = s.minInterval
+	}
+	if s.reserve <= 0 || s.reserve >= 1 {
+		s.reserve = 0.2
+	}
+
+	for _, symbol := range symbols {
+		s.symbols[symbol] = &scheduledSymbol{}
+	}
+	return s
+}
+
+// Remove stops scheduling a symbol, e.g. once it is blacklisted.
+func (s *SymbolScheduler) Remove(symbol string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.symbols, symbol)
+}
+
+// Observe records a fetched ticker so the symbol's priority follows its activity.
+func (s *SymbolScheduler) Observe(symbol string, price, quoteVolume24h float64, at time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	state, ok := s.symbols[symbol]
+	if !ok || price <= 0 {
+		return
+	}
+
+	if state.lastPrice > 0 {
+		if elapsed := at.Sub(state.lastSeen).Seconds(); elapsed > 0 {
+			move := math.Abs(math.Log(price/state.lastPrice)) / math.Sqrt(elapsed)
+			if state.observed {
+				state.volatility = volatilityWeight*move + (1-volatilityWeight)*state.volatility
+			} else {
+				state.volatility = move
+				state.observed = true
+			}
+		}
+	}
+	state.lastPrice = price
+	state.lastSeen = at
+	if quoteVolume24h > 0 {
+		state.turnover = quoteVolume24h
+	}
+}
+
+// NextBatch returns the symbols due at now, most overdue first. budget is the remaining share
+// of the exchange's rate limit, 1 when unknown. Symbols past MaxInterval are always included so
+// quiet symbols keep their minimum cadence; the rest fill the batch as far as the budget allows.
+// Symbols never fetched yet only take free slots, so startup does not burst through the limit.
+func (s *SymbolScheduler) NextBatch(now time.Time, budget float64) []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	usable := (budget - s.reserve) / (1 - s.reserve)
+	usable = math.Max(0, math.Min(1, usable))
+	capacity := int(math.Ceil(float64(s.batchSize) * usable))
+
+	intervals := s.intervals()
+
+	type candidate struct {
+		symbol  string
+		overdue float64
+		starved bool
+	}
+	var due []candidate
+	for symbol, state := range s.symbols {
+		elapsed := now.Sub(state.lastFetched)
+		interval := intervals[symbol]
+		if elapsed < interval {
+			continue
+		}
+		due = append(due, candidate{
+			symbol:  symbol,
+			overdue: float64(elapsed) / float64(interval),
+			starved: !state.lastFetched.IsZero() && elapsed >= s.maxInterval,
+		})
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].starved != due[j].starved {
+			return due[i].starved
+		}
+		if due[i].overdue != due[j].overdue {
+			return due[i].overdue > due[j].overdue
+		}
+		return due[i].symbol < due[j].symbol
+	})
+
+	batch := make([]string, 0, capacity)
+	for _, c := range due {
+		if len(batch) >= capacity && !c.starved {
+			break
+		}
+		batch = append(batch, c.symbol)
+		s.symbols[c.symbol].lastFetched = now
+	}
+	return batch
+}
+
+// intervals ranks symbols by volatility and by turnover and maps the higher of the two ranks
+// onto [MinInterval, MaxInterval]. Symbols without observations are fetched at MinInterval
+// until their activity is known. The lock must be held.
+func (s *SymbolScheduler) intervals() map[string]time.Duration {
+	volatilityRank := s.rank(func(state *scheduledSymbol) float64 { return state.volatility })
+	turnoverRank := s.rank(func(state *scheduledSymbol) float64 { return state.turnover })
+
+	span := s.maxInterval - s.minInterval
+	result := make(map[string]time.Duration, len(s.symbols))
+	for symbol, state := range s.symbols {
+		score := 1.0
+		if state.observed {
+			score = math.Max(volatilityRank[symbol], turnoverRank[symbol])
+		}
+		result[symbol] = s.maxInterval - time.Duration(score*float64(span))
+	}
+	return result
+}
+
+// rank returns each observed symbol's percentile in [0, 1] for the given measure.
+func (s *SymbolScheduler) rank(measure func(*scheduledSymbol) float64) map[string]float64 {
+	symbols := make([]string, 0, len(s.symbols))
+	for symbol, state := range s.symbols {
+		if state.observed {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		return measure(s.symbols[symbols[i]]) < measure(s.symbols[symbols[j]])
+	})
+
+	ranks := make(map[string]float64, len(symbols))
+	for i, symbol := range symbols {
+		if len(symbols) == 1 {
+			ranks[symbol] = 1
+			continue
+		}
+		ranks[symbol] = float64(i) / float64(len(symbols)-1)
+	}
+	return ranks
+}
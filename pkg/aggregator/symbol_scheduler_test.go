@@ -0,0 +1,103 @@
+package aggregator
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"scanner.magictradebot.com/config"
+)
+
+func testSymbols(n int) []string {
+	symbols := make([]string, n)
+	for i := range symbols {
+		symbols[i] = fmt.Sprintf("SYM%02dUSDT", i)
+	}
+	return symbols
+}
+
+func TestSchedulerCapacityFollowsTheBudget(t *testing.T) {
+	cfg := config.SchedulerSettings{BatchSize: 10, MinIntervalSeconds: 5, MaxIntervalSeconds: 60, ReservePercent: 20}
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		budget float64
+		want   int
+	}{
+		{budget: 1, want: 10},
+		{budget: 0.6, want: 5},  // half of the usable budget above the reserve
+		{budget: 0.25, want: 1}, // a sliver of budget still allows one request
+		{budget: 0.2, want: 0},  // nothing but the reserve left
+		{budget: -0.5, want: 0}, // a broken estimate never goes negative
+		{budget: 3, want: 10},   // nor above the batch size
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.budget), func(t *testing.T) {
+			s := NewSymbolScheduler(testSymbols(20), cfg, 5*time.Second)
+			if got := s.NextBatch(now, tt.budget); len(got) != tt.want {
+				t.Errorf("batch of %d symbols at budget %v, want %d", len(got), tt.budget, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedulerKeepsStarvedSymbolsAtTheirMinimumCadence(t *testing.T) {
+	cfg := config.SchedulerSettings{BatchSize: 10, MinIntervalSeconds: 5, MaxIntervalSeconds: 60, ReservePercent: 20}
+	s := NewSymbolScheduler(testSymbols(10), cfg, 5*time.Second)
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	if got := s.NextBatch(start, 1); len(got) != 10 {
+		t.Fatalf("first batch has %d symbols, want all 10", len(got))
+	}
+	// Quiet symbols are due after MaxInterval; without budget nothing is fetched before that
+	if got := s.NextBatch(start.Add(59*time.Second), 0); len(got) != 0 {
+		t.Errorf("fetched %v with no budget before any symbol starved", got)
+	}
+	got := s.NextBatch(start.Add(60*time.Second), 0)
+	if len(got) != 10 {
+		t.Errorf("starved batch = %v, want every symbol despite the exhausted budget", got)
+	}
+	if again := s.NextBatch(start.Add(61*time.Second), 0); len(again) != 0 {
+		t.Errorf("refetched %v right after the starved batch", again)
+	}
+}
+
+func TestSchedulerFetchesActiveSymbolsMoreOften(t *testing.T) {
+	cfg := config.SchedulerSettings{BatchSize: 10, MinIntervalSeconds: 5, MaxIntervalSeconds: 60}
+	s := NewSymbolScheduler([]string{"BTCUSDT", "ETHUSDT", "XRPUSDT"}, cfg, 5*time.Second)
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	// BTC moves and trades most, XRP least
+	moves := map[string][2]float64{"BTCUSDT": {100, 110}, "ETHUSDT": {100, 101}, "XRPUSDT": {100, 100}}
+	turnover := map[string]float64{"BTCUSDT": 1e9, "ETHUSDT": 1e6, "XRPUSDT": 1e3}
+	for symbol, prices := range moves {
+		s.Observe(symbol, prices[0], turnover[symbol], start.Add(-10*time.Second))
+		s.Observe(symbol, prices[1], turnover[symbol], start)
+	}
+	s.Observe("UNKNOWN", 1, 1, start)
+
+	if got := s.NextBatch(start, 1); len(got) != 3 {
+		t.Fatalf("first batch = %v", got)
+	}
+	due := func(at time.Duration) []string {
+		got := s.NextBatch(start.Add(at), 1)
+		sort.Strings(got)
+		return got
+	}
+	if got := due(5 * time.Second); len(got) != 1 || got[0] != "BTCUSDT" {
+		t.Errorf("due after 5s = %v, want only BTCUSDT", got)
+	}
+	// ETH sits in the middle of both rankings, halfway between 5s and 60s
+	if got := due(33 * time.Second); len(got) != 2 || got[0] != "BTCUSDT" || got[1] != "ETHUSDT" {
+		t.Errorf("due after 33s = %v, want BTCUSDT and ETHUSDT", got)
+	}
+	if got := due(60 * time.Second); len(got) != 2 || got[1] != "XRPUSDT" {
+		t.Errorf("due after 60s = %v, want XRPUSDT at its maximum interval", got)
+	}
+
+	s.Remove("BTCUSDT")
+	if got := due(2 * time.Minute); len(got) != 2 || got[0] != "ETHUSDT" {
+		t.Errorf("due after removing BTCUSDT = %v", got)
+	}
+}
@@ -0,0 +1,281 @@
+package aggregator
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/models"
+)
+
+// Built-in tick filter rules.
+const (
+	RuleNonPositive = "non_positive" // price or volume at or below zero
+	RuleJump        = "mad_jump"     // price too far from the recent median
+	RuleStale       = "stale"        // timestamp too far behind the clock
+	RuleDuplicate   = "duplicate"    // identical to the symbol's last accepted tick
+)
+
+// TickHistory is what the filter remembers about a symbol's accepted ticks.
+type TickHistory struct {
+	Recent           []float64 // latest accepted prices, oldest first; only used for statistics
+	Last             TickData  // last accepted tick, as received
+	Accepted         int64
+	ConsecutiveJumps int // jump rejections since the last price within range
+}
+
+// TickRule decides whether a tick is plausible. Check returns a human-readable
+// rejection reason, or "" to let the tick through.
+type TickRule interface {
+	Name() string
+	Check(symbol string, tick TickData, history *TickHistory) string
+}
+
+// TickFilter runs every tick through its rules before it reaches the aggregator.
+// Ticks are checked as received, so volumes are still rolling 24h totals.
+type TickFilter struct {
+	rules       []TickRule
+	window      int
+	quarantine  bool
+	lock        sync.Mutex
+	history     map[string]*TickHistory
+	rejected    map[string]int64
+	quarantined []models.QuarantinedTick
+	Logger      *logrus.Logger
+}
+
+// NewTickFilter builds a filter with the configured built-in rules, or all of them when none are listed.
+func NewTickFilter(cfg config.TickFilterSettings, logger *logrus.Logger) (*TickFilter, error) {
+	window := cfg.MadWindow
+	if window <= 0 {
+		window = 50
+	}
+
+	f := &TickFilter{
+		window:     window,
+		quarantine: cfg.Quarantine,
+		history:    make(map[string]*TickHistory),
+		rejected:   make(map[string]int64),
+		Logger:     logger,
+	}
+
+	names := cfg.Rules
+	if len(names) == 0 {
+		names = []string{RuleNonPositive, RuleStale, RuleDuplicate, RuleJump}
+	}
+
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case RuleNonPositive:
+			f.AddRule(nonPositiveRule{})
+		case RuleStale:
+			maxAge := time.Duration(cfg.MaxAgeSeconds) * time.Second
+			if maxAge <= 0 {
+				maxAge = 2 * time.Minute
+			}
+			f.AddRule(staleRule{maxAge: maxAge.Milliseconds()})
+		case RuleDuplicate:
+			f.AddRule(duplicateRule{})
+		case RuleJump:
+			rule := jumpRule{
+				multiplier:     cfg.MadMultiplier,
+				minSamples:     cfg.MadMinSamples,
+				minJumpRatio:   cfg.MinJumpPercent / 100,
+				maxConsecutive: cfg.MaxConsecutiveRejects,
+			}
+			if rule.multiplier <= 0 {
+				rule.multiplier = 10
+			}
+			if rule.minSamples <= 0 {
+				rule.minSamples = 10
+			}
+			if rule.minJumpRatio <= 0 {
+				rule.minJumpRatio = 0.02
+			}
+			if rule.maxConsecutive <= 0 {
+				rule.maxConsecutive = 5
+			}
+			f.AddRule(rule)
+		default:
+			return nil, fmt.Errorf("unknown tick filter rule %q (use %s, %s, %s or %s)",
+				name, RuleNonPositive, RuleJump, RuleStale, RuleDuplicate)
+		}
+	}
+
+	return f, nil
+}
+
+// AddRule appends a rule; rules run in the order they were added.
+func (f *TickFilter) AddRule(rule TickRule) {
+	f.rules = append(f.rules, rule)
+}
+
+// Accept reports whether a tick passed every rule. Rejected ticks are counted, logged
+// and, when quarantine is enabled, kept for ExtractQuarantined.
+func (f *TickFilter) Accept(symbol string, tick TickData) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	history, ok := f.history[symbol]
+	if !ok {
+		history = &TickHistory{}
+		f.history[symbol] = history
+	}
+
+	for _, rule := range f.rules {
+		reason := rule.Check(symbol, tick, history)
+		if reason == "" {
+			continue
+		}
+
+		f.rejected[rule.Name()]++
+		entry := f.Logger.WithFields(logrus.Fields{
+			"symbol": symbol,
+			"rule":   rule.Name(),
+			"price":  tick.Price,
+			"time":   tick.Time,
+		})
+		// Unchanged tickers are routine between exchange updates
+		if rule.Name() == RuleDuplicate {
+			entry.Debugf("🚫 Tick rejected: %s", reason)
+		} else {
+			entry.Warnf("🚫 Tick rejected: %s", reason)
+		}
+
+		if f.quarantine {
+			f.quarantined = append(f.quarantined, models.QuarantinedTick{
+				Symbol:      symbol,
+				Price:       tick.Price,
+				Volume:      tick.Volume,
+				QuoteVolume: tick.QuoteVolume,
+				TickTime:    tick.Time,
+				Rule:        rule.Name(),
+				Reason:      reason,
+				ReceivedAt:  time.Now().UnixMilli(),
+			})
+		}
+		return false
+	}
+
+	history.Last = tick
+	history.Accepted++
+	history.Recent = append(history.Recent, tick.Price.InexactFloat64())
+	if len(history.Recent) > f.window {
+		history.Recent = history.Recent[len(history.Recent)-f.window:]
+	}
+	return true
+}
+
+// Rejected returns the number of rejected ticks per rule since startup.
+func (f *TickFilter) Rejected() map[string]int64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	counts := make(map[string]int64, len(f.rejected))
+	for rule, n := range f.rejected {
+		counts[rule] = n
+	}
+	return counts
+}
+
+// ExtractQuarantined returns the ticks rejected since the previous call.
+func (f *TickFilter) ExtractQuarantined() []models.QuarantinedTick {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	ticks := f.quarantined
+	f.quarantined = nil
+	return ticks
+}
+
+type nonPositiveRule struct{}
+
+func (nonPositiveRule) Name() string { return RuleNonPositive }
+
+func (nonPositiveRule) Check(_ string, tick TickData, _ *TickHistory) string {
+	if !tick.Price.IsPositive() {
+		return fmt.Sprintf("price %v is not positive", tick.Price)
+	}
+	if tick.Volume.IsNegative() || tick.QuoteVolume.IsNegative() {
+		return "negative volume"
+	}
+	return ""
+}
+
+type staleRule struct {
+	maxAge int64 // ms
+}
+
+func (staleRule) Name() string { return RuleStale }
+
+// Check only looks at the clock; ticks arriving out of order are left to the aggregator's lateness handling.
+func (r staleRule) Check(_ string, tick TickData, _ *TickHistory) string {
+	if age := time.Now().UnixMilli() - tick.Time; age > r.maxAge {
+		return fmt.Sprintf("timestamp is %ds old", age/1000)
+	}
+	return ""
+}
+
+type duplicateRule struct{}
+
+func (duplicateRule) Name() string { return RuleDuplicate }
+
+func (duplicateRule) Check(_ string, tick TickData, history *TickHistory) string {
+	if history.Accepted > 0 && tick.Equal(history.Last) {
+		return "identical to the last accepted tick"
+	}
+	return ""
+}
+
+// jumpRule rejects prices further from the recent median than multiplier × MAD, but never
+// within minJumpRatio of the median so flat markets do not reject every move. After
+// maxConsecutive rejections in a row the price is taken as a new level and the window resets.
+type jumpRule struct {
+	multiplier     float64
+	minSamples     int
+	minJumpRatio   float64
+	maxConsecutive int
+}
+
+func (jumpRule) Name() string { return RuleJump }
+
+func (r jumpRule) Check(_ string, tick TickData, history *TickHistory) string {
+	if len(history.Recent) < r.minSamples {
+		return ""
+	}
+	if history.ConsecutiveJumps >= r.maxConsecutive {
+		history.ConsecutiveJumps = 0
+		history.Recent = history.Recent[:0]
+		return ""
+	}
+
+	median := medianOf(history.Recent)
+	deviations := make([]float64, len(history.Recent))
+	for i, p := range history.Recent {
+		deviations[i] = math.Abs(p - median)
+	}
+	mad := medianOf(deviations)
+
+	allowed := math.Max(r.multiplier*mad, r.minJumpRatio*median)
+	if jump := math.Abs(tick.Price.InexactFloat64() - median); jump > allowed {
+		history.ConsecutiveJumps++
+		return fmt.Sprintf("price %v is %.4g from the median %v (allowed %.4g)", tick.Price, jump, median, allowed)
+	}
+	history.ConsecutiveJumps = 0
+	return ""
+}
+
+func medianOf(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
@@ -0,0 +1,157 @@
+package aggregator
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"scanner.magictradebot.com/config"
+)
+
+func newTestFilter(t *testing.T, cfg config.TickFilterSettings) *TickFilter {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	f, err := NewTickFilter(cfg, logger)
+	if err != nil {
+		t.Fatalf("NewTickFilter: %v", err)
+	}
+	return f
+}
+
+// warmUp feeds prices around 100 whose median absolute deviation is 0.5, so the jump
+// rule allows 10 × 0.5 = 5 either side of the median.
+func warmUp(t *testing.T, f *TickFilter, symbol string, now int64) {
+	t.Helper()
+	for i, price := range []string{"99.5", "100", "100.5", "99.5", "100", "100.5", "99.5", "100", "100.5", "100"} {
+		if !f.Accept(symbol, tick(price, "1", now+int64(i))) {
+			t.Fatalf("warm-up price %s was rejected", price)
+		}
+	}
+}
+
+func TestMadJumpRule(t *testing.T) {
+	tests := []struct {
+		price  string
+		accept bool
+	}{
+		{price: "104", accept: true},
+		{price: "96", accept: true},
+		{price: "105.5", accept: false},
+		{price: "94", accept: false},
+		{price: "150", accept: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.price, func(t *testing.T) {
+			f := newTestFilter(t, config.TickFilterSettings{Rules: []string{RuleJump}})
+			now := time.Now().UnixMilli()
+			warmUp(t, f, "BTCUSDT", now)
+
+			if got := f.Accept("BTCUSDT", tick(tt.price, "1", now+100)); got != tt.accept {
+				t.Errorf("accepted = %v, want %v", got, tt.accept)
+			}
+		})
+	}
+}
+
+func TestMadJumpRuleFloorAndWarmUp(t *testing.T) {
+	f := newTestFilter(t, config.TickFilterSettings{Rules: []string{RuleJump}})
+	now := time.Now().UnixMilli()
+
+	// Too few samples for statistics: any price passes
+	if !f.Accept("FLATUSDT", tick("100", "1", now)) || !f.Accept("FLATUSDT", tick("300", "1", now+1)) {
+		t.Fatal("prices were checked before MadMinSamples were collected")
+	}
+
+	// A flat market has no deviation, so MinJumpPercent keeps small moves acceptable
+	for i := int64(0); i < 10; i++ {
+		f.Accept("ETHUSDT", tick("100", "1", now+i))
+	}
+	if !f.Accept("ETHUSDT", tick("101.9", "1", now+20)) {
+		t.Error("a 1.9% move in a flat market was rejected")
+	}
+	if f.Accept("ETHUSDT", tick("102.5", "1", now+21)) {
+		t.Error("a 2.5% move in a flat market was accepted")
+	}
+}
+
+func TestMadJumpRuleAcceptsNewLevel(t *testing.T) {
+	f := newTestFilter(t, config.TickFilterSettings{Rules: []string{RuleJump}, MaxConsecutiveRejects: 3})
+	now := time.Now().UnixMilli()
+	warmUp(t, f, "BTCUSDT", now)
+
+	for i := int64(0); i < 3; i++ {
+		if f.Accept("BTCUSDT", tick("150", "1", now+100+i)) {
+			t.Fatalf("jump %d was accepted before the limit", i+1)
+		}
+	}
+	if !f.Accept("BTCUSDT", tick("150", "1", now+200)) {
+		t.Fatal("the price was not taken as a new level after 3 rejections")
+	}
+	// The window restarted at the new level
+	if !f.Accept("BTCUSDT", tick("151", "1", now+201)) {
+		t.Error("a price near the new level was rejected")
+	}
+	if got := f.Rejected()[RuleJump]; got != 3 {
+		t.Errorf("rejected = %d, want 3", got)
+	}
+}
+
+func TestTickFilterQuarantine(t *testing.T) {
+	now := time.Now().UnixMilli()
+	tests := []struct {
+		name     string
+		tick     TickData
+		wantRule string
+	}{
+		{name: "non-positive price", tick: tick("0", "1", now+100), wantRule: RuleNonPositive},
+		{name: "negative volume", tick: tick("100", "-1", now+100), wantRule: RuleNonPositive},
+		{name: "stale", tick: tick("100", "1", now-10*60_000), wantRule: RuleStale},
+		{name: "duplicate", tick: tick("100", "1", now+9), wantRule: RuleDuplicate},
+		{name: "jump", tick: tick("150", "1", now+100), wantRule: RuleJump},
+	}
+	for _, tt := range tests {
+		for _, quarantine := range []bool{true, false} {
+			name := tt.name
+			if !quarantine {
+				name += " without quarantine"
+			}
+			t.Run(name, func(t *testing.T) {
+				f := newTestFilter(t, config.TickFilterSettings{Quarantine: quarantine})
+				warmUp(t, f, "BTCUSDT", now)
+
+				if f.Accept("BTCUSDT", tt.tick) {
+					t.Fatal("tick was accepted")
+				}
+				if got := f.Rejected()[tt.wantRule]; got != 1 {
+					t.Errorf("rejected by %s = %d, want 1", tt.wantRule, got)
+				}
+
+				quarantined := f.ExtractQuarantined()
+				if !quarantine {
+					if len(quarantined) != 0 {
+						t.Errorf("quarantined %d ticks with quarantine disabled", len(quarantined))
+					}
+					return
+				}
+				if len(quarantined) != 1 {
+					t.Fatalf("quarantined %d ticks, want 1", len(quarantined))
+				}
+				q := quarantined[0]
+				if q.Symbol != "BTCUSDT" || q.Rule != tt.wantRule || q.Reason == "" || q.TickTime != tt.tick.Time || !q.Price.Equal(tt.tick.Price) {
+					t.Errorf("quarantined tick = %+v", q)
+				}
+				if again := f.ExtractQuarantined(); len(again) != 0 {
+					t.Errorf("quarantined ticks were returned twice")
+				}
+			})
+		}
+	}
+}
+
+func TestTickFilterRejectsUnknownRule(t *testing.T) {
+	if _, err := NewTickFilter(config.TickFilterSettings{Rules: []string{"spread"}}, logrus.New()); err == nil {
+		t.Error("unknown rule was accepted")
+	}
+}
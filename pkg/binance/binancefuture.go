@@ -14,6 +14,8 @@ type TickerInfo struct {
 	PriceChangePercent string `json:"priceChangePercent"`
 	LastPrice          string `json:"lastPrice"`
 	Volume             string `json:"volume"`
+	QuoteVolume        string `json:"quoteVolume"`
+	CloseTime          int64  `json:"closeTime"`
 }
 
 // shared rate-limited client instance
@@ -21,6 +23,11 @@ var sharedClient = NewRateLimitedClient(&http.Client{
 	Timeout: 10 * time.Second,
 })
 
+// RateLimitBudget reports the remaining request budget of the shared client.
+func RateLimitBudget() (remaining, limit int, ok bool) {
+	return sharedClient.Budget()
+}
+
 func GetAllTickers() ([]*TickerInfo, error) {
 	url := "https://fapi.binance.com/fapi/v1/ticker/24hr"
 	req, err := http.NewRequest(http.MethodGet, url, nil)
@@ -71,3 +78,128 @@ func GetTickerInfo(symbol string) (*TickerInfo, error) {
 	}
 	return &data, nil
 }
+
+// Kline is one candle as reported by the exchange.
+type Kline struct {
+	OpenTime    int64
+	Open        string
+	High        string
+	Low         string
+	Close       string
+	Volume      string
+	QuoteVolume string
+}
+
+// KlineInterval maps a collector interval label to Binance's, reporting false when unsupported.
+func KlineInterval(label string) (string, bool) {
+	switch label {
+	case "1m", "3m", "5m", "15m", "30m", "1h", "2h", "4h", "6h", "8h", "12h", "1d", "3d", "1w", "1M":
+		return label, true
+	}
+	return "", false
+}
+
+// GetKlines fetches the candles of a symbol opening within [startTime, endTime] (unix ms).
+func GetKlines(symbol, interval string, startTime, endTime int64, limit int) ([]*Kline, error) {
+	url := fmt.Sprintf("https://fapi.binance.com/fapi/v1/klines?symbol=%s&interval=%s&startTime=%d&endTime=%d&limit=%d",
+		symbol, interval, startTime, endTime, limit)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := sharedClient.SendWithRetry(req, 5)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non-200 response: %d\n%s", resp.StatusCode, string(body))
+	}
+
+	// [openTime, open, high, low, close, volume, closeTime, quoteVolume, trades, ...]
+	var rows [][]interface{}
+	if err := json.Unmarshal(body, &rows); err != nil {
+		return nil, err
+	}
+
+	klines := make([]*Kline, 0, len(rows))
+	for _, row := range rows {
+		if len(row) < 8 {
+			continue
+		}
+		openTime, ok := row[0].(float64)
+		if !ok {
+			continue
+		}
+		klines = append(klines, &Kline{
+			OpenTime:    int64(openTime),
+			Open:        fmt.Sprint(row[1]),
+			High:        fmt.Sprint(row[2]),
+			Low:         fmt.Sprint(row[3]),
+			Close:       fmt.Sprint(row[4]),
+			Volume:      fmt.Sprint(row[5]),
+			QuoteVolume: fmt.Sprint(row[7]),
+		})
+	}
+	return klines, nil
+}
+
+// Instrument holds the price and quantity increments of a contract.
+type Instrument struct {
+	Symbol   string
+	TickSize string
+	StepSize string
+}
+
+// GetInstruments fetches the tick and lot sizes of every USDT-M futures contract.
+func GetInstruments() ([]*Instrument, error) {
+	url := "https://fapi.binance.com/fapi/v1/exchangeInfo"
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := sharedClient.SendWithRetry(req, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non-200 response: %d\n%s", resp.StatusCode, string(body))
+	}
+
+	var parsed struct {
+		Symbols []struct {
+			Symbol  string `json:"symbol"`
+			Filters []struct {
+				FilterType string `json:"filterType"`
+				TickSize   string `json:"tickSize"`
+				StepSize   string `json:"stepSize"`
+			} `json:"filters"`
+		} `json:"symbols"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, err
+	}
+
+	instruments := make([]*Instrument, 0, len(parsed.Symbols))
+	for _, s := range parsed.Symbols {
+		instrument := &Instrument{Symbol: s.Symbol}
+		for _, f := range s.Filters {
+			switch f.FilterType {
+			case "PRICE_FILTER":
+				instrument.TickSize = f.TickSize
+			case "LOT_SIZE":
+				instrument.StepSize = f.StepSize
+			}
+		}
+		instruments = append(instruments, instrument)
+	}
+	return instruments, nil
+}
@@ -153,3 +153,27 @@ func isTransient(err error) bool {
 		strings.Contains(err.Error(), "timeout") ||
 		strings.Contains(err.Error(), "connection reset")
 }
+
+// Budget returns the most constrained rate limit the exchange reported that has not reset yet.
+// ok is false until the exchange has reported a limit.
+func (c *RateLimitedClient) Budget() (remaining, limit int, ok bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	lowest := 2.0
+	for _, info := range c.rateLimits {
+		if info.limit <= 0 || now.After(info.resetTime) {
+			continue
+		}
+		left := info.limit - info.used
+		if left < 0 {
+			left = 0
+		}
+		if share := float64(left) / float64(info.limit); share < lowest {
+			lowest = share
+			remaining, limit, ok = left, info.limit, true
+		}
+	}
+	return remaining, limit, ok
+}
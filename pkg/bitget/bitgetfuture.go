@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -26,6 +28,11 @@ var sharedClient = NewRateLimitedClient(&http.Client{
 	Timeout: 10 * time.Second,
 })
 
+// RateLimitBudget reports the remaining request budget of the shared client.
+func RateLimitBudget() (remaining, limit int, ok bool) {
+	return sharedClient.Budget()
+}
+
 // GetAllTickers fetches all USDT-margined perpetual futures tickers from Bitget
 func GetAllTickers() ([]*BitgetTickerInfo, error) {
 	url := "https://api.bitget.com/api/mix/v1/market/tickers?productType=umcbl"
@@ -96,3 +103,135 @@ func GetTickerInfo(symbol string) (*BitgetTickerInfo, error) {
 	}
 	return parsed.Data[0], nil
 }
+
+// Kline is one candle as reported by the exchange.
+type Kline struct {
+	OpenTime    int64
+	Open        string
+	High        string
+	Low         string
+	Close       string
+	Volume      string
+	QuoteVolume string
+}
+
+// KlineInterval maps a collector interval label to Bitget's UTC-aligned granularity, reporting false when unsupported.
+func KlineInterval(label string) (string, bool) {
+	intervals := map[string]string{
+		"1m": "1m", "3m": "3m", "5m": "5m", "15m": "15m", "30m": "30m",
+		"1h": "1H", "2h": "2H", "4h": "4H", "6h": "6Hutc", "12h": "12Hutc",
+		"1d": "1Dutc", "3d": "3Dutc", "1w": "1Wutc", "1M": "1Mutc",
+	}
+	interval, ok := intervals[label]
+	return interval, ok
+}
+
+// GetKlines fetches the candles of a mix symbol (e.g. BTCUSDT_UMCBL) opening within [startTime, endTime] (unix ms).
+func GetKlines(symbol, granularity string, startTime, endTime int64, limit int) ([]*Kline, error) {
+	url := fmt.Sprintf("https://api.bitget.com/api/mix/v1/market/candles?symbol=%s&granularity=%s&startTime=%d&endTime=%d&limit=%d",
+		symbol, granularity, startTime, endTime, limit)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := sharedClient.SendWithRetry(req, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non-200 response: %d\n%s", resp.StatusCode, string(body))
+	}
+
+	// [ts, open, high, low, close, baseVolume, quoteVolume]
+	var rows [][]string
+	if err := json.Unmarshal(body, &rows); err != nil {
+		return nil, err
+	}
+
+	klines := make([]*Kline, 0, len(rows))
+	for _, row := range rows {
+		if len(row) < 7 {
+			continue
+		}
+		openTime, err := strconv.ParseInt(row[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		klines = append(klines, &Kline{
+			OpenTime:    openTime,
+			Open:        row[1],
+			High:        row[2],
+			Low:         row[3],
+			Close:       row[4],
+			Volume:      row[5],
+			QuoteVolume: row[6],
+		})
+	}
+	return klines, nil
+}
+
+// Instrument holds the price and quantity increments of a contract.
+type Instrument struct {
+	Symbol         string `json:"symbol"`
+	PricePlace     string `json:"pricePlace"`     // decimals of the price
+	PriceEndStep   string `json:"priceEndStep"`   // tick size in units of the last price decimal
+	SizeMultiplier string `json:"sizeMultiplier"` // quantity step
+}
+
+// TickSize returns the price increment, e.g. pricePlace 2 and priceEndStep 5 give 0.05.
+func (i *Instrument) TickSize() string {
+	places, err := strconv.Atoi(i.PricePlace)
+	if err != nil || places < 0 {
+		return ""
+	}
+	step := i.PriceEndStep
+	if step == "" {
+		step = "1"
+	}
+	if places == 0 {
+		return step
+	}
+	if len(step) <= places {
+		step = strings.Repeat("0", places-len(step)+1) + step
+	}
+	return step[:len(step)-places] + "." + step[len(step)-places:]
+}
+
+// GetInstruments fetches the price and size precision of every USDT-margined contract.
+func GetInstruments() ([]*Instrument, error) {
+	url := "https://api.bitget.com/api/mix/v1/market/contracts?productType=umcbl"
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := sharedClient.SendWithRetry(req, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non-200 response: %d\n%s", resp.StatusCode, string(body))
+	}
+
+	var parsed struct {
+		Code string        `json:"code"`
+		Msg  string        `json:"msg"`
+		Data []*Instrument `json:"data"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, err
+	}
+	if parsed.Code != "00000" {
+		return nil, fmt.Errorf("bitget API error: %s", parsed.Msg)
+	}
+	return parsed.Data, nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -18,6 +19,7 @@ type BybitTickerInfo struct {
 	PrevPrice24h string `json:"prevPrice24h"`
 	Turnover24h  string `json:"turnover24h"`
 	Volume24h    string `json:"volume24h"`
+	Timestamp    int64  `json:"-"` // taken from the response envelope
 }
 
 // shared rate-limited client instance
@@ -25,6 +27,11 @@ var sharedClient = NewRateLimitedClient(&http.Client{
 	Timeout: 10 * time.Second,
 })
 
+// RateLimitBudget reports the remaining request budget of the shared client.
+func RateLimitBudget() (remaining, limit int, ok bool) {
+	return sharedClient.Budget()
+}
+
 // GetAllTickers fetches all USDT perpetual tickers from Bybit
 func GetAllTickers() ([]*BybitTickerInfo, error) {
 	url := "https://api.bybit.com/v5/market/tickers?category=linear"
@@ -48,6 +55,7 @@ func GetAllTickers() ([]*BybitTickerInfo, error) {
 	var parsed struct {
 		RetCode int    `json:"retCode"`
 		RetMsg  string `json:"retMsg"`
+		Time    int64  `json:"time"`
 		Result  struct {
 			List []*BybitTickerInfo `json:"list"`
 		} `json:"result"`
@@ -61,6 +69,10 @@ func GetAllTickers() ([]*BybitTickerInfo, error) {
 		return nil, fmt.Errorf("API error: %s", parsed.RetMsg)
 	}
 
+	for _, t := range parsed.Result.List {
+		t.Timestamp = parsed.Time
+	}
+
 	return parsed.Result.List, nil
 }
 
@@ -87,6 +99,7 @@ func GetTickerInfo(symbol string) (*BybitTickerInfo, error) {
 	var parsed struct {
 		RetCode int    `json:"retCode"`
 		RetMsg  string `json:"retMsg"`
+		Time    int64  `json:"time"`
 		Result  struct {
 			List []*BybitTickerInfo `json:"list"`
 		} `json:"result"`
@@ -104,5 +117,157 @@ func GetTickerInfo(symbol string) (*BybitTickerInfo, error) {
 		return nil, fmt.Errorf("no data returned for symbol %s", symbol)
 	}
 
+	parsed.Result.List[0].Timestamp = parsed.Time
 	return parsed.Result.List[0], nil
 }
+
+// Kline is one candle as reported by the exchange.
+type Kline struct {
+	OpenTime    int64
+	Open        string
+	High        string
+	Low         string
+	Close       string
+	Volume      string
+	QuoteVolume string
+}
+
+// KlineInterval maps a collector interval label to Bybit's, reporting false when unsupported.
+func KlineInterval(label string) (string, bool) {
+	intervals := map[string]string{
+		"1m": "1", "3m": "3", "5m": "5", "15m": "15", "30m": "30",
+		"1h": "60", "2h": "120", "4h": "240", "6h": "360", "12h": "720",
+		"1d": "D", "1w": "W", "1M": "M",
+	}
+	interval, ok := intervals[label]
+	return interval, ok
+}
+
+// GetKlines fetches the candles of a linear symbol opening within [startTime, endTime] (unix ms).
+// The candle still in progress is included when endTime reaches it.
+func GetKlines(symbol, interval string, startTime, endTime int64, limit int) ([]*Kline, error) {
+	url := fmt.Sprintf("https://api.bybit.com/v5/market/kline?category=linear&symbol=%s&interval=%s&start=%d&end=%d&limit=%d",
+		symbol, interval, startTime, endTime, limit)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := sharedClient.SendWithRetry(req, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non-200 response: %d\n%s", resp.StatusCode, string(body))
+	}
+
+	// [startTime, open, high, low, close, volume, turnover], newest first
+	var parsed struct {
+		RetCode int    `json:"retCode"`
+		RetMsg  string `json:"retMsg"`
+		Result  struct {
+			List [][]string `json:"list"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, err
+	}
+	if parsed.RetCode != 0 {
+		return nil, fmt.Errorf("API error: %s", parsed.RetMsg)
+	}
+
+	klines := make([]*Kline, 0, len(parsed.Result.List))
+	for _, row := range parsed.Result.List {
+		if len(row) < 7 {
+			continue
+		}
+		openTime, err := strconv.ParseInt(row[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		klines = append(klines, &Kline{
+			OpenTime:    openTime,
+			Open:        row[1],
+			High:        row[2],
+			Low:         row[3],
+			Close:       row[4],
+			Volume:      row[5],
+			QuoteVolume: row[6],
+		})
+	}
+	return klines, nil
+}
+
+// Instrument holds the price and quantity increments of a contract.
+type Instrument struct {
+	Symbol   string
+	TickSize string
+	StepSize string
+}
+
+// GetInstruments fetches the tick and lot sizes of every linear contract, following the
+// pagination cursor.
+func GetInstruments() ([]*Instrument, error) {
+	var instruments []*Instrument
+	cursor := ""
+	for {
+		url := "https://api.bybit.com/v5/market/instruments-info?category=linear&limit=1000"
+		if cursor != "" {
+			url += "&cursor=" + cursor
+		}
+
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := sharedClient.SendWithRetry(req, 1)
+		if err != nil {
+			return nil, err
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("non-200 response: %d\n%s", resp.StatusCode, string(body))
+		}
+
+		var parsed struct {
+			RetCode int    `json:"retCode"`
+			RetMsg  string `json:"retMsg"`
+			Result  struct {
+				List []struct {
+					Symbol      string `json:"symbol"`
+					PriceFilter struct {
+						TickSize string `json:"tickSize"`
+					} `json:"priceFilter"`
+					LotSizeFilter struct {
+						QtyStep string `json:"qtyStep"`
+					} `json:"lotSizeFilter"`
+				} `json:"list"`
+				NextPageCursor string `json:"nextPageCursor"`
+			} `json:"result"`
+		}
+		if err := json.Unmarshal(body, &parsed); err != nil {
+			return nil, err
+		}
+		if parsed.RetCode != 0 {
+			return nil, fmt.Errorf("API error: %s", parsed.RetMsg)
+		}
+
+		for _, i := range parsed.Result.List {
+			instruments = append(instruments, &Instrument{
+				Symbol:   i.Symbol,
+				TickSize: i.PriceFilter.TickSize,
+				StepSize: i.LotSizeFilter.QtyStep,
+			})
+		}
+		if parsed.Result.NextPageCursor == "" || len(parsed.Result.List) == 0 {
+			return instruments, nil
+		}
+		cursor = parsed.Result.NextPageCursor
+	}
+}
@@ -14,13 +14,25 @@ import (
 
 	"scanner.magictradebot.com/config"
 	"scanner.magictradebot.com/models"
+	"scanner.magictradebot.com/pkg/storage"
 )
 
-var GormDB *gorm.DB
+// GormRepository is the GORM-backed store used for SQLite, PostgreSQL and MySQL.
+type GormRepository struct {
+	db       *gorm.DB
+	exchange string
+	instance string
+	scale    DecimalScale
+	postgres config.PostgresSettings
+	log      *logrus.Logger
+}
+
+var _ storage.Store = (*GormRepository)(nil)
 
-func InitDB(log *logrus.Logger) {
-	provider := config.Settings.Database.Provider
-	conn := config.Settings.Database.ConnectionString
+// Open connects to the configured database and verifies the connection.
+func Open(cfg config.DatabaseSettings, log *logrus.Logger) (*gorm.DB, error) {
+	provider := cfg.Provider
+	conn := cfg.ConnectionString
 
 	var db *gorm.DB
 	var err error
@@ -32,7 +44,7 @@ func InitDB(log *logrus.Logger) {
 		}
 		db, err = gorm.Open(sqlite.Open(conn), &gorm.Config{})
 		if err != nil {
-			log.Fatalf("❌ Failed to open SQLite DB: %v", err)
+			return nil, fmt.Errorf("open SQLite DB: %w", err)
 		}
 		log.Infof("✅ SQLite connected: %s", conn)
 
@@ -48,7 +60,7 @@ func InitDB(log *logrus.Logger) {
 
 		// ✅ Minimal validation
 		if host == "" || port == "" || user == "" || password == "" || dbname == "" {
-			log.Fatal("❌ Missing one or more required DB environment variables: DB_HOST, DB_PORT, DB_USER, DB_PASSWORD, DB_NAME")
+			return nil, fmt.Errorf("missing one or more required DB environment variables: DB_HOST, DB_PORT, DB_USER, DB_PASSWORD, DB_NAME")
 		}
 
 		conn = fmt.Sprintf(
@@ -58,43 +70,63 @@ func InitDB(log *logrus.Logger) {
 
 		db, err = gorm.Open(postgres.Open(conn), &gorm.Config{})
 		if err != nil {
-			log.Fatalf("❌ Failed to connect to PostgreSQL: %v", err)
+			return nil, fmt.Errorf("connect to PostgreSQL: %w", err)
 		}
 		log.Infof("✅ PostgreSQL connected")
 
+	case "mysql", "mariadb":
+		db, err = openMySQL(conn)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("✅ MySQL connected")
+
 	default:
-		log.Fatalf("❌ Unknown DB provider: %s", provider)
+		return nil, fmt.Errorf("unknown DB provider: %s", provider)
 	}
 
 	sqlDB, err := db.DB()
 	if err != nil {
-		log.Fatalf("❌ Failed to extract sql.DB: %v", err)
+		return nil, fmt.Errorf("extract sql.DB: %w", err)
 	}
 	if err := sqlDB.Ping(); err != nil {
-		log.Fatalf("❌ DB ping failed: %v", err)
+		return nil, fmt.Errorf("DB ping failed: %w", err)
 	}
 
-	GormDB = db
+	return db, nil
+}
+
+// NewGormRepository stores rows through db, tagging klines with the exchange and collector instance.
+// The price and volume columns keep the decimals given by scale.
+func NewGormRepository(db *gorm.DB, exchange, instance string, scale DecimalScale, postgres config.PostgresSettings, log *logrus.Logger) *GormRepository {
+	return &GormRepository{db: db, exchange: strings.ToLower(exchange), instance: instance, scale: scale, postgres: postgres, log: log}
+}
+
+// Migrate applies every pending schema migration, then widens the decimal columns if a listed
+// instrument now trades in finer increments than they hold.
+func (r *GormRepository) Migrate() error {
+	if _, err := NewMigrator(r.db, r.exchange, r.scale, r.postgres, r.log).Up(0); err != nil {
+		return err
+	}
+	return alterDecimalColumns(r.db, r.scale, true, r.log)
 }
 
-func AutoMigrate() error {
-	// Safe — this will NOT drop existing tables or data
-	return GormDB.AutoMigrate(
-		&models.SymbolKlineData{},
-	)
+// Close releases the database connection.
+func (r *GormRepository) Close() error {
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
 }
 
-func SaveKlines(data []models.SymbolKlineData, instance string, log *logrus.Logger) error {
+func (r *GormRepository) SaveKlines(data []models.SymbolKlineData) error {
 	if len(data) == 0 {
-		log.WithField("instance", instance).Info("📭 No klines to insert")
+		r.log.WithField("instance", r.instance).Info("📭 No klines to insert")
 		return nil
 	}
 
-	// Set instance and clean symbol
-	for i := range data {
-		data[i].Instance = instance
-		data[i].Symbol = cleanSymbol(data[i].Symbol) // <-- Apply cleaner here
-	}
+	data = prepareKlines(data, r.exchange, r.instance)
 
 	// Group data by symbol and interval for detailed logging
 	type logKey struct {
@@ -109,60 +141,468 @@ func SaveKlines(data []models.SymbolKlineData, instance string, log *logrus.Logg
 		logSummary[key]++
 	}
 
-	result := GormDB.Clauses(clause.OnConflict{
+	inserted, err := r.insertKlines(data)
+	if err != nil {
+		return err
+	}
+
+	for key, count := range logSummary {
+		r.log.WithFields(logrus.Fields{
+			"instance":  r.instance,
+			"symbol":    key.Symbol,
+			"interval":  key.Interval,
+			"attempted": count,
+			"inserted":  inserted, // optional: total inserted
+		}).Infof("✅ Saved klines for %s [%s]", key.Symbol, key.Interval)
+	}
+
+	r.log.WithFields(logrus.Fields{
+		"instance":  r.instance,
+		"attempted": len(data),
+		"inserted":  inserted,
+	}).Info("✅ Saved all OHLC entries to DB")
+
+	return nil
+}
+
+// insertKlines writes prepared klines, skipping ones already stored, and returns how many were new.
+func (r *GormRepository) insertKlines(data []models.SymbolKlineData) (int64, error) {
+	if r.useCopy(len(data)) {
+		n, err := r.copyKlines(data, false)
+		if err != nil {
+			return 0, fmt.Errorf("copy insert failed: %w", err)
+		}
+		return n, nil
+	}
+
+	result := r.db.Clauses(clause.OnConflict{
+		Columns:   klineKeyColumns(),
+		DoNothing: true,
+	}).CreateInBatches(data, 100)
+
+	if result.Error != nil {
+		return 0, fmt.Errorf("insert failed: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// UpsertKlines overwrites stored klines with amended versions.
+// A row is only replaced when the incoming candle carries a higher amendment count,
+// so a stale amendment arriving out of order never overwrites a newer one.
+func (r *GormRepository) UpsertKlines(data []models.SymbolKlineData) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	data = prepareKlines(data, r.exchange, r.instance)
+
+	var updated int64
+	if r.useCopy(len(data)) {
+		n, err := r.copyKlines(data, true)
+		if err != nil {
+			return fmt.Errorf("copy upsert failed: %w", err)
+		}
+		updated = n
+	} else {
+		var onConflict clause.Expression = clause.OnConflict{
+			Columns:   klineKeyColumns(),
+			DoUpdates: clause.AssignmentColumns(amendedColumns),
+			Where: clause.Where{Exprs: []clause.Expression{
+				clause.Lt{
+					Column: clause.Column{Table: clause.CurrentTable, Name: "amendments"},
+					Value:  clause.Column{Table: "excluded", Name: "amendments"},
+				},
+			}},
+		}
+		if r.db.Dialector.Name() == "mysql" {
+			onConflict = mysqlAmendUpsert(r.db)
+		}
+		result := r.db.Clauses(onConflict).CreateInBatches(data, 100)
+
+		if result.Error != nil {
+			return fmt.Errorf("upsert failed: %w", result.Error)
+		}
+		updated = result.RowsAffected
+	}
+
+	r.log.WithFields(logrus.Fields{
+		"instance":  r.instance,
+		"attempted": len(data),
+		"updated":   updated,
+	}).Info("♻️ Upserted amended OHLC entries")
+
+	return nil
+}
+
+// SaveOfficialKlines stores candles published by the exchange and marks the matching
+// aggregated candles as superseded. Official candles are overwritten when fetched again.
+func (r *GormRepository) SaveOfficialKlines(data []models.SymbolKlineData) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	data = prepareKlines(data, r.exchange, r.instance)
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{
+			Columns: klineKeyColumns(),
+			DoUpdates: clause.AssignmentColumns([]string{
+				"open", "high", "low", "close", "volume", "turnover", "vwap", "typical_price", "instance",
+			}),
+		}).CreateInBatches(data, 100)
+		if result.Error != nil {
+			return fmt.Errorf("upsert official klines failed: %w", result.Error)
+		}
+
+		type key struct {
+			Exchange string
+			Symbol   string
+			Interval string
+		}
+		openTimes := make(map[key][]int64)
+		for _, k := range data {
+			id := key{k.Exchange, k.Symbol, k.Interval}
+			openTimes[id] = append(openTimes[id], k.OpenTime)
+		}
+		for k, times := range openTimes {
+			err := tx.Model(&models.SymbolKlineData{}).
+				Where(map[string]interface{}{"exchange": k.Exchange, "symbol": k.Symbol, "interval": k.Interval, "source": models.SourceAggregated}).
+				Where("open_time IN ?", times).
+				Update("superseded", true).Error
+			if err != nil {
+				return fmt.Errorf("mark superseded klines failed: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.log.WithFields(logrus.Fields{
+		"instance": r.instance,
+		"count":    len(data),
+	}).Info("🏛️ Saved official exchange klines")
+
+	return nil
+}
+
+// SaveBars inserts completed information bars. Bars re-emitted after a journal replay
+// carry the same sequence number and are skipped; any other bar whose sequence is
+// already taken is reported as an error.
+func (r *GormRepository) SaveBars(data []models.SymbolBarData) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	data = append([]models.SymbolBarData(nil), data...)
+	for i := range data {
+		data[i].Instance = r.instance
+		data[i].Exchange = r.exchange
+		data[i].Symbol = storage.StoredSymbol(data[i].Symbol)
+	}
+
+	result := r.db.Clauses(clause.OnConflict{
 		Columns: []clause.Column{
 			{Name: "symbol"},
-			{Name: "interval"},
-			{Name: "open_time"},
+			{Name: "bar_type"},
+			{Name: "sequence"},
+			{Name: "exchange"},
 		},
 		DoNothing: true,
 	}).CreateInBatches(data, 100)
 
 	if result.Error != nil {
-		return fmt.Errorf("insert failed: %w", result.Error)
+		return fmt.Errorf("insert bars failed: %w", result.Error)
 	}
 
-	for key, count := range logSummary {
-		log.WithFields(logrus.Fields{
-			"instance":  instance,
-			"symbol":    key.Symbol,
-			"interval":  key.Interval,
-			"attempted": count,
-			"inserted":  result.RowsAffected, // optional: total inserted
-		}).Infof("✅ Saved klines for %s [%s]", key.Symbol, key.Interval)
+	r.log.WithFields(logrus.Fields{
+		"instance":  r.instance,
+		"attempted": len(data),
+		"inserted":  result.RowsAffected,
+	}).Info("✅ Saved information bars to DB")
+
+	if result.RowsAffected < int64(len(data)) {
+		return r.checkSkippedBars(data)
+	}
+	return nil
+}
+
+// checkSkippedBars compares bars against the stored ones with the same sequence. A replayed
+// bar matches its stored copy; anything else means two different bars share a sequence.
+func (r *GormRepository) checkSkippedBars(data []models.SymbolBarData) error {
+	type barKey struct {
+		symbol, barType string
+		sequence        int64
+	}
+	stored := make(map[barKey]models.SymbolBarData, len(data))
+	for start := 0; start < len(data); start += 100 {
+		end := min(start+100, len(data))
+		keys := make([][]interface{}, 0, end-start)
+		for _, b := range data[start:end] {
+			keys = append(keys, []interface{}{b.Symbol, b.BarType, b.Sequence})
+		}
+
+		var rows []models.SymbolBarData
+		err := r.db.Where("exchange = ? AND (symbol, bar_type, sequence) IN ?", r.exchange, keys).Find(&rows).Error
+		if err != nil {
+			return fmt.Errorf("load stored bars: %w", err)
+		}
+		for _, b := range rows {
+			stored[barKey{b.Symbol, b.BarType, b.Sequence}] = b
+		}
+	}
+
+	var conflicts []string
+	for _, b := range data {
+		s, ok := stored[barKey{b.Symbol, b.BarType, b.Sequence}]
+		if !ok || (s.OpenTime == b.OpenTime && s.CloseTime == b.CloseTime && s.TickCount == b.TickCount) {
+			continue
+		}
+		conflicts = append(conflicts, fmt.Sprintf("%s %s #%d", b.Symbol, b.BarType, b.Sequence))
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%d bars were not saved because their sequence is taken by a different bar: %s",
+			len(conflicts), strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// NextBarSequences returns the sequence the next bar of each of the exchange's stored symbols
+// and bar types takes.
+func (r *GormRepository) NextBarSequences() (map[string]map[string]int64, error) {
+	var rows []struct {
+		Symbol  string
+		BarType string
+		Last    int64
+	}
+	err := r.db.Model(&models.SymbolBarData{}).
+		Select("symbol, bar_type, MAX(sequence) AS last").
+		Where("exchange = ?", r.exchange).
+		Group("symbol, bar_type").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("load bar sequences: %w", err)
+	}
+
+	next := make(map[string]map[string]int64)
+	for _, row := range rows {
+		if next[row.Symbol] == nil {
+			next[row.Symbol] = make(map[string]int64)
+		}
+		next[row.Symbol][row.BarType] = row.Last + 1
+	}
+	return next, nil
+}
+
+// SaveQuarantinedTicks stores ticks rejected by the tick filter.
+func (r *GormRepository) SaveQuarantinedTicks(data []models.QuarantinedTick) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	data = append([]models.QuarantinedTick(nil), data...)
+	for i := range data {
+		data[i].Instance = r.instance
+	}
+
+	if err := r.db.CreateInBatches(data, 100).Error; err != nil {
+		return fmt.Errorf("insert quarantined ticks failed: %w", err)
+	}
+
+	r.log.WithFields(logrus.Fields{
+		"instance": r.instance,
+		"count":    len(data),
+	}).Info("🧪 Quarantined rejected ticks")
+
+	return nil
+}
+
+// SaveSecondKlines writes a batch of sub-minute candles to their own table.
+func (r *GormRepository) SaveSecondKlines(data []models.SymbolSecondKlineData) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	data = append([]models.SymbolSecondKlineData(nil), data...)
+	for i := range data {
+		data[i].Instance = r.instance
+		data[i].Exchange = r.exchange
+		data[i].Symbol = storage.StoredSymbol(data[i].Symbol)
+	}
+
+	result := r.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{
+			{Name: "symbol"},
+			{Name: "interval"},
+			{Name: "open_time"},
+			{Name: "exchange"},
+		},
+		DoNothing: true,
+	}).CreateInBatches(data, 500)
+
+	if result.Error != nil {
+		return fmt.Errorf("insert sub-minute klines failed: %w", result.Error)
 	}
 
-	log.WithFields(logrus.Fields{
-		"instance":  instance,
+	r.log.WithFields(logrus.Fields{
+		"instance":  r.instance,
 		"attempted": len(data),
 		"inserted":  result.RowsAffected,
-	}).Info("✅ Saved all OHLC entries to DB")
+	}).Info("✅ Saved sub-minute klines to DB")
 
 	return nil
 }
 
-func cleanSymbol(raw string) string {
-	raw = strings.ToUpper(raw) // Normalize
+// LoadKlines returns the exchange's stored candles of one source opening within [from, to]
+// (unix ms), oldest first. The symbol is normalized the same way it was when saved.
+func (r *GormRepository) LoadKlines(symbol, interval, source string, from, to int64) ([]models.SymbolKlineData, error) {
+	var rows []models.SymbolKlineData
+	err := r.db.
+		Where(map[string]interface{}{"exchange": r.exchange, "symbol": storage.StoredSymbol(symbol), "interval": interval, "source": source}).
+		Where("open_time BETWEEN ? AND ?", from, to).
+		Order("open_time").
+		Find(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("load klines failed: %w", err)
+	}
+	return rows, nil
+}
+
+// LatestKlines returns the exchange's most recent candle of every symbol for one interval and source.
+func (r *GormRepository) LatestKlines(interval, source string) ([]models.SymbolKlineData, error) {
+	filter := map[string]interface{}{"exchange": r.exchange, "interval": interval, "source": source}
+	latest := r.db.Model(&models.SymbolKlineData{}).
+		Select("symbol, MAX(open_time)").
+		Where(filter).
+		Group("symbol")
+
+	var rows []models.SymbolKlineData
+	err := r.db.
+		Where(filter).
+		Where("(symbol, open_time) IN (?)", latest).
+		Order("symbol").
+		Find(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("load latest klines failed: %w", err)
+	}
+	return rows, nil
+}
+
+// EachKline streams matching candles row by row, so large exports never sit in memory.
+func (r *GormRepository) EachKline(filter storage.KlineFilter, fn func(models.SymbolKlineData) error) error {
+	query := r.db.Model(&models.SymbolKlineData{})
 
-	// Case 1: Hyphen-based format
-	if strings.Contains(raw, "-") {
-		suffixes := []string{"-USDT-SWAP", "-USDT", "-USD-SWAP", "-USD", "-PERP", "-FUTURE", "-SWAP"}
-		for _, s := range suffixes {
-			if strings.HasSuffix(raw, s) {
-				return strings.TrimSuffix(raw, s)
+	conditions := map[string]interface{}{}
+	if filter.Exchange != "" {
+		conditions["exchange"] = strings.ToLower(filter.Exchange)
+	}
+	if filter.Interval != "" {
+		conditions["interval"] = filter.Interval
+	}
+	if filter.Source != "" {
+		conditions["source"] = filter.Source
+	}
+	if len(conditions) > 0 {
+		query = query.Where(conditions)
+	}
+
+	if len(filter.Symbols) > 0 {
+		symbols := r.db
+		for _, pattern := range filter.Symbols {
+			pattern = storage.StoredSymbol(pattern)
+			if storage.IsGlob(pattern) {
+				symbols = symbols.Or("symbol LIKE ? ESCAPE '!'", globToLike(pattern))
+			} else {
+				symbols = symbols.Or(map[string]interface{}{"symbol": pattern})
 			}
 		}
-		return raw
+		query = query.Where(symbols)
 	}
 
-	// Case 2: Concatenated format (e.g. BTCUSDT, ETHUSD)
-	quoteAssets := []string{"USDT", "USD", "BUSD", "USDC", "TUSD", "DAI", "USDT_UMCBL"}
-	for _, quote := range quoteAssets {
-		if strings.HasSuffix(raw, quote) {
-			return strings.TrimSuffix(raw, quote)
+	query = query.Where("open_time >= ?", filter.From)
+	if filter.To > 0 {
+		query = query.Where("open_time <= ?", filter.To)
+	}
+
+	rows, err := query.Order("symbol").Order("open_time").Rows()
+	if err != nil {
+		return fmt.Errorf("query klines failed: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var k models.SymbolKlineData
+		if err := r.db.ScanRows(rows, &k); err != nil {
+			return fmt.Errorf("scan kline failed: %w", err)
+		}
+		if err := fn(k); err != nil {
+			return err
 		}
 	}
+	return rows.Err()
+}
+
+// globToLike converts a symbol glob to an upper-cased LIKE pattern escaped with '!'. A backslash
+// would need different quoting on MySQL, where it also escapes string literals.
+func globToLike(pattern string) string {
+	replacer := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_", "*", "%", "?", "_")
+	return replacer.Replace(strings.ToUpper(pattern))
+}
+
+// PurgeSecondKlines deletes the exchange's sub-minute candles that opened before the cutoff (unix ms).
+func (r *GormRepository) PurgeSecondKlines(before int64) (int64, error) {
+	result := r.db.Where("exchange = ? AND open_time < ?", r.exchange, before).Delete(&models.SymbolSecondKlineData{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("purge sub-minute klines failed: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
 
-	// Default: return raw symbol
-	return raw
+// CountKlines returns how many of the exchange's candles of one interval opened before the
+// cutoff (unix ms), the open time of the oldest one and the highest id among them.
+func (r *GormRepository) CountKlines(interval string, before int64) (int64, int64, int64, error) {
+	var result struct {
+		Count  int64
+		Oldest *int64
+		MaxID  *int64
+	}
+	err := r.db.Model(&models.SymbolKlineData{}).
+		Select("COUNT(*) AS count, MIN(open_time) AS oldest, MAX(id) AS max_id").
+		Where(map[string]interface{}{"exchange": r.exchange, "interval": interval}).
+		Where("open_time < ?", before).
+		Scan(&result).Error
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("count klines failed: %w", err)
+	}
+	if result.Oldest == nil || result.MaxID == nil {
+		return 0, 0, 0, nil
+	}
+	return result.Count, *result.Oldest, *result.MaxID, nil
+}
+
+// PurgeKlines deletes the exchange's candles of one interval that opened before the cutoff
+// (unix ms) and were stored no later than the one with maxID.
+func (r *GormRepository) PurgeKlines(interval string, before, maxID int64) (int64, error) {
+	result := r.db.
+		Where(map[string]interface{}{"exchange": r.exchange, "interval": interval}).
+		Where("open_time < ? AND id <= ?", before, maxID).
+		Delete(&models.SymbolKlineData{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("purge klines failed: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// prepareKlines returns a copy of the klines with the exchange and instance set and the
+// symbols normalized, leaving the caller's slice to other sinks as it was.
+func prepareKlines(data []models.SymbolKlineData, exchange, instance string) []models.SymbolKlineData {
+	prepared := make([]models.SymbolKlineData, len(data))
+	for i, k := range data {
+		k.Exchange = exchange
+		k.Instance = instance
+		k.Symbol = storage.StoredSymbol(k.Symbol)
+		prepared[i] = k
+	}
+	return prepared
 }
@@ -0,0 +1,315 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/models"
+	"scanner.magictradebot.com/pkg/storage"
+)
+
+// newTestRepository migrates a fresh SQLite database for the exchange.
+func newTestRepository(t *testing.T, exchange string) *GormRepository {
+	t.Helper()
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	gormDB, err := Open(config.DatabaseSettings{
+		Provider:         "sqlite",
+		ConnectionString: filepath.Join(t.TempDir(), "test.db"),
+	}, log)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	repo := NewGormRepository(gormDB, exchange, "test", DecimalScale{Price: 8, Volume: 8}, config.PostgresSettings{}, log)
+	t.Cleanup(func() { _ = repo.Close() })
+	if err := repo.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return repo
+}
+
+func testBar(symbol string, sequence, openTime int64) models.SymbolBarData {
+	price := decimal.NewFromInt(100)
+	return models.SymbolBarData{
+		Symbol:    symbol,
+		BarType:   "tick",
+		Sequence:  sequence,
+		Open:      price,
+		High:      price,
+		Low:       price,
+		Close:     price,
+		TickCount: 10,
+		OpenTime:  openTime,
+		CloseTime: openTime + 1_000,
+	}
+}
+
+func TestSaveBarsSequences(t *testing.T) {
+	repo := newTestRepository(t, "binance")
+
+	first := []models.SymbolBarData{testBar("BTCUSDT", 0, 1_000), testBar("BTCUSDT", 1, 2_000), testBar("ETHUSDT", 0, 1_000)}
+	if err := repo.SaveBars(first); err != nil {
+		t.Fatalf("SaveBars: %v", err)
+	}
+
+	next, err := repo.NextBarSequences()
+	if err != nil {
+		t.Fatalf("NextBarSequences: %v", err)
+	}
+	if next["BTC"]["tick"] != 2 || next["ETH"]["tick"] != 1 {
+		t.Errorf("next sequences = %v, want BTC 2 and ETH 1", next)
+	}
+
+	// A replay re-emits the same bars, which are skipped quietly
+	replayed := []models.SymbolBarData{testBar("BTCUSDT", 1, 2_000), testBar("BTCUSDT", 2, 3_000)}
+	if err := repo.SaveBars(replayed); err != nil {
+		t.Errorf("replayed bars: %v", err)
+	}
+
+	// A different bar reusing a sequence is reported rather than dropped
+	if err := repo.SaveBars([]models.SymbolBarData{testBar("BTCUSDT", 0, 9_000)}); err == nil {
+		t.Error("a bar reusing a stored sequence was accepted")
+	}
+}
+
+func TestBarsAndSecondKlinesOfSeveralExchanges(t *testing.T) {
+	binance := newTestRepository(t, "binance")
+	okx := NewGormRepository(binance.db, "okx", "test", binance.scale, config.PostgresSettings{}, binance.log)
+
+	for _, repo := range []*GormRepository{binance, okx} {
+		if err := repo.SaveBars([]models.SymbolBarData{testBar("BTCUSDT", 0, 1_000)}); err != nil {
+			t.Fatalf("%s SaveBars: %v", repo.exchange, err)
+		}
+		if err := repo.SaveSecondKlines([]models.SymbolSecondKlineData{{Symbol: "BTCUSDT", Interval: "5s", OpenTime: 60_000}}); err != nil {
+			t.Fatalf("%s SaveSecondKlines: %v", repo.exchange, err)
+		}
+	}
+	// okx's next bar differs from binance's stored one without being mistaken for it
+	if err := okx.SaveBars([]models.SymbolBarData{testBar("BTCUSDT", 1, 2_000)}); err != nil {
+		t.Fatalf("okx SaveBars: %v", err)
+	}
+
+	var bars, seconds int64
+	binance.db.Model(&models.SymbolBarData{}).Count(&bars)
+	binance.db.Model(&models.SymbolSecondKlineData{}).Count(&seconds)
+	if bars != 3 || seconds != 2 {
+		t.Errorf("stored %d bars and %d sub-minute klines, want 3 and 2", bars, seconds)
+	}
+
+	for repo, want := range map[*GormRepository]int64{binance: 1, okx: 2} {
+		next, err := repo.NextBarSequences()
+		if err != nil || next["BTC"]["tick"] != want {
+			t.Errorf("%s next sequences = %v, %v, want %d", repo.exchange, next, err, want)
+		}
+	}
+
+	if purged, err := okx.PurgeSecondKlines(120_000); err != nil || purged != 1 {
+		t.Errorf("okx purged %d sub-minute klines, %v, want only its own", purged, err)
+	}
+}
+
+func TestEachKlineMatchesLikeTheMemoryFilter(t *testing.T) {
+	repo := newTestRepository(t, "binance")
+	memory := storage.NewMemoryRepository("binance")
+
+	var klines []models.SymbolKlineData
+	for _, symbol := range []string{"BTCUSDT", "ETHUSDT", "ETHFIUSDT", "1000PEPEUSDT", "XRPUSDC"} {
+		klines = append(klines, models.SymbolKlineData{Symbol: symbol, Interval: "1m", OpenTime: 60_000, Source: models.SourceAggregated})
+	}
+	// Both stores get their own copy, as SaveKlines normalizes the symbols it is given
+	if err := memory.SaveKlines(append([]models.SymbolKlineData(nil), klines...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SaveKlines(klines); err != nil {
+		t.Fatal(err)
+	}
+
+	symbols := func(r storage.KlineRepository, patterns []string) []string {
+		var got []string
+		err := r.EachKline(storage.KlineFilter{Symbols: patterns, Interval: "1m"}, func(k models.SymbolKlineData) error {
+			got = append(got, storage.StoredSymbol(k.Symbol))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("EachKline(%v): %v", patterns, err)
+		}
+		sort.Strings(got)
+		return got
+	}
+
+	tests := []struct {
+		patterns []string
+		want     string
+	}{
+		{patterns: []string{"ETH*"}, want: "ETH,ETHFI"},
+		{patterns: []string{"*USDT"}, want: "1000PEPE,BTC,ETH,ETHFI,XRP"},
+		{patterns: []string{"BTCUSDT", "xrp"}, want: "BTC,XRP"},
+		{patterns: []string{"1000*"}, want: "1000PEPE"},
+		{patterns: []string{"?TH"}, want: "ETH"},
+		{patterns: []string{"ETH%"}, want: ""},
+	}
+	for _, tt := range tests {
+		db, mem := strings.Join(symbols(repo, tt.patterns), ","), strings.Join(symbols(memory, tt.patterns), ",")
+		if db != tt.want || mem != tt.want {
+			t.Errorf("%v: database matched %q, memory matched %q, want %q", tt.patterns, db, mem, tt.want)
+		}
+	}
+}
+
+func TestKlinesOfSeveralExchanges(t *testing.T) {
+	binance := newTestRepository(t, "binance")
+	okx := NewGormRepository(binance.db, "okx", "test", binance.scale, config.PostgresSettings{}, binance.log)
+
+	kline := func(close int64) []models.SymbolKlineData {
+		return []models.SymbolKlineData{{Symbol: "BTCUSDT", Interval: "1m", OpenTime: 60_000, Source: models.SourceAggregated, Close: decimal.NewFromInt(close)}}
+	}
+	if err := binance.SaveKlines(kline(100)); err != nil {
+		t.Fatalf("binance SaveKlines: %v", err)
+	}
+	if err := okx.SaveKlines(kline(101)); err != nil {
+		t.Fatalf("okx SaveKlines: %v", err)
+	}
+
+	official := kline(102)
+	official[0].Source = models.SourceExchange
+	if err := okx.SaveOfficialKlines(official); err != nil {
+		t.Fatalf("okx SaveOfficialKlines: %v", err)
+	}
+
+	var rows []models.SymbolKlineData
+	if err := binance.db.Order("exchange").Order("source").Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("stored %d klines, want one per exchange and source", len(rows))
+	}
+	for _, row := range rows {
+		wantSuperseded := row.Exchange == "okx" && row.Source == models.SourceAggregated
+		if row.Superseded != wantSuperseded {
+			t.Errorf("%s %s superseded = %v, want %v", row.Exchange, row.Source, row.Superseded, wantSuperseded)
+		}
+	}
+
+	for _, repo := range []*GormRepository{binance, okx} {
+		loaded, err := repo.LoadKlines("BTC-USDT-SWAP", "1m", models.SourceAggregated, 0, 60_000)
+		if err != nil || len(loaded) != 1 || loaded[0].Exchange != repo.exchange {
+			t.Errorf("%s LoadKlines = %+v, %v, want only its own kline", repo.exchange, loaded, err)
+		}
+		latest, err := repo.LatestKlines("1m", models.SourceAggregated)
+		if err != nil || len(latest) != 1 || latest[0].Exchange != repo.exchange {
+			t.Errorf("%s LatestKlines = %+v, %v, want only its own kline", repo.exchange, latest, err)
+		}
+	}
+
+	// Reverting to version 7 drops the exchange from the key and keeps only the configured exchange's candles
+	if _, err := NewMigrator(binance.db, "binance", binance.scale, config.PostgresSettings{}, binance.log).Down(len(migrations) - 7); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	var remaining []string
+	if err := binance.db.Model(&models.SymbolKlineData{}).Distinct().Pluck("exchange", &remaining).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 || remaining[0] != "binance" {
+		t.Errorf("exchanges after Down = %v, want binance", remaining)
+	}
+}
+
+func TestRollUpKlinesMatchesResample(t *testing.T) {
+	repo := newTestRepository(t, "binance")
+	day := int64(24 * 60 * 60_000)
+
+	// Two days of hourly klines for two symbols, with both sources for one of them
+	var klines []models.SymbolKlineData
+	for _, symbol := range []string{"BTCUSDT", "ETHUSDT"} {
+		for at := int64(0); at < 2*day; at += 60 * 60_000 {
+			price := decimal.NewFromInt(100 + at/day)
+			klines = append(klines, models.SymbolKlineData{Symbol: symbol, Interval: "1h", OpenTime: at, Source: models.SourceAggregated,
+				Open: price, High: price, Low: price, Close: price, Volume: decimal.NewFromInt(1), SampleCount: 1, Amendments: int(at / (60 * 60_000) % 3)})
+			if symbol == "BTCUSDT" {
+				official := klines[len(klines)-1]
+				official.Source = models.SourceExchange
+				klines = append(klines, official)
+			}
+		}
+	}
+	if err := repo.SaveKlines(klines); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := repo.LoadKlines("BTCUSDT", "1h", models.SourceAggregated, 0, 2*day)
+	if err != nil || len(stored) != 48 {
+		t.Fatalf("stored %d klines, %v", len(stored), err)
+	}
+
+	built, err := repo.RollUpKlines("1h", "1d", config.AlignmentSettings{}, 0, 2*day, false)
+	if err != nil {
+		t.Fatalf("RollUpKlines: %v", err)
+	}
+	if built != 6 {
+		t.Errorf("built %d daily klines, want one per day, symbol and source", built)
+	}
+
+	var daily []models.SymbolKlineData
+	if err := repo.db.Where("interval = ?", "1d").Order("symbol").Order("source").Order("open_time").Find(&daily).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(daily) != 6 {
+		t.Fatalf("stored %d daily klines, want 6", len(daily))
+	}
+	for _, k := range daily {
+		assertDecimal(t, k.Symbol+" volume", k.Volume, "24")
+		if k.Amendments != 2 {
+			t.Errorf("%s %s day %d has %d amendments, want the most of any hour", k.Symbol, k.Source, k.OpenTime/day, k.Amendments)
+		}
+		if k.Partial {
+			t.Errorf("%s %s day %d is partial", k.Symbol, k.Source, k.OpenTime/day)
+		}
+		if want := fmt.Sprint(100 + k.OpenTime/day); k.Close.String() != want {
+			t.Errorf("%s day %d close = %s, want %s", k.Symbol, k.OpenTime/day, k.Close, want)
+		}
+	}
+}
+
+func assertDecimal(t *testing.T, name string, got decimal.Decimal, want string) {
+	t.Helper()
+	if !got.Equal(decimal.RequireFromString(want)) {
+		t.Errorf("%s = %s, want %s", name, got, want)
+	}
+}
+
+func TestSaveLeavesTheCallersRowsAlone(t *testing.T) {
+	repo := newTestRepository(t, "binance")
+
+	klines := []models.SymbolKlineData{{Symbol: "BTCUSDT", Interval: "1m", OpenTime: 60_000}}
+	if err := repo.SaveKlines(klines); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpsertKlines(klines); err != nil {
+		t.Fatal(err)
+	}
+	seconds := []models.SymbolSecondKlineData{{Symbol: "BTCUSDT", Interval: "5s", OpenTime: 60_000}}
+	if err := repo.SaveSecondKlines(seconds); err != nil {
+		t.Fatal(err)
+	}
+	bars := []models.SymbolBarData{testBar("BTCUSDT", 0, 1_000)}
+	if err := repo.SaveBars(bars); err != nil {
+		t.Fatal(err)
+	}
+
+	if k := klines[0]; k.Symbol != "BTCUSDT" || k.Exchange != "" || k.Instance != "" {
+		t.Errorf("kline was rewritten to %s on %q by %q", k.Symbol, k.Exchange, k.Instance)
+	}
+	if s := seconds[0]; s.Symbol != "BTCUSDT" || s.Exchange != "" || s.Instance != "" {
+		t.Errorf("sub-minute kline was rewritten to %s on %q by %q", s.Symbol, s.Exchange, s.Instance)
+	}
+	if b := bars[0]; b.Symbol != "BTCUSDT" || b.Exchange != "" || b.Instance != "" {
+		t.Errorf("bar was rewritten to %s on %q by %q", b.Symbol, b.Exchange, b.Instance)
+	}
+}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"scanner.magictradebot.com/models"
+)
+
+// DecimalScale is the number of decimals the price and the volume columns keep.
+type DecimalScale struct {
+	Price  int // prices, VWAP and typical price
+	Volume int // volumes and turnover
+}
+
+// DefaultDecimalScale is used when the instruments' tick sizes are unknown. It is finer than
+// any contract listed today.
+var DefaultDecimalScale = DecimalScale{Price: 18, Volume: 18}
+
+// Integer digits kept in front of the decimals. Volumes of meme contracts run into the trillions.
+const (
+	priceIntegerDigits  = 20
+	volumeIntegerDigits = 24
+	maxDecimalScale     = 30 // the widest scale every supported database accepts
+)
+
+// decimalTable lists the price and volume columns of a table.
+type decimalTable struct {
+	table         string
+	price, volume []string
+}
+
+func decimalTables() []decimalTable {
+	return []decimalTable{
+		{models.SymbolKlineData{}.TableName(), []string{"open", "high", "low", "close", "vwap", "typical_price"}, []string{"volume", "turnover"}},
+		{models.SymbolSecondKlineData{}.TableName(), []string{"open", "high", "low", "close"}, []string{"volume", "turnover"}},
+		{models.SymbolBarData{}.TableName(), []string{"open", "high", "low", "close"}, []string{"volume", "turnover"}},
+		{models.QuarantinedTick{}.TableName(), []string{"price"}, []string{"volume", "quote_volume"}},
+	}
+}
+
+// clamp keeps a scale within what the column types support, and never below the historical 8.
+func (s DecimalScale) clamp() DecimalScale {
+	limit := func(v int) int {
+		if v < 8 {
+			return 8
+		}
+		if v > maxDecimalScale {
+			return maxDecimalScale
+		}
+		return v
+	}
+	return DecimalScale{Price: limit(s.Price), Volume: limit(s.Volume)}
+}
+
+// alterDecimalColumns gives the price and volume columns the scale's precision. With onlyWiden
+// set, columns that already keep enough decimals are left alone. SQLite ignores declared
+// precision, so its columns are not touched.
+func alterDecimalColumns(tx *gorm.DB, scale DecimalScale, onlyWiden bool, log *logrus.Logger) error {
+	if tx.Dialector.Name() == "sqlite" {
+		return nil
+	}
+	scale = scale.clamp()
+
+	for _, t := range decimalTables() {
+		if !tx.Migrator().HasTable(t.table) {
+			continue
+		}
+		current := make(map[string]int)
+		if onlyWiden {
+			types, err := tx.Migrator().ColumnTypes(t.table)
+			if err != nil {
+				return fmt.Errorf("read columns of %s: %w", t.table, err)
+			}
+			for _, ct := range types {
+				if _, s, ok := ct.DecimalSize(); ok {
+					current[ct.Name()] = int(s)
+				}
+			}
+		}
+
+		for _, group := range []struct {
+			columns []string
+			digits  int
+			scale   int
+		}{
+			{t.price, priceIntegerDigits, scale.Price},
+			{t.volume, volumeIntegerDigits, scale.Volume},
+		} {
+			for _, column := range group.columns {
+				if onlyWiden && current[column] >= group.scale {
+					continue
+				}
+				columnType := fmt.Sprintf("decimal(%d,%d)", group.digits+group.scale, group.scale)
+				if err := alterColumnType(tx, t.table, column, columnType); err != nil {
+					return err
+				}
+				if log != nil {
+					log.WithFields(logrus.Fields{
+						"table":  t.table,
+						"column": column,
+						"type":   columnType,
+					}).Info("📐 Resized decimal column")
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// alterColumnType changes a column's type in place, converting the stored values.
+func alterColumnType(tx *gorm.DB, table, column, columnType string) error {
+	var sql string
+	switch tx.Dialector.Name() {
+	case "sqlite":
+		return nil
+	case "postgres":
+		sql = "ALTER TABLE ? ALTER COLUMN ? TYPE " + columnType
+	case "mysql":
+		sql = "ALTER TABLE ? MODIFY COLUMN ? " + columnType
+	default:
+		return fmt.Errorf("changing column types is not supported on %s", tx.Dialector.Name())
+	}
+	if err := tx.Exec(sql, clause.Table{Name: table}, clause.Column{Name: column}).Error; err != nil {
+		return fmt.Errorf("alter %s.%s to %s: %w", table, column, columnType, err)
+	}
+	return nil
+}
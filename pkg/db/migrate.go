@@ -0,0 +1,271 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/models"
+)
+
+// Migration is one numbered schema change. Up and Down run in a transaction together with
+// the bookkeeping row, and may transform data as well as the schema. Steps check the current
+// schema before changing it, so databases created by the old AutoMigrate fast-forward cleanly.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB, env MigrationEnv) error
+	Down    func(tx *gorm.DB, env MigrationEnv) error
+}
+
+// MigrationEnv carries the settings data migrations depend on.
+type MigrationEnv struct {
+	Exchange  string       // backfilled into rows that predate the exchange column
+	Scale     DecimalScale // precision of the price and volume columns
+	Partition bool         // partition the kline table by month on PostgreSQL
+}
+
+// MigrationStatus reports whether a migration has been applied.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// schemaMigration is a row of the migrations table.
+type schemaMigration struct {
+	Version   int    `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"size:100"`
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return models.TablePrefix + "SchemaMigrations"
+}
+
+// Migrator applies and reverts the registered migrations.
+type Migrator struct {
+	db         *gorm.DB
+	env        MigrationEnv
+	migrations []Migration
+	log        *logrus.Logger
+}
+
+// NewMigrator prepares the migrations for db.
+func NewMigrator(db *gorm.DB, exchange string, scale DecimalScale, postgres config.PostgresSettings, log *logrus.Logger) *Migrator {
+	list := append([]Migration(nil), migrations...)
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return &Migrator{
+		db:         db,
+		env:        MigrationEnv{Exchange: strings.ToLower(exchange), Scale: scale, Partition: postgres.Partitioning.Enabled},
+		migrations: list,
+		log:        log,
+	}
+}
+
+// Status lists every migration with the time it was applied, if it was.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := MigrationStatus{Version: mig.Version, Name: mig.Name}
+		if row, ok := applied[mig.Version]; ok {
+			at := row.AppliedAt
+			s.AppliedAt = &at
+		}
+		status = append(status, s)
+	}
+	return status, nil
+}
+
+// Pending returns the number of migrations not applied yet.
+func (m *Migrator) Pending() (int, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; !ok {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// Up applies pending migrations up to and including target, or all of them when target is 0.
+// MySQL commits schema changes as they run, so a failed migration can leave part of its work
+// behind; the helpers skip tables, columns and indexes that already exist, so it can be rerun.
+func (m *Migrator) Up(target int) (int, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, mig := range m.migrations {
+		if target > 0 && mig.Version > target {
+			break
+		}
+		if _, ok := applied[mig.Version]; ok {
+			continue
+		}
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := mig.Up(tx, m.env); err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{Version: mig.Version, Name: mig.Name, AppliedAt: time.Now().UTC()}).Error
+		})
+		if err != nil {
+			return count, fmt.Errorf("migration %d %s: %w", mig.Version, mig.Name, err)
+		}
+		count++
+		m.log.WithFields(logrus.Fields{
+			"version": mig.Version,
+			"name":    mig.Name,
+		}).Info("⬆️ Migration applied")
+	}
+	return count, nil
+}
+
+// Down reverts the latest applied migrations, at most steps of them.
+func (m *Migrator) Down(steps int) (int, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+		mig := m.migrations[i]
+		if _, ok := applied[mig.Version]; !ok {
+			continue
+		}
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := mig.Down(tx, m.env); err != nil {
+				return err
+			}
+			return tx.Delete(&schemaMigration{Version: mig.Version}).Error
+		})
+		if err != nil {
+			return count, fmt.Errorf("revert migration %d %s: %w", mig.Version, mig.Name, err)
+		}
+		count++
+		m.log.WithFields(logrus.Fields{
+			"version": mig.Version,
+			"name":    mig.Name,
+		}).Info("⬇️ Migration reverted")
+	}
+	return count, nil
+}
+
+// applied creates the migrations table if needed and returns the recorded rows by version.
+func (m *Migrator) applied() (map[int]schemaMigration, error) {
+	if !m.db.Migrator().HasTable(&schemaMigration{}) {
+		if err := m.db.Migrator().CreateTable(&schemaMigration{}); err != nil {
+			return nil, fmt.Errorf("create migrations table: %w", err)
+		}
+	}
+	var rows []schemaMigration
+	if err := m.db.Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("read migrations table: %w", err)
+	}
+	applied := make(map[int]schemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// Helpers shared by the migrations. Snapshot structs carry no index tags: GORM would name
+// those after the snapshot type, so indexes are created here with explicit names.
+
+// indexName prefixes a fixed index name so environments sharing a database do not clash.
+// The legacy prefix keeps the historical names.
+func indexName(base string) string {
+	if models.TablePrefix == models.LegacyTablePrefix {
+		return base
+	}
+	return strings.ToLower(models.TablePrefix) + base
+}
+
+// columnIndexName is the name GORM gives a single-column index.
+func columnIndexName(table, column string) string {
+	return "idx_" + table + "_" + column
+}
+
+func createTable(tx *gorm.DB, table string, snapshot interface{}) error {
+	if tx.Migrator().HasTable(table) {
+		return nil
+	}
+	return tx.Table(table).Migrator().CreateTable(snapshot)
+}
+
+func dropTable(tx *gorm.DB, table string) error {
+	return tx.Migrator().DropTable(table)
+}
+
+// addColumns adds the snapshot's fields that the table lacks.
+func addColumns(tx *gorm.DB, table string, snapshot interface{}, fields ...string) error {
+	migrator := tx.Table(table).Migrator()
+	for _, field := range fields {
+		if migrator.HasColumn(snapshot, field) {
+			continue
+		}
+		if err := migrator.AddColumn(snapshot, field); err != nil {
+			return fmt.Errorf("add column %s: %w", field, err)
+		}
+	}
+	return nil
+}
+
+// dropColumns uses plain ALTER TABLE; the SQLite migrator cannot drop columns by table name.
+// Indexes on the columns must be dropped first.
+func dropColumns(tx *gorm.DB, table string, columns ...string) error {
+	for _, column := range columns {
+		if !tx.Migrator().HasColumn(table, column) {
+			continue
+		}
+		if err := tx.Exec("ALTER TABLE ? DROP COLUMN ?", clause.Table{Name: table}, clause.Column{Name: column}).Error; err != nil {
+			return fmt.Errorf("drop column %s: %w", column, err)
+		}
+	}
+	return nil
+}
+
+func createIndex(tx *gorm.DB, table, name string, unique bool, columns ...string) error {
+	if tx.Migrator().HasIndex(table, name) {
+		return nil
+	}
+	cols := make([]interface{}, len(columns))
+	for i, c := range columns {
+		cols[i] = clause.Column{Name: c}
+	}
+	sql := "CREATE INDEX ? ON ??"
+	if unique {
+		sql = "CREATE UNIQUE INDEX ? ON ??"
+	}
+	if err := tx.Exec(sql, clause.Column{Name: name}, clause.Table{Name: table}, cols).Error; err != nil {
+		return fmt.Errorf("create index %s: %w", name, err)
+	}
+	return nil
+}
+
+func dropIndex(tx *gorm.DB, table, name string) error {
+	if !tx.Migrator().HasIndex(table, name) {
+		return nil
+	}
+	if err := tx.Migrator().DropIndex(table, name); err != nil {
+		return fmt.Errorf("drop index %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,408 @@
+package db
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"scanner.magictradebot.com/models"
+	"scanner.magictradebot.com/pkg/aggregator"
+)
+
+// migrations is the schema history, oldest first. Never edit an applied migration; add a new one.
+var migrations = []Migration{
+	{Version: 1, Name: "create_klines", Up: createKlines, Down: dropKlines},
+	{Version: 2, Name: "kline_quality", Up: addKlineQuality, Down: dropKlineQuality},
+	{Version: 3, Name: "kline_turnover_vwap", Up: addKlineTurnover, Down: dropKlineTurnover},
+	{Version: 4, Name: "create_bars_ticks_seconds", Up: createAuxTables, Down: dropAuxTables},
+	{Version: 5, Name: "kline_source", Up: addKlineSource, Down: dropKlineSource},
+	{Version: 6, Name: "kline_exchange", Up: addKlineExchange, Down: dropKlineExchange},
+	{Version: 7, Name: "decimal_prices", Up: widenDecimalColumns, Down: restoreDecimalColumns},
+	{Version: 8, Name: "kline_exchange_key", Up: addExchangeToKlineKey, Down: dropExchangeFromKlineKey},
+	{Version: 9, Name: "kline_partitions", Up: partitionKlines, Down: unpartitionKlines},
+	{Version: 10, Name: "aux_exchange_key", Up: addExchangeToAuxKeys, Down: dropExchangeFromAuxKeys},
+}
+
+func klineTable() string {
+	return models.SymbolKlineData{}.TableName()
+}
+
+// Snapshots of the kline columns as each migration introduced them.
+
+type klineV1 struct {
+	ID         int64   `gorm:"primaryKey;autoIncrement"`
+	Symbol     string  `gorm:"size:50"`
+	Interval   string  `gorm:"size:10;default:1m"`
+	Open       float64 `gorm:"type:decimal(18,8)"`
+	High       float64 `gorm:"type:decimal(18,8)"`
+	Low        float64 `gorm:"type:decimal(18,8)"`
+	Close      float64 `gorm:"type:decimal(18,8)"`
+	OpenTime   int64
+	Instance   string
+	Volume     float64
+	TradeCount int64
+}
+
+func createKlines(tx *gorm.DB, _ MigrationEnv) error {
+	table := klineTable()
+	if err := createTable(tx, table, &klineV1{}); err != nil {
+		return err
+	}
+	if err := createIndex(tx, table, indexName("idx_symbol_interval_time"), true, "symbol", "interval", "open_time"); err != nil {
+		return err
+	}
+	return createIndex(tx, table, columnIndexName(table, "instance"), false, "instance")
+}
+
+func dropKlines(tx *gorm.DB, _ MigrationEnv) error {
+	return dropTable(tx, klineTable())
+}
+
+type klineV2 struct {
+	Amendments    int    `gorm:"default:0"`
+	FillPolicy    string `gorm:"size:10"`
+	Synthetic     bool   `gorm:"default:false"`
+	SampleCount   int64
+	FirstSampleAt int64
+	LastSampleAt  int64
+	Coverage      float64
+	MaxGapMs      int64
+	Partial       bool `gorm:"default:false"`
+}
+
+var klineV2Fields = []string{"Amendments", "FillPolicy", "Synthetic", "SampleCount", "FirstSampleAt", "LastSampleAt", "Coverage", "MaxGapMs", "Partial"}
+
+func addKlineQuality(tx *gorm.DB, _ MigrationEnv) error {
+	return addColumns(tx, klineTable(), &klineV2{}, klineV2Fields...)
+}
+
+func dropKlineQuality(tx *gorm.DB, _ MigrationEnv) error {
+	return dropColumns(tx, klineTable(), "amendments", "fill_policy", "synthetic", "sample_count",
+		"first_sample_at", "last_sample_at", "coverage", "max_gap_ms", "partial")
+}
+
+type klineV3 struct {
+	Turnover     float64
+	VWAP         float64 `gorm:"column:vwap;type:decimal(18,8)"`
+	TypicalPrice float64 `gorm:"type:decimal(18,8)"`
+	Alignment    string  `gorm:"size:64;default:UTC"`
+}
+
+// addKlineTurnover adds the derived price columns and fills them for older candles. Without
+// trades to weigh, the typical price is the best available VWAP estimate.
+func addKlineTurnover(tx *gorm.DB, _ MigrationEnv) error {
+	table := klineTable()
+	if err := addColumns(tx, table, &klineV3{}, "Turnover", "VWAP", "TypicalPrice", "Alignment"); err != nil {
+		return err
+	}
+
+	err := tx.Table(table).
+		Where("typical_price IS NULL OR typical_price = 0").
+		Update("typical_price", gorm.Expr("(high + low + close) / 3")).Error
+	if err != nil {
+		return fmt.Errorf("backfill typical price: %w", err)
+	}
+	err = tx.Table(table).
+		Where("vwap IS NULL OR vwap = 0").
+		Update("vwap", gorm.Expr("typical_price")).Error
+	if err != nil {
+		return fmt.Errorf("backfill vwap: %w", err)
+	}
+	err = tx.Table(table).
+		Where("alignment IS NULL OR alignment = ?", "").
+		Update("alignment", aggregator.DefaultAlignment).Error
+	if err != nil {
+		return fmt.Errorf("backfill alignment: %w", err)
+	}
+	return nil
+}
+
+func dropKlineTurnover(tx *gorm.DB, _ MigrationEnv) error {
+	return dropColumns(tx, klineTable(), "turnover", "vwap", "typical_price", "alignment")
+}
+
+type barV1 struct {
+	ID        int64  `gorm:"primaryKey;autoIncrement"`
+	Symbol    string `gorm:"size:50"`
+	BarType   string `gorm:"size:10"`
+	Sequence  int64
+	Threshold float64
+	Open      float64 `gorm:"type:decimal(18,8)"`
+	High      float64 `gorm:"type:decimal(18,8)"`
+	Low       float64 `gorm:"type:decimal(18,8)"`
+	Close     float64 `gorm:"type:decimal(18,8)"`
+	Volume    float64
+	Turnover  float64
+	TickCount int64
+	OpenTime  int64
+	CloseTime int64
+	Instance  string
+}
+
+type quarantinedTickV1 struct {
+	ID          int64  `gorm:"primaryKey;autoIncrement"`
+	Symbol      string `gorm:"size:50"`
+	Price       float64
+	Volume      float64
+	QuoteVolume float64
+	TickTime    int64
+	Rule        string `gorm:"size:20"`
+	Reason      string `gorm:"size:255"`
+	ReceivedAt  int64
+	Instance    string
+}
+
+type secondKlineV1 struct {
+	ID         int64  `gorm:"primaryKey;autoIncrement"`
+	Symbol     string `gorm:"size:50"`
+	Interval   string `gorm:"size:10"`
+	OpenTime   int64
+	Open       float64 `gorm:"type:decimal(18,8)"`
+	High       float64 `gorm:"type:decimal(18,8)"`
+	Low        float64 `gorm:"type:decimal(18,8)"`
+	Close      float64 `gorm:"type:decimal(18,8)"`
+	Volume     float64
+	Turnover   float64
+	TradeCount int64
+	Instance   string
+}
+
+func createAuxTables(tx *gorm.DB, _ MigrationEnv) error {
+	bars := models.SymbolBarData{}.TableName()
+	if err := createTable(tx, bars, &barV1{}); err != nil {
+		return err
+	}
+	if err := createIndex(tx, bars, indexName("idx_symbol_bar_sequence"), true, "symbol", "bar_type", "sequence"); err != nil {
+		return err
+	}
+	for _, column := range []string{"open_time", "instance"} {
+		if err := createIndex(tx, bars, columnIndexName(bars, column), false, column); err != nil {
+			return err
+		}
+	}
+
+	ticks := models.QuarantinedTick{}.TableName()
+	if err := createTable(tx, ticks, &quarantinedTickV1{}); err != nil {
+		return err
+	}
+	for _, column := range []string{"symbol", "rule", "received_at", "instance"} {
+		if err := createIndex(tx, ticks, columnIndexName(ticks, column), false, column); err != nil {
+			return err
+		}
+	}
+
+	seconds := models.SymbolSecondKlineData{}.TableName()
+	if err := createTable(tx, seconds, &secondKlineV1{}); err != nil {
+		return err
+	}
+	if err := createIndex(tx, seconds, indexName("idx_symbol_second_interval_time"), true, "symbol", "interval", "open_time"); err != nil {
+		return err
+	}
+	for _, column := range []string{"open_time", "instance"} {
+		if err := createIndex(tx, seconds, columnIndexName(seconds, column), false, column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dropAuxTables(tx *gorm.DB, _ MigrationEnv) error {
+	for _, table := range []string{
+		models.SymbolSecondKlineData{}.TableName(),
+		models.QuarantinedTick{}.TableName(),
+		models.SymbolBarData{}.TableName(),
+	} {
+		if err := dropTable(tx, table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type klineV5 struct {
+	Source     string `gorm:"size:10;default:aggregated"`
+	Superseded bool   `gorm:"default:false"`
+}
+
+// addKlineSource lets exchange candles sit next to aggregated ones by moving the source into
+// the unique key. Existing rows were all built by the collector.
+func addKlineSource(tx *gorm.DB, _ MigrationEnv) error {
+	table := klineTable()
+	if err := addColumns(tx, table, &klineV5{}, "Source", "Superseded"); err != nil {
+		return err
+	}
+	err := tx.Table(table).
+		Where("source IS NULL OR source = ?", "").
+		Update("source", models.SourceAggregated).Error
+	if err != nil {
+		return fmt.Errorf("backfill kline source: %w", err)
+	}
+	if err := createIndex(tx, table, indexName("idx_symbol_interval_time_source"), true, "symbol", "interval", "open_time", "source"); err != nil {
+		return err
+	}
+	return dropIndex(tx, table, indexName("idx_symbol_interval_time"))
+}
+
+// dropKlineSource deletes exchange candles, since the old unique key cannot hold both sources.
+func dropKlineSource(tx *gorm.DB, _ MigrationEnv) error {
+	table := klineTable()
+	if err := tx.Table(table).Where("source = ?", models.SourceExchange).Delete(nil).Error; err != nil {
+		return fmt.Errorf("delete exchange klines: %w", err)
+	}
+	if err := createIndex(tx, table, indexName("idx_symbol_interval_time"), true, "symbol", "interval", "open_time"); err != nil {
+		return err
+	}
+	if err := dropIndex(tx, table, indexName("idx_symbol_interval_time_source")); err != nil {
+		return err
+	}
+	return dropColumns(tx, table, "source", "superseded")
+}
+
+type klineV6 struct {
+	Exchange string `gorm:"size:20"`
+}
+
+// addKlineExchange tags existing candles with the configured exchange, which collected them.
+func addKlineExchange(tx *gorm.DB, env MigrationEnv) error {
+	table := klineTable()
+	if err := addColumns(tx, table, &klineV6{}, "Exchange"); err != nil {
+		return err
+	}
+	if err := createIndex(tx, table, columnIndexName(table, "exchange"), false, "exchange"); err != nil {
+		return err
+	}
+	err := tx.Table(table).
+		Where("exchange IS NULL OR exchange = ?", "").
+		Update("exchange", env.Exchange).Error
+	if err != nil {
+		return fmt.Errorf("backfill kline exchange: %w", err)
+	}
+	return nil
+}
+
+func dropKlineExchange(tx *gorm.DB, _ MigrationEnv) error {
+	table := klineTable()
+	if err := dropIndex(tx, table, columnIndexName(table, "exchange")); err != nil {
+		return err
+	}
+	return dropColumns(tx, table, "exchange")
+}
+
+// widenDecimalColumns sizes the price and volume columns from the instruments' tick sizes.
+// Volumes used to be floating point; the conversion keeps whatever digits they held.
+func widenDecimalColumns(tx *gorm.DB, env MigrationEnv) error {
+	return alterDecimalColumns(tx, env.Scale, false, nil)
+}
+
+// restoreDecimalColumns returns to decimal(18,8) prices and floating-point volumes. Prices
+// with more than 8 decimals are rounded.
+func restoreDecimalColumns(tx *gorm.DB, _ MigrationEnv) error {
+	for _, t := range decimalTables() {
+		if !tx.Migrator().HasTable(t.table) {
+			continue
+		}
+		for _, column := range t.price {
+			if err := alterColumnType(tx, t.table, column, "decimal(18,8)"); err != nil {
+				return err
+			}
+		}
+		for _, column := range t.volume {
+			if err := alterColumnType(tx, t.table, column, "double precision"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addExchangeToKlineKey lets several exchanges store the same symbol by moving the exchange
+// into the unique key. Rows still without an exchange are tagged with the configured one.
+func addExchangeToKlineKey(tx *gorm.DB, env MigrationEnv) error {
+	table := klineTable()
+	err := tx.Table(table).
+		Where("exchange IS NULL OR exchange = ?", "").
+		Update("exchange", env.Exchange).Error
+	if err != nil {
+		return fmt.Errorf("backfill kline exchange: %w", err)
+	}
+	if err := createIndex(tx, table, indexName("idx_symbol_interval_time_source_exchange"), true, "symbol", "interval", "open_time", "source", "exchange"); err != nil {
+		return err
+	}
+	return dropIndex(tx, table, indexName("idx_symbol_interval_time_source"))
+}
+
+// dropExchangeFromKlineKey deletes the candles of other exchanges than the configured one,
+// since the old unique key cannot hold them next to each other.
+func dropExchangeFromKlineKey(tx *gorm.DB, env MigrationEnv) error {
+	table := klineTable()
+	if err := tx.Table(table).Where("exchange <> ?", env.Exchange).Delete(nil).Error; err != nil {
+		return fmt.Errorf("delete other exchanges' klines: %w", err)
+	}
+	if err := createIndex(tx, table, indexName("idx_symbol_interval_time_source"), true, "symbol", "interval", "open_time", "source"); err != nil {
+		return err
+	}
+	return dropIndex(tx, table, indexName("idx_symbol_interval_time_source_exchange"))
+}
+
+// auxKey is the unique key of a bar or sub-minute kline table before and after the exchange
+// joined it.
+type auxKey struct {
+	table    string
+	oldIndex string
+	newIndex string
+	columns  []string
+}
+
+func auxKeys() []auxKey {
+	return []auxKey{
+		{models.SymbolBarData{}.TableName(), "idx_symbol_bar_sequence", "idx_symbol_bar_sequence_exchange", []string{"symbol", "bar_type", "sequence"}},
+		{models.SymbolSecondKlineData{}.TableName(), "idx_symbol_second_interval_time", "idx_symbol_second_interval_time_exchange", []string{"symbol", "interval", "open_time"}},
+	}
+}
+
+type auxV10 struct {
+	Exchange string `gorm:"size:20"`
+}
+
+// addExchangeToAuxKeys tags existing bars and sub-minute klines with the configured exchange
+// and moves it into their unique keys, so several exchanges can number bars of one symbol.
+func addExchangeToAuxKeys(tx *gorm.DB, env MigrationEnv) error {
+	for _, k := range auxKeys() {
+		if err := addColumns(tx, k.table, &auxV10{}, "Exchange"); err != nil {
+			return err
+		}
+		err := tx.Table(k.table).
+			Where("exchange IS NULL OR exchange = ?", "").
+			Update("exchange", env.Exchange).Error
+		if err != nil {
+			return fmt.Errorf("backfill %s exchange: %w", k.table, err)
+		}
+		if err := createIndex(tx, k.table, indexName(k.newIndex), true, append(k.columns, "exchange")...); err != nil {
+			return err
+		}
+		if err := dropIndex(tx, k.table, indexName(k.oldIndex)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropExchangeFromAuxKeys deletes the bars and sub-minute klines of other exchanges than the
+// configured one, since the old unique keys cannot hold them next to each other.
+func dropExchangeFromAuxKeys(tx *gorm.DB, env MigrationEnv) error {
+	for _, k := range auxKeys() {
+		if err := tx.Table(k.table).Where("exchange <> ?", env.Exchange).Delete(nil).Error; err != nil {
+			return fmt.Errorf("delete other exchanges' rows of %s: %w", k.table, err)
+		}
+		if err := createIndex(tx, k.table, indexName(k.oldIndex), true, k.columns...); err != nil {
+			return err
+		}
+		if err := dropIndex(tx, k.table, indexName(k.newIndex)); err != nil {
+			return err
+		}
+		if err := dropColumns(tx, k.table, "exchange"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// mysqlStringSize is the width of string columns declared without a size. MySQL cannot index
+// the TEXT type GORM would pick otherwise.
+const mysqlStringSize = 255
+
+// openMySQL connects to MySQL 5.7+ or MariaDB 10.3+. conn is a go-sql-driver DSN such as
+// "user:password@tcp(localhost:3306)/kline_db"; when empty the DB_* environment variables are used.
+func openMySQL(conn string) (*gorm.DB, error) {
+	dsn, err := mysqlDSN(conn)
+	if err != nil {
+		return nil, err
+	}
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:               dsn,
+		DefaultStringSize: mysqlStringSize,
+	}), &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("connect to MySQL: %w", err)
+	}
+	return db, nil
+}
+
+// mysqlDSN builds the DSN from conn or the environment. Timestamps are always parsed into
+// time.Time in UTC, which the migrations table relies on.
+func mysqlDSN(conn string) (string, error) {
+	var cfg *mysqldriver.Config
+	if conn != "" {
+		parsed, err := mysqldriver.ParseDSN(conn)
+		if err != nil {
+			return "", fmt.Errorf("invalid MySQL DSN: %w", err)
+		}
+		cfg = parsed
+	} else {
+		// 🔐 DB_HOST, DB_PORT (default 3306), DB_USER, DB_PASSWORD, DB_NAME
+		host := os.Getenv("DB_HOST")
+		port := os.Getenv("DB_PORT")
+		user := os.Getenv("DB_USER")
+		password := os.Getenv("DB_PASSWORD")
+		dbname := os.Getenv("DB_NAME")
+		if host == "" || user == "" || password == "" || dbname == "" {
+			return "", fmt.Errorf("missing one or more required DB environment variables: DB_HOST, DB_USER, DB_PASSWORD, DB_NAME")
+		}
+		if port == "" {
+			port = "3306"
+		}
+
+		cfg = mysqldriver.NewConfig()
+		cfg.Net = "tcp"
+		cfg.Addr = net.JoinHostPort(host, port)
+		cfg.User = user
+		cfg.Passwd = password
+		cfg.DBName = dbname
+	}
+	cfg.ParseTime = true
+	cfg.Loc = time.UTC
+	if cfg.Params == nil {
+		cfg.Params = map[string]string{}
+	}
+	if _, ok := cfg.Params["charset"]; !ok {
+		cfg.Params["charset"] = "utf8mb4"
+	}
+	return cfg.FormatDSN(), nil
+}
+
+// mysqlAmendUpsert updates a duplicate kline only when the incoming row carries more
+// amendments. MySQL has no conditional ON DUPLICATE KEY UPDATE and applies the assignments in
+// order, so each column compares the amendments first and the count itself is assigned last.
+// The incoming row is read through a row alias on MySQL 8.0.19 and later, and through VALUES()
+// on MariaDB and older MySQL, which lack the alias.
+func mysqlAmendUpsert(db *gorm.DB) clause.Expression {
+	rowAlias := mysqlRowAlias(db)
+	incoming := func(column clause.Column) interface{} {
+		if rowAlias {
+			return clause.Column{Table: "new", Name: column.Name}
+		}
+		return gorm.Expr("VALUES(?)", column)
+	}
+
+	set := make(clause.Set, 0, len(amendedColumns))
+	amendments := clause.Column{Name: "amendments"}
+	assign := func(name string) {
+		column := clause.Column{Name: name}
+		set = append(set, clause.Assignment{
+			Column: column,
+			Value:  gorm.Expr("IF(? < ?, ?, ?)", amendments, incoming(amendments), incoming(column), column),
+		})
+	}
+	for _, name := range amendedColumns {
+		if name != "amendments" {
+			assign(name)
+		}
+	}
+	assign("amendments")
+
+	if rowAlias {
+		return rowAliasUpsert(set)
+	}
+	return clause.OnConflict{DoUpdates: set}
+}
+
+// mysqlRowAlias reports whether the server is MySQL 8.0.19 or later.
+func mysqlRowAlias(db *gorm.DB) bool {
+	dialector, ok := db.Dialector.(*mysql.Dialector)
+	if !ok || strings.Contains(dialector.ServerVersion, "MariaDB") {
+		return false
+	}
+	release, _, _ := strings.Cut(dialector.ServerVersion, "-")
+	minimum := []int{8, 0, 19}
+	for i, part := range strings.SplitN(release, ".", len(minimum)) {
+		n, err := strconv.Atoi(part)
+		if err != nil || n != minimum[i] {
+			return err == nil && n > minimum[i]
+		}
+	}
+	return true
+}
+
+// rowAliasUpsert is ON DUPLICATE KEY UPDATE with the inserted row aliased as new.
+type rowAliasUpsert clause.Set
+
+func (rowAliasUpsert) Name() string {
+	return "ON CONFLICT"
+}
+
+func (u rowAliasUpsert) Build(builder clause.Builder) {
+	builder.WriteString("AS new ON DUPLICATE KEY UPDATE ")
+	clause.Set(u).Build(builder)
+}
+
+// MergeClause clears the clause name, which would otherwise be written ahead of the alias.
+func (u rowAliasUpsert) MergeClause(c *clause.Clause) {
+	c.Name = ""
+	c.Expression = u
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/models"
+)
+
+func TestMySQLAmendUpsertSQL(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+		absent  string
+	}{
+		{version: "8.0.36", want: "AS new ON DUPLICATE KEY UPDATE `open`=IF(`amendments` < `new`.`amendments`, `new`.`open`, `open`)", absent: "VALUES("},
+		{version: "8.4.0-commercial", want: "AS new ON DUPLICATE KEY UPDATE", absent: "VALUES("},
+		{version: "8.0.18", want: "ON DUPLICATE KEY UPDATE `open`=IF(`amendments` < VALUES(`amendments`), VALUES(`open`), `open`)", absent: "AS new"},
+		{version: "5.7.44-log", want: "ON DUPLICATE KEY UPDATE", absent: "AS new"},
+		{version: "10.11.6-MariaDB", want: "ON DUPLICATE KEY UPDATE", absent: "AS new"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			gormDB, err := gorm.Open(mysql.New(mysql.Config{
+				DSN:                       "user:password@tcp(localhost:3306)/kline_db",
+				ServerVersion:             tt.version,
+				SkipInitializeWithVersion: true,
+			}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := gormDB.Clauses(mysqlAmendUpsert(gormDB)).Create(&models.SymbolKlineData{Symbol: "BTC", Interval: "1m"})
+			if result.Error != nil {
+				t.Fatal(result.Error)
+			}
+			stmt := result.Statement
+			sql := stmt.SQL.String()
+			if !strings.Contains(sql, tt.want) || strings.Contains(sql, tt.absent) {
+				t.Errorf("upsert is\n%s\nwant it to contain %q and not %q", sql, tt.want, tt.absent)
+			}
+			if last := strings.LastIndex(sql, "`amendments`="); last < strings.LastIndex(sql, "`open`=") {
+				t.Error("the amendment count is not assigned last")
+			}
+		})
+	}
+}
+
+// TestMySQLUpsertKlines runs against the server named by TEST_MYSQL_DSN, e.g.
+// "user:password@tcp(localhost:3306)/kline_test"; point it at MySQL 8 and at MariaDB.
+func TestMySQLUpsertKlines(t *testing.T) {
+	repo := newIntegrationRepository(t, "TEST_MYSQL_DSN", openMySQL, config.PostgresSettings{})
+
+	kline := func(close int64, amendments int) []models.SymbolKlineData {
+		return []models.SymbolKlineData{{Symbol: "BTCUSDT", Interval: "1m", OpenTime: 60_000, Source: models.SourceAggregated,
+			Close: decimal.NewFromInt(close), Amendments: amendments}}
+	}
+	stored := func() models.SymbolKlineData {
+		t.Helper()
+		rows, err := repo.LoadKlines("BTCUSDT", "1m", models.SourceAggregated, 0, 60_000)
+		if err != nil || len(rows) != 1 {
+			t.Fatalf("LoadKlines = %d rows, %v", len(rows), err)
+		}
+		return rows[0]
+	}
+
+	if err := repo.SaveKlines(kline(100, 0)); err != nil {
+		t.Fatalf("SaveKlines: %v", err)
+	}
+	if err := repo.UpsertKlines(kline(102, 2)); err != nil {
+		t.Fatalf("UpsertKlines: %v", err)
+	}
+	if k := stored(); k.Amendments != 2 || !k.Close.Equal(decimal.NewFromInt(102)) {
+		t.Errorf("after the amendment: close %s, amendments %d", k.Close, k.Amendments)
+	}
+
+	// A stale amendment arriving late leaves the newer one in place
+	if err := repo.UpsertKlines(kline(101, 1)); err != nil {
+		t.Fatalf("UpsertKlines: %v", err)
+	}
+	if k := stored(); k.Amendments != 2 || !k.Close.Equal(decimal.NewFromInt(102)) {
+		t.Errorf("after a stale amendment: close %s, amendments %d", k.Close, k.Amendments)
+	}
+}
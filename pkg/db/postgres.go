@@ -0,0 +1,425 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+
+	"scanner.magictradebot.com/models"
+)
+
+// klineKey is the unique key klines are merged on.
+var klineKey = []string{"symbol", "interval", "open_time", "source", "exchange"}
+
+func klineKeyColumns() []clause.Column {
+	columns := make([]clause.Column, len(klineKey))
+	for i, name := range klineKey {
+		columns[i] = clause.Column{Name: name}
+	}
+	return columns
+}
+
+// amendedColumns are overwritten when an amended kline replaces a stored one.
+var amendedColumns = []string{
+	"open", "high", "low", "close", "volume", "turnover", "vwap", "typical_price", "trade_count", "amendments",
+	"fill_policy", "synthetic", "alignment", "sample_count", "first_sample_at", "last_sample_at",
+	"coverage", "max_gap_ms", "partial", "instance",
+}
+
+// useCopy reports whether a batch is large enough for the COPY path.
+func (r *GormRepository) useCopy(rows int) bool {
+	if r.db.Dialector.Name() != "postgres" || r.postgres.CopyThreshold < 0 {
+		return false
+	}
+	threshold := r.postgres.CopyThreshold
+	if threshold == 0 {
+		threshold = 500
+	}
+	return rows >= threshold
+}
+
+// copyKlines COPYs klines into a temporary staging table and merges them into the kline table
+// in one statement. With amend set, stored klines are replaced by versions carrying more
+// amendments; otherwise stored klines are kept. Duplicates within the batch collapse to the
+// most amended one. It returns the number of rows inserted or updated.
+func (r *GormRepository) copyKlines(data []models.SymbolKlineData, amend bool) (int64, error) {
+	stmt := &gorm.Statement{DB: r.db}
+	if err := stmt.Parse(&models.SymbolKlineData{}); err != nil {
+		return 0, fmt.Errorf("parse kline schema: %w", err)
+	}
+	var fields []*schema.Field
+	var columns []string
+	for _, f := range stmt.Schema.Fields {
+		if f.DBName == "" || f.PrimaryKey {
+			continue
+		}
+		fields = append(fields, f)
+		columns = append(columns, f.DBName)
+	}
+
+	ctx := context.Background()
+	rows := make([][]interface{}, len(data))
+	for i := range data {
+		value := reflect.ValueOf(&data[i]).Elem()
+		row := make([]interface{}, len(fields))
+		for j, f := range fields {
+			v, zero := f.ValueOf(ctx, value)
+			if zero && f.HasDefaultValue && f.DefaultValueInterface != nil {
+				v = f.DefaultValueInterface // the value GORM's insert would leave to the column default
+			}
+			if d, ok := v.(decimal.Decimal); ok {
+				v = pgtype.Numeric{Int: d.Coefficient(), Exp: d.Exponent(), Valid: true}
+			}
+			row[j] = v
+		}
+		rows[i] = row
+	}
+
+	table := pgx.Identifier{models.SymbolKlineData{}.TableName()}.Sanitize()
+	staging := pgx.Identifier{"kline_staging"}.Sanitize()
+	columnList := quoteColumns(columns)
+	keyList := quoteColumns(klineKey)
+
+	merge := fmt.Sprintf(`INSERT INTO %s (%s)
+SELECT DISTINCT ON (%s) %s FROM %s ORDER BY %s, "amendments" DESC
+ON CONFLICT (%s) `, table, columnList, keyList, columnList, staging, keyList, keyList)
+	if amend {
+		updates := make([]string, len(amendedColumns))
+		for i, c := range amendedColumns {
+			col := pgx.Identifier{c}.Sanitize()
+			updates[i] = col + " = EXCLUDED." + col
+		}
+		merge += fmt.Sprintf(`DO UPDATE SET %s WHERE %s."amendments" < EXCLUDED."amendments"`, strings.Join(updates, ", "), table)
+	} else {
+		merge += "DO NOTHING"
+	}
+
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return 0, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	var affected int64
+	err = conn.Raw(func(driverConn interface{}) error {
+		stdConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("COPY needs the pgx driver, the connection is a %T", driverConn)
+		}
+		tx, err := stdConn.Conn().Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		create := fmt.Sprintf("CREATE TEMP TABLE %s ON COMMIT DROP AS SELECT %s FROM %s WITH NO DATA", staging, columnList, table)
+		if _, err := tx.Exec(ctx, create); err != nil {
+			return fmt.Errorf("create staging table: %w", err)
+		}
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{"kline_staging"}, columns, pgx.CopyFromRows(rows)); err != nil {
+			return fmt.Errorf("copy klines: %w", err)
+		}
+		tag, err := tx.Exec(ctx, merge)
+		if err != nil {
+			return fmt.Errorf("merge klines: %w", err)
+		}
+		affected = tag.RowsAffected()
+		return tx.Commit(ctx)
+	})
+	return affected, err
+}
+
+func quoteColumns(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = pgx.Identifier{c}.Sanitize()
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// MaintainPartitions creates the partitions of the current and coming months when
+// partitioning is enabled on PostgreSQL, and detaches those past retention; detached
+// partitions stay behind as plain tables for archiving or dropping. The table itself is
+// converted by the kline_partitions migration.
+func (r *GormRepository) MaintainPartitions(now time.Time) error {
+	cfg := r.postgres.Partitioning
+	if !cfg.Enabled || r.db.Dialector.Name() != "postgres" {
+		return nil
+	}
+	premake := cfg.PremakeMonths
+	if premake <= 0 {
+		premake = 3
+	}
+
+	table := klineTable()
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		partitioned, err := isPartitioned(tx, table)
+		if err != nil {
+			return err
+		}
+		if !partitioned {
+			return fmt.Errorf("%s is not partitioned: the kline_partitions migration ran while partitioning was disabled, revert and reapply it", table)
+		}
+
+		current := monthStart(now)
+		for i := 0; i <= premake; i++ {
+			month := current.AddDate(0, i, 0)
+			created, err := createPartition(tx, table, month)
+			if err != nil {
+				return err
+			}
+			if created {
+				r.log.WithField("partition", partitionName(table, month)).Info("🧱 Created kline partition")
+			}
+		}
+		if cfg.RetainMonths > 0 {
+			return r.detachPartitions(tx, table, current.AddDate(0, -cfg.RetainMonths, 0))
+		}
+		return nil
+	})
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func partitionName(table string, month time.Time) string {
+	return table + "_p" + month.Format("2006_01")
+}
+
+func quoteIdent(name string) string {
+	return pgx.Identifier{name}.Sanitize()
+}
+
+func isPartitioned(tx *gorm.DB, table string) (bool, error) {
+	var kind string
+	err := tx.Raw("SELECT relkind::text FROM pg_class WHERE oid = to_regclass(?)", quoteIdent(table)).Scan(&kind).Error
+	if err != nil {
+		return false, fmt.Errorf("inspect %s: %w", table, err)
+	}
+	return kind == "p", nil
+}
+
+// tableIndex is the name and definition of an index other than the primary key.
+type tableIndex struct {
+	Name string
+	Def  string
+}
+
+// detachKeys drops the indexes and the primary key of a table and returns the indexes'
+// definitions, so they can be rebuilt on the table replacing it.
+func detachKeys(tx *gorm.DB, table string) ([]tableIndex, error) {
+	var indexes []tableIndex
+	err := tx.Raw(`SELECT indexname AS name, indexdef AS def FROM pg_indexes
+WHERE schemaname = current_schema() AND tablename = ?
+AND indexname NOT IN (SELECT conname FROM pg_constraint WHERE conrelid = to_regclass(?) AND contype = 'p')`,
+		table, quoteIdent(table)).Scan(&indexes).Error
+	if err != nil {
+		return nil, fmt.Errorf("read indexes: %w", err)
+	}
+	for _, idx := range indexes {
+		if err := tx.Exec("DROP INDEX ?", clause.Table{Name: idx.Name}).Error; err != nil {
+			return nil, fmt.Errorf("drop index %s: %w", idx.Name, err)
+		}
+	}
+	var primaryKey string
+	err = tx.Raw("SELECT conname FROM pg_constraint WHERE conrelid = to_regclass(?) AND contype = 'p'", quoteIdent(table)).Scan(&primaryKey).Error
+	if err != nil {
+		return nil, fmt.Errorf("read primary key: %w", err)
+	}
+	if primaryKey != "" {
+		if err := tx.Exec("ALTER TABLE ? DROP CONSTRAINT ?", clause.Table{Name: table}, clause.Column{Name: primaryKey}).Error; err != nil {
+			return nil, fmt.Errorf("drop primary key: %w", err)
+		}
+	}
+	return indexes, nil
+}
+
+// replaceTable renames table to old, creates the new table with create, hands it the id
+// sequence and copies the rows over. Indexes and the primary key are added by the caller.
+func replaceTable(tx *gorm.DB, table, old, create string) error {
+	if err := tx.Exec("ALTER TABLE ? RENAME TO ?", clause.Table{Name: table}, clause.Table{Name: old}).Error; err != nil {
+		return fmt.Errorf("rename table: %w", err)
+	}
+	if err := tx.Exec(create, clause.Table{Name: table}, clause.Table{Name: old}).Error; err != nil {
+		return fmt.Errorf("create table: %w", err)
+	}
+
+	// The id sequence belongs to the old table and would be dropped with it.
+	var sequence *string
+	if err := tx.Raw("SELECT pg_get_serial_sequence(?, 'id')", quoteIdent(old)).Scan(&sequence).Error; err != nil {
+		return fmt.Errorf("find id sequence: %w", err)
+	}
+	if sequence != nil {
+		if err := tx.Exec("ALTER SEQUENCE "+*sequence+" OWNED BY ?", clause.Column{Table: table, Name: "id"}).Error; err != nil {
+			return fmt.Errorf("move id sequence: %w", err)
+		}
+	}
+	return nil
+}
+
+// partitionKlines moves the kline rows into a table of the same name partitioned by open_time
+// month, when enabled on PostgreSQL. The indexes are rebuilt from their definitions, with the
+// primary key widened to include open_time as partitioning requires. Rows outside every month
+// land in a default partition.
+func partitionKlines(tx *gorm.DB, env MigrationEnv) error {
+	table := klineTable()
+	if !env.Partition || tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	if partitioned, err := isPartitioned(tx, table); err != nil || partitioned {
+		return err
+	}
+
+	old := table + "_unpartitioned"
+	indexes, err := detachKeys(tx, table)
+	if err != nil {
+		return err
+	}
+	if err := replaceTable(tx, table, old, "CREATE TABLE ? (LIKE ? INCLUDING DEFAULTS) PARTITION BY RANGE (open_time)"); err != nil {
+		return err
+	}
+	if err := tx.Exec("CREATE TABLE ? PARTITION OF ? DEFAULT", clause.Table{Name: table + "_default"}, clause.Table{Name: table}).Error; err != nil {
+		return fmt.Errorf("create default partition: %w", err)
+	}
+
+	var months []time.Time
+	err = tx.Raw("SELECT DISTINCT date_trunc('month', to_timestamp(open_time / 1000) AT TIME ZONE 'UTC') FROM ?", clause.Table{Name: old}).Scan(&months).Error
+	if err != nil {
+		return fmt.Errorf("read months: %w", err)
+	}
+	for _, month := range months {
+		if _, err := createPartition(tx, table, monthStart(month)); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Exec("INSERT INTO ? SELECT * FROM ?", clause.Table{Name: table}, clause.Table{Name: old}).Error; err != nil {
+		return fmt.Errorf("copy rows: %w", err)
+	}
+	if err := tx.Exec("ALTER TABLE ? ADD PRIMARY KEY (id, open_time)", clause.Table{Name: table}).Error; err != nil {
+		return fmt.Errorf("add primary key: %w", err)
+	}
+	for _, idx := range indexes {
+		if err := tx.Exec(idx.Def).Error; err != nil {
+			return fmt.Errorf("recreate index %s: %w", idx.Name, err)
+		}
+	}
+	if err := tx.Exec("DROP TABLE ?", clause.Table{Name: old}).Error; err != nil {
+		return fmt.Errorf("drop old table: %w", err)
+	}
+	return nil
+}
+
+// unpartitionKlines moves the rows of every attached partition back into a plain kline
+// table. Partitions detached past retention are left alone.
+func unpartitionKlines(tx *gorm.DB, _ MigrationEnv) error {
+	table := klineTable()
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	if partitioned, err := isPartitioned(tx, table); err != nil || !partitioned {
+		return err
+	}
+
+	old := table + "_partitioned"
+	indexes, err := detachKeys(tx, table)
+	if err != nil {
+		return err
+	}
+	if err := replaceTable(tx, table, old, "CREATE TABLE ? (LIKE ? INCLUDING DEFAULTS)"); err != nil {
+		return err
+	}
+	if err := tx.Exec("INSERT INTO ? SELECT * FROM ?", clause.Table{Name: table}, clause.Table{Name: old}).Error; err != nil {
+		return fmt.Errorf("copy rows: %w", err)
+	}
+	if err := tx.Exec("ALTER TABLE ? ADD PRIMARY KEY (id)", clause.Table{Name: table}).Error; err != nil {
+		return fmt.Errorf("add primary key: %w", err)
+	}
+	for _, idx := range indexes {
+		// Indexes of a partitioned table are defined ON ONLY the parent
+		if err := tx.Exec(strings.Replace(idx.Def, " ON ONLY ", " ON ", 1)).Error; err != nil {
+			return fmt.Errorf("recreate index %s: %w", idx.Name, err)
+		}
+	}
+	if err := tx.Exec("DROP TABLE ?", clause.Table{Name: old}).Error; err != nil {
+		return fmt.Errorf("drop partitioned table: %w", err)
+	}
+	return nil
+}
+
+// createPartition adds the partition of one month unless it exists, and reports whether it
+// did. Rows of that month already in the default partition are moved into it first.
+func createPartition(tx *gorm.DB, table string, month time.Time) (bool, error) {
+	name := partitionName(table, month)
+	if tx.Migrator().HasTable(name) {
+		return false, nil
+	}
+	from, to := month.UnixMilli(), month.AddDate(0, 1, 0).UnixMilli()
+	bounds := fmt.Sprintf("FOR VALUES FROM (%d) TO (%d)", from, to)
+	fallback := clause.Table{Name: table + "_default"}
+
+	var stray bool
+	err := tx.Raw("SELECT EXISTS (SELECT 1 FROM ? WHERE open_time >= ? AND open_time < ?)", fallback, from, to).Scan(&stray).Error
+	if err != nil {
+		return false, fmt.Errorf("check default partition: %w", err)
+	}
+
+	if !stray {
+		err = tx.Exec("CREATE TABLE ? PARTITION OF ? "+bounds, clause.Table{Name: name}, clause.Table{Name: table}).Error
+	} else {
+		err = tx.Exec("CREATE TABLE ? (LIKE ? INCLUDING DEFAULTS)", clause.Table{Name: name}, clause.Table{Name: table}).Error
+		if err == nil {
+			err = tx.Exec("WITH moved AS (DELETE FROM ? WHERE open_time >= ? AND open_time < ? RETURNING *) INSERT INTO ? SELECT * FROM moved",
+				fallback, from, to, clause.Table{Name: name}).Error
+		}
+		if err == nil {
+			err = tx.Exec("ALTER TABLE ? ATTACH PARTITION ? "+bounds, clause.Table{Name: table}, clause.Table{Name: name}).Error
+		}
+	}
+	if err != nil {
+		return false, fmt.Errorf("create partition %s: %w", name, err)
+	}
+	return true, nil
+}
+
+// detachPartitions detaches the monthly partitions that end at or before cutoff.
+func (r *GormRepository) detachPartitions(tx *gorm.DB, table string, cutoff time.Time) error {
+	var names []string
+	err := tx.Raw(`SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+WHERE i.inhparent = to_regclass(?)`, quoteIdent(table)).Scan(&names).Error
+	if err != nil {
+		return fmt.Errorf("list partitions: %w", err)
+	}
+
+	prefix := table + "_p"
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		month, err := time.Parse("2006_01", strings.TrimPrefix(name, prefix))
+		if err != nil || month.AddDate(0, 1, 0).After(cutoff) {
+			continue
+		}
+		if err := tx.Exec("ALTER TABLE ? DETACH PARTITION ?", clause.Table{Name: table}, clause.Table{Name: name}).Error; err != nil {
+			return fmt.Errorf("detach partition %s: %w", name, err)
+		}
+		r.log.WithField("partition", name).Info("🗄️ Detached kline partition past retention")
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/models"
+)
+
+// newIntegrationRepository migrates the database named by the DSN in env, skipping the test
+// when it is unset. Tables get their own prefix and are dropped again by reverting every migration.
+func newIntegrationRepository(t *testing.T, env string, open func(dsn string) (*gorm.DB, error), postgresCfg config.PostgresSettings) *GormRepository {
+	t.Helper()
+	dsn := os.Getenv(env)
+	if dsn == "" {
+		t.Skipf("%s is not set", env)
+	}
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	prefix := models.TablePrefix
+	models.TablePrefix = "Test_"
+	gormDB, err := open(dsn)
+	if err != nil {
+		models.TablePrefix = prefix
+		t.Fatalf("open %s: %v", env, err)
+	}
+	repo := NewGormRepository(gormDB, "binance", "test", DecimalScale{Price: 8, Volume: 8}, postgresCfg, log)
+	migrator := NewMigrator(gormDB, "binance", repo.scale, postgresCfg, log)
+	t.Cleanup(func() {
+		if _, err := migrator.Down(len(migrations)); err != nil {
+			t.Errorf("revert migrations: %v", err)
+		}
+		_ = repo.Close()
+		models.TablePrefix = prefix
+	})
+	if err := repo.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return repo
+}
+
+func TestPartitionMigration(t *testing.T) {
+	var settings config.PostgresSettings
+	settings.Partitioning.Enabled = true
+	repo := newIntegrationRepository(t, "TEST_POSTGRES_DSN", func(dsn string) (*gorm.DB, error) {
+		return gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	}, settings)
+	table := klineTable()
+
+	now := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+	var klines []models.SymbolKlineData
+	for _, at := range []time.Time{now.AddDate(0, -2, 0), now, now.AddDate(2, 0, 0)} {
+		klines = append(klines, models.SymbolKlineData{Symbol: "BTCUSDT", Interval: "1m", OpenTime: at.UnixMilli(), Source: models.SourceAggregated, Close: decimal.NewFromInt(100)})
+	}
+	if err := repo.SaveKlines(klines); err != nil {
+		t.Fatalf("SaveKlines: %v", err)
+	}
+	if err := repo.MaintainPartitions(now); err != nil {
+		t.Fatalf("MaintainPartitions: %v", err)
+	}
+	for _, month := range []time.Time{now, now.AddDate(0, 3, 0)} {
+		if !repo.db.Migrator().HasTable(partitionName(table, monthStart(month))) {
+			t.Errorf("no partition for %s", month.Format("2006-01"))
+		}
+	}
+
+	// Down to version 8 returns every attached row to a plain table
+	if _, err := NewMigrator(repo.db, "binance", repo.scale, settings, repo.log).Down(len(migrations) - 8); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if partitioned, err := isPartitioned(repo.db, table); err != nil || partitioned {
+		t.Fatalf("partitioned after Down = %v, %v", partitioned, err)
+	}
+	var count int64
+	if err := repo.db.Model(&models.SymbolKlineData{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != int64(len(klines)) {
+		t.Errorf("%d klines after Down, want %d", count, len(klines))
+	}
+	if err := repo.SaveKlines(klines); err != nil {
+		t.Errorf("the unique key was not restored: %v", err)
+	}
+	if err := repo.db.Model(&models.SymbolKlineData{}).Count(&count).Error; err != nil || count != int64(len(klines)) {
+		t.Errorf("%d klines after saving them again, want %d", count, len(klines))
+	}
+}
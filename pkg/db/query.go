@@ -0,0 +1,221 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/models"
+	"scanner.magictradebot.com/pkg/storage"
+)
+
+// Page sizes of QueryKlines.
+const (
+	DefaultPageSize = 1000
+	MaxPageSize     = 10000
+)
+
+// KlineQuery selects the klines of one series.
+type KlineQuery struct {
+	Exchange   string // empty for every exchange; RecentKlines and KlineMatrix require one
+	Symbol     string // normalized the same way as when saved
+	Interval   string
+	Source     string       // empty for aggregated klines
+	From       int64        // unix ms, inclusive
+	To         int64        // unix ms, inclusive; 0 for no upper bound
+	Limit      int          // page size (default DefaultPageSize, at most MaxPageSize)
+	Cursor     *KlineCursor // Next of the previous page; nil for the first page
+	Descending bool         // newest first
+}
+
+// KlineCursor is the position of the last kline of a page. Klines of several exchanges share
+// open times, so the exchange breaks the tie.
+type KlineCursor struct {
+	OpenTime int64
+	Exchange string
+}
+
+// KlinePage is one page of klines.
+type KlinePage struct {
+	Klines []models.SymbolKlineData
+	Next   *KlineCursor // cursor of the following page, nil after the last one
+}
+
+// KlineMatrix lines up the klines of several symbols by open time.
+type KlineMatrix struct {
+	Symbols   []string                            // column order
+	OpenTimes []int64                             // every open time any symbol has, ascending
+	Rows      map[int64][]*models.SymbolKlineData // per open time, one entry per symbol; nil where it has none
+}
+
+// Row returns the klines opening at openTime in the order of Symbols.
+func (m *KlineMatrix) Row(openTime int64) []*models.SymbolKlineData {
+	return m.Rows[openTime]
+}
+
+// series applies the query's filters except the range and paging.
+func (r *GormRepository) series(q KlineQuery) (*gorm.DB, error) {
+	if q.Interval == "" {
+		return nil, errors.New("interval is required")
+	}
+	source := q.Source
+	if source == "" {
+		source = models.SourceAggregated
+	}
+	conditions := map[string]interface{}{"interval": q.Interval, "source": source}
+	if q.Symbol != "" {
+		conditions["symbol"] = storage.StoredSymbol(q.Symbol)
+	}
+	if q.Exchange != "" {
+		conditions["exchange"] = strings.ToLower(q.Exchange)
+	}
+	return r.db.Model(&models.SymbolKlineData{}).Where(conditions), nil
+}
+
+// QueryKlines returns one page of a symbol's series within [From, To]. Pages are keyed by
+// open time and exchange, so rows written between requests never shift them.
+func (r *GormRepository) QueryKlines(q KlineQuery) (KlinePage, error) {
+	if q.Symbol == "" {
+		return KlinePage{}, errors.New("symbol is required")
+	}
+	query, err := r.series(q)
+	if err != nil {
+		return KlinePage{}, err
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	limit = min(limit, MaxPageSize)
+
+	query = query.Where("open_time >= ?", q.From)
+	if q.To > 0 {
+		query = query.Where("open_time <= ?", q.To)
+	}
+	order := "open_time, exchange"
+	if q.Descending {
+		order = "open_time DESC, exchange DESC"
+		if q.Cursor != nil {
+			query = query.Where("(open_time, exchange) < (?, ?)", q.Cursor.OpenTime, q.Cursor.Exchange)
+		}
+	} else if q.Cursor != nil {
+		query = query.Where("(open_time, exchange) > (?, ?)", q.Cursor.OpenTime, q.Cursor.Exchange)
+	}
+
+	var page KlinePage
+	if err := query.Order(order).Limit(limit).Find(&page.Klines).Error; err != nil {
+		return KlinePage{}, fmt.Errorf("query klines failed: %w", err)
+	}
+	if len(page.Klines) == limit {
+		last := page.Klines[len(page.Klines)-1]
+		page.Next = &KlineCursor{OpenTime: last.OpenTime, Exchange: last.Exchange}
+	}
+	return page, nil
+}
+
+// RecentKlines returns the n latest klines of one exchange's series of a symbol opening at or
+// before To (any time when 0), oldest first.
+func (r *GormRepository) RecentKlines(q KlineQuery, n int) ([]models.SymbolKlineData, error) {
+	if q.Symbol == "" {
+		return nil, errors.New("symbol is required")
+	}
+	if q.Exchange == "" {
+		return nil, errors.New("exchange is required, the latest klines of several exchanges would interleave")
+	}
+	query, err := r.series(q)
+	if err != nil {
+		return nil, err
+	}
+	if q.To > 0 {
+		query = query.Where("open_time <= ?", q.To)
+	}
+
+	var rows []models.SymbolKlineData
+	if err := query.Order("open_time DESC, exchange DESC").Limit(n).Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("load recent klines failed: %w", err)
+	}
+	for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+		rows[i], rows[j] = rows[j], rows[i]
+	}
+	return rows, nil
+}
+
+// KlineMatrix loads one exchange's klines of several symbols within [From, To] and lines them
+// up by open time. The query's Symbol, Limit, Cursor and Descending are ignored.
+func (r *GormRepository) KlineMatrix(q KlineQuery, symbols []string) (*KlineMatrix, error) {
+	if len(symbols) == 0 {
+		return nil, errors.New("no symbols given")
+	}
+	if q.Exchange == "" {
+		return nil, errors.New("exchange is required, a cell holds the kline of one exchange")
+	}
+	q.Symbol = ""
+	query, err := r.series(q)
+	if err != nil {
+		return nil, err
+	}
+
+	matrix := &KlineMatrix{Rows: make(map[int64][]*models.SymbolKlineData)}
+	column := make(map[string]int, len(symbols))
+	for _, s := range symbols {
+		s = storage.StoredSymbol(s)
+		if _, ok := column[s]; ok {
+			continue
+		}
+		column[s] = len(matrix.Symbols)
+		matrix.Symbols = append(matrix.Symbols, s)
+	}
+
+	query = query.Where("symbol IN ?", matrix.Symbols).Where("open_time >= ?", q.From)
+	if q.To > 0 {
+		query = query.Where("open_time <= ?", q.To)
+	}
+	var rows []models.SymbolKlineData
+	if err := query.Order("open_time, exchange").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("load kline matrix failed: %w", err)
+	}
+
+	for i := range rows {
+		k := &rows[i]
+		row, ok := matrix.Rows[k.OpenTime]
+		if !ok {
+			row = make([]*models.SymbolKlineData, len(matrix.Symbols))
+			matrix.Rows[k.OpenTime] = row
+			matrix.OpenTimes = append(matrix.OpenTimes, k.OpenTime)
+		}
+		if row[column[k.Symbol]] != nil {
+			return nil, fmt.Errorf("%s has two klines opening at %d", k.Symbol, k.OpenTime)
+		}
+		row[column[k.Symbol]] = k
+	}
+	sort.Slice(matrix.OpenTimes, func(i, j int) bool { return matrix.OpenTimes[i] < matrix.OpenTimes[j] })
+	return matrix, nil
+}
+
+// ResampledKlines loads a series within [From, To] and rolls it up into the coarser interval.
+// From is widened to the start of its bucket so the first candle is complete. The query's
+// Limit, Cursor and Descending are ignored.
+func (r *GormRepository) ResampledKlines(q KlineQuery, interval string, alignment config.AlignmentSettings) ([]models.SymbolKlineData, error) {
+	resampler, err := NewResampler(q.Interval, interval, alignment)
+	if err != nil {
+		return nil, err
+	}
+	query, err := r.series(q)
+	if err != nil {
+		return nil, err
+	}
+	query = query.Where("open_time >= ?", resampler.target.Floor(q.From))
+	if q.To > 0 {
+		query = query.Where("open_time <= ?", q.To)
+	}
+
+	var rows []models.SymbolKlineData
+	if err := query.Order("symbol").Order("open_time").Order("exchange").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("load klines to resample failed: %w", err)
+	}
+	return resampler.Resample(rows), nil
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/models"
+)
+
+func TestQueryKlinesPagesAcrossExchanges(t *testing.T) {
+	binance := newTestRepository(t, "binance")
+	okx := NewGormRepository(binance.db, "okx", "test", binance.scale, config.PostgresSettings{}, binance.log)
+
+	const minutes = 4
+	for _, repo := range []*GormRepository{binance, okx} {
+		var klines []models.SymbolKlineData
+		for i := int64(0); i < minutes; i++ {
+			klines = append(klines, models.SymbolKlineData{Symbol: "BTCUSDT", Interval: "1m", OpenTime: i * 60_000, Source: models.SourceAggregated})
+		}
+		if err := repo.SaveKlines(klines); err != nil {
+			t.Fatalf("SaveKlines: %v", err)
+		}
+	}
+
+	for _, descending := range []bool{false, true} {
+		t.Run(fmt.Sprintf("descending=%v", descending), func(t *testing.T) {
+			// Odd page sizes split the two exchanges' klines of one minute across pages
+			q := KlineQuery{Symbol: "BTCUSDT", Interval: "1m", Limit: 3, Descending: descending}
+			seen := make(map[string]bool)
+			var previous int64 = -1
+			for pages := 0; ; pages++ {
+				if pages > minutes*2 {
+					t.Fatal("paging does not end")
+				}
+				page, err := binance.QueryKlines(q)
+				if err != nil {
+					t.Fatalf("QueryKlines: %v", err)
+				}
+				for _, k := range page.Klines {
+					key := fmt.Sprintf("%s@%d", k.Exchange, k.OpenTime)
+					if seen[key] {
+						t.Errorf("%s returned twice", key)
+					}
+					seen[key] = true
+					if previous >= 0 && (k.OpenTime < previous) != descending && k.OpenTime != previous {
+						t.Errorf("%s is out of order", key)
+					}
+					previous = k.OpenTime
+				}
+				if page.Next == nil {
+					break
+				}
+				q.Cursor = page.Next
+			}
+			if len(seen) != minutes*2 {
+				t.Errorf("paged through %d klines, want %d", len(seen), minutes*2)
+			}
+		})
+	}
+}
+
+func TestSeriesOfOneExchange(t *testing.T) {
+	binance := newTestRepository(t, "binance")
+	okx := NewGormRepository(binance.db, "okx", "test", binance.scale, config.PostgresSettings{}, binance.log)
+
+	for _, repo := range []*GormRepository{binance, okx} {
+		var klines []models.SymbolKlineData
+		for _, symbol := range []string{"BTCUSDT", "ETHUSDT"} {
+			for i := int64(0); i < 3; i++ {
+				klines = append(klines, models.SymbolKlineData{Symbol: symbol, Interval: "1m", OpenTime: i * 60_000})
+			}
+		}
+		if err := repo.SaveKlines(klines); err != nil {
+			t.Fatalf("SaveKlines: %v", err)
+		}
+	}
+
+	q := KlineQuery{Symbol: "BTCUSDT", Interval: "1m"}
+	if _, err := binance.RecentKlines(q, 2); err == nil {
+		t.Error("RecentKlines mixed the exchanges")
+	}
+	if _, err := binance.KlineMatrix(q, []string{"BTCUSDT", "ETHUSDT"}); err == nil {
+		t.Error("KlineMatrix mixed the exchanges")
+	}
+
+	q.Exchange = "OKX"
+	recent, err := binance.RecentKlines(q, 2)
+	if err != nil {
+		t.Fatalf("RecentKlines: %v", err)
+	}
+	if len(recent) != 2 || recent[0].OpenTime != 60_000 || recent[1].OpenTime != 120_000 || recent[0].Exchange != "okx" || recent[1].Exchange != "okx" {
+		t.Errorf("recent klines = %+v, want okx's last two oldest first", recent)
+	}
+
+	matrix, err := binance.KlineMatrix(q, []string{"BTCUSDT", "ETH-USDT-SWAP"})
+	if err != nil {
+		t.Fatalf("KlineMatrix: %v", err)
+	}
+	if len(matrix.OpenTimes) != 3 {
+		t.Fatalf("matrix open times = %v", matrix.OpenTimes)
+	}
+	for _, openTime := range matrix.OpenTimes {
+		for i, k := range matrix.Row(openTime) {
+			if k == nil || k.Exchange != "okx" || k.Symbol != matrix.Symbols[i] {
+				t.Errorf("cell %s@%d = %+v", matrix.Symbols[i], openTime, k)
+			}
+		}
+	}
+}
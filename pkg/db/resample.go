@@ -0,0 +1,236 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/models"
+	"scanner.magictradebot.com/pkg/aggregator"
+)
+
+// resampleScale is the number of decimals kept for prices derived by division.
+const resampleScale = 18
+
+// rollUpSpan is the span of stored klines RollUpKlines resamples per query.
+const rollUpSpan = time.Hour
+
+// Resampler rolls stored klines up into a coarser interval.
+type Resampler struct {
+	sourceMs int64
+	interval string
+	target   aggregator.Boundary
+}
+
+// NewResampler checks that the target interval is coarser than the source interval.
+func NewResampler(source, target string, alignment config.AlignmentSettings) (*Resampler, error) {
+	sourceMs, err := aggregator.ParseInterval(source)
+	if err != nil {
+		return nil, err
+	}
+	targetMs, err := aggregator.ParseInterval(target)
+	if err != nil {
+		return nil, err
+	}
+	if targetMs <= sourceMs {
+		return nil, fmt.Errorf("cannot resample %s into %s; the target interval must be coarser", source, target)
+	}
+	boundary, err := aggregator.NewBoundary(target, alignment)
+	if err != nil {
+		return nil, err
+	}
+	return &Resampler{sourceMs: sourceMs, interval: target, target: boundary}, nil
+}
+
+// Resample rolls klines of the source interval up per exchange, symbol and source, ordered
+// by symbol and open time. Buckets not fully covered by source klines are marked partial.
+func (r *Resampler) Resample(klines []models.SymbolKlineData) []models.SymbolKlineData {
+	type seriesKey struct{ exchange, symbol, source string }
+	type bucketKey struct {
+		series   seriesKey
+		openTime int64
+	}
+
+	buckets := make(map[bucketKey][]models.SymbolKlineData)
+	for _, k := range klines {
+		key := bucketKey{seriesKey{k.Exchange, k.Symbol, k.Source}, r.target.Floor(k.OpenTime)}
+		buckets[key] = append(buckets[key], k)
+	}
+
+	result := make([]models.SymbolKlineData, 0, len(buckets))
+	for key, children := range buckets {
+		result = append(result, r.rollUp(key.openTime, children))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Symbol != b.Symbol {
+			return a.Symbol < b.Symbol
+		}
+		if a.Exchange != b.Exchange {
+			return a.Exchange < b.Exchange
+		}
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		return a.OpenTime < b.OpenTime
+	})
+	return result
+}
+
+// rollUp merges the source klines of one bucket.
+func (r *Resampler) rollUp(openTime int64, children []models.SymbolKlineData) models.SymbolKlineData {
+	sort.Slice(children, func(i, j int) bool { return children[i].OpenTime < children[j].OpenTime })
+	closeTime := r.target.Next(openTime)
+	first, last := children[0], children[len(children)-1]
+
+	k := models.SymbolKlineData{
+		Symbol:     first.Symbol,
+		Exchange:   first.Exchange,
+		Instance:   first.Instance,
+		Source:     first.Source,
+		Interval:   r.interval,
+		OpenTime:   openTime,
+		Open:       first.Open,
+		High:       first.High,
+		Low:        first.Low,
+		Close:      last.Close,
+		Alignment:  r.target.Label(),
+		Superseded: true,
+		Synthetic:  true,
+	}
+
+	var covered, maxGap int64
+	lastSample := openTime
+	for _, c := range children {
+		k.High = decimal.Max(k.High, c.High)
+		k.Low = decimal.Min(k.Low, c.Low)
+		k.Volume = k.Volume.Add(c.Volume)
+		k.Turnover = k.Turnover.Add(c.Turnover)
+		k.TradeCount += c.TradeCount
+		k.SampleCount += c.SampleCount
+		k.Amendments = max(k.Amendments, c.Amendments)
+		k.Partial = k.Partial || c.Partial
+		k.Synthetic = k.Synthetic && c.Synthetic
+		k.Superseded = k.Superseded && c.Superseded
+		covered += r.sourceMs
+		maxGap = max(maxGap, c.MaxGapMs)
+
+		if c.SampleCount == 0 {
+			continue
+		}
+		if k.FirstSampleAt == 0 || c.FirstSampleAt < k.FirstSampleAt {
+			k.FirstSampleAt = c.FirstSampleAt
+		}
+		k.LastSampleAt = max(k.LastSampleAt, c.LastSampleAt)
+		maxGap = max(maxGap, c.FirstSampleAt-lastSample)
+		lastSample = max(lastSample, c.LastSampleAt)
+	}
+	if k.SampleCount > 0 {
+		maxGap = max(maxGap, closeTime-lastSample)
+	}
+
+	k.TypicalPrice = k.High.Add(k.Low).Add(k.Close).DivRound(decimal.NewFromInt(3), resampleScale)
+	k.VWAP = k.TypicalPrice
+	if k.Volume.IsPositive() {
+		k.VWAP = k.Turnover.DivRound(k.Volume, resampleScale)
+	}
+	if k.LastSampleAt > k.FirstSampleAt {
+		k.Coverage = min(float64(k.LastSampleAt-k.FirstSampleAt)/float64(closeTime-openTime), 1)
+	}
+	k.MaxGapMs = maxGap
+	k.Partial = k.Partial || covered < closeTime-openTime
+	return k
+}
+
+// RollUpKlines resamples the exchange's klines of one interval opening within [from, before)
+// into the target interval and inserts the candles not stored yet, returning how many were
+// built. before should lie on a target boundary. With dryRun nothing is written.
+func (r *GormRepository) RollUpKlines(interval, target string, alignment config.AlignmentSettings, from, before int64, dryRun bool) (int64, error) {
+	resampler, err := NewResampler(interval, target, alignment)
+	if err != nil {
+		return 0, err
+	}
+	conditions := map[string]interface{}{"exchange": r.exchange, "interval": interval}
+
+	var built, inserted int64
+	for start := resampler.target.Floor(from); start < before; {
+		end := start + rollUpSpan.Milliseconds()
+		if floor := resampler.target.Floor(end); floor > start {
+			end = floor
+		} else {
+			end = resampler.target.Next(start)
+		}
+		end = min(end, before)
+
+		rolled, err := r.rollUpWindow(resampler, conditions, start, end)
+		if err != nil {
+			return built, err
+		}
+		start = end
+		if len(rolled) == 0 {
+			continue
+		}
+
+		built += int64(len(rolled))
+		if dryRun {
+			continue
+		}
+		n, err := r.insertKlines(rolled)
+		if err != nil {
+			return built, fmt.Errorf("roll up %s into %s: %w", interval, target, err)
+		}
+		inserted += n
+	}
+
+	if inserted > 0 {
+		r.log.WithFields(logrus.Fields{
+			"instance": r.instance,
+			"interval": interval,
+			"target":   target,
+			"built":    built,
+			"inserted": inserted,
+		}).Info("🧮 Rolled up klines")
+	}
+	return built, nil
+}
+
+// rollUpWindow resamples the klines opening within [start, end). Rows are streamed per symbol
+// and source, so only the klines of one target bucket are held at a time.
+func (r *GormRepository) rollUpWindow(resampler *Resampler, conditions map[string]interface{}, start, end int64) ([]models.SymbolKlineData, error) {
+	rows, err := r.db.Model(&models.SymbolKlineData{}).
+		Where(conditions).
+		Where("open_time >= ? AND open_time < ?", start, end).
+		Order("symbol").Order("source").Order("open_time").
+		Rows()
+	if err != nil {
+		return nil, fmt.Errorf("load klines to roll up failed: %w", err)
+	}
+	defer rows.Close()
+
+	var rolled, bucket []models.SymbolKlineData
+	for rows.Next() {
+		var k models.SymbolKlineData
+		if err := r.db.ScanRows(rows, &k); err != nil {
+			return nil, fmt.Errorf("scan kline failed: %w", err)
+		}
+		if len(bucket) > 0 {
+			first := bucket[0]
+			if first.Symbol != k.Symbol || first.Source != k.Source || resampler.target.Floor(first.OpenTime) != resampler.target.Floor(k.OpenTime) {
+				rolled = append(rolled, resampler.Resample(bucket)...)
+				bucket = bucket[:0]
+			}
+		}
+		bucket = append(bucket, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("load klines to roll up failed: %w", err)
+	}
+	if len(bucket) > 0 {
+		rolled = append(rolled, resampler.Resample(bucket)...)
+	}
+	return rolled, nil
+}
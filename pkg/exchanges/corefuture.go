@@ -2,9 +2,13 @@ package exchanges
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/shopspring/decimal"
+	"scanner.magictradebot.com/models"
 	"scanner.magictradebot.com/pkg/binance"
 	"scanner.magictradebot.com/pkg/bitget"
 	"scanner.magictradebot.com/pkg/bybit"
@@ -13,14 +17,15 @@ import (
 
 // TickerInfo is a generic struct for normalized ticker data across exchanges
 type TickerInfo struct {
-	Symbol    string
-	LastPrice string
-	High24h   string
-	Low24h    string
-	Vol24h    string
-	Change24h string
-	Exchange  string
-	Timestamp int64 `json:"timestamp"`
+	Symbol      string
+	LastPrice   string
+	High24h     string
+	Low24h      string
+	Vol24h      string
+	QuoteVol24h string // 24h turnover in quote currency, empty when not reported
+	Change24h   string
+	Exchange    string
+	Timestamp   int64 `json:"timestamp"`
 }
 
 // CoreFuturesAllTickers fetches all tickers from the specified exchange
@@ -34,16 +39,7 @@ func CoreFuturesAllTickers(exchange string) ([]*TickerInfo, error) {
 		}
 		result := make([]*TickerInfo, 0, len(data))
 		for _, t := range data {
-			result = append(result, &TickerInfo{
-				Symbol:    t.Symbol,
-				LastPrice: t.LastPrice,
-				High24h:   "",
-				Low24h:    "",
-				Vol24h:    t.Volume,
-				Change24h: t.PriceChangePercent,
-				Exchange:  "binance",
-				Timestamp: time.Now().UnixMilli(),
-			})
+			result = append(result, fromBinance(t))
 		}
 		return result, nil
 
@@ -54,16 +50,7 @@ func CoreFuturesAllTickers(exchange string) ([]*TickerInfo, error) {
 		}
 		result := make([]*TickerInfo, 0, len(data))
 		for _, t := range data {
-			result = append(result, &TickerInfo{
-				Symbol:    t.InstrumentID,
-				LastPrice: t.LastPrice,
-				High24h:   t.High24h,
-				Low24h:    t.Low24h,
-				Vol24h:    t.Vol24h,
-				Change24h: t.Change24hPct,
-				Exchange:  "okx",
-				Timestamp: time.Now().UnixMilli(),
-			})
+			result = append(result, fromOKX(t))
 		}
 		return result, nil
 
@@ -74,16 +61,7 @@ func CoreFuturesAllTickers(exchange string) ([]*TickerInfo, error) {
 		}
 		result := make([]*TickerInfo, 0, len(data))
 		for _, t := range data {
-			result = append(result, &TickerInfo{
-				Symbol:    t.Symbol,
-				LastPrice: t.LastPrice,
-				High24h:   t.High24h,
-				Low24h:    t.Low24h,
-				Vol24h:    t.BaseVolume,
-				Change24h: t.Change24hPercent,
-				Exchange:  "bitget",
-				Timestamp: time.Now().UnixMilli(),
-			})
+			result = append(result, fromBitget(t))
 		}
 		return result, nil
 
@@ -94,16 +72,7 @@ func CoreFuturesAllTickers(exchange string) ([]*TickerInfo, error) {
 		}
 		result := make([]*TickerInfo, 0, len(data))
 		for _, t := range data {
-			result = append(result, &TickerInfo{
-				Symbol:    t.Symbol,
-				LastPrice: t.LastPrice,
-				High24h:   t.HighPrice24h,
-				Low24h:    t.LowPrice24h,
-				Vol24h:    t.Volume24h,
-				Change24h: t.Price24hPcnt,
-				Exchange:  "bybit",
-				Timestamp: time.Now().UnixMilli(),
-			})
+			result = append(result, fromBybit(t))
 		}
 		return result, nil
 
@@ -111,3 +80,350 @@ func CoreFuturesAllTickers(exchange string) ([]*TickerInfo, error) {
 		return nil, errors.New("unsupported exchange: " + exchange)
 	}
 }
+
+// CoreFuturesTicker fetches a single symbol's ticker from the per-symbol endpoint
+func CoreFuturesTicker(exchange, symbol string) (*TickerInfo, error) {
+	switch strings.ToLower(exchange) {
+	case "binance":
+		t, err := binance.GetTickerInfo(symbol)
+		if err != nil {
+			return nil, err
+		}
+		return fromBinance(t), nil
+
+	case "okx":
+		t, err := okx.GetTickerInfo(symbol)
+		if err != nil {
+			return nil, err
+		}
+		return fromOKX(t), nil
+
+	case "bitget":
+		t, err := bitget.GetTickerInfo(symbol)
+		if err != nil {
+			return nil, err
+		}
+		return fromBitget(t), nil
+
+	case "bybit":
+		t, err := bybit.GetTickerInfo(symbol)
+		if err != nil {
+			return nil, err
+		}
+		return fromBybit(t), nil
+
+	default:
+		return nil, errors.New("unsupported exchange: " + exchange)
+	}
+}
+
+// KlineInfo is a closed candle as published by the exchange.
+type KlineInfo struct {
+	Symbol      string
+	Interval    string
+	OpenTime    int64
+	Open        string
+	High        string
+	Low         string
+	Close       string
+	Volume      string
+	QuoteVolume string
+	Exchange    string
+}
+
+// ErrUnsupportedInterval is returned when the exchange publishes no klines for an interval.
+var ErrUnsupportedInterval = errors.New("interval not offered by exchange")
+
+// CoreFuturesKlines fetches the exchange's own candles for a symbol opening within [from, to] (unix ms).
+func CoreFuturesKlines(exchange, symbol, interval string, from, to int64, limit int) ([]*KlineInfo, error) {
+	ex := strings.ToLower(exchange)
+
+	type kline struct {
+		openTime                                    int64
+		open, high, low, close, volume, quoteVolume string
+	}
+	var rows []kline
+
+	switch ex {
+	case "binance":
+		bar, ok := binance.KlineInterval(interval)
+		if !ok {
+			return nil, ErrUnsupportedInterval
+		}
+		data, err := binance.GetKlines(symbol, bar, from, to, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range data {
+			rows = append(rows, kline{k.OpenTime, k.Open, k.High, k.Low, k.Close, k.Volume, k.QuoteVolume})
+		}
+
+	case "okx":
+		bar, ok := okx.KlineInterval(interval)
+		if !ok {
+			return nil, ErrUnsupportedInterval
+		}
+		data, err := okx.GetKlines(symbol, bar, from, to, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range data {
+			rows = append(rows, kline{k.OpenTime, k.Open, k.High, k.Low, k.Close, k.Volume, k.QuoteVolume})
+		}
+
+	case "bitget":
+		bar, ok := bitget.KlineInterval(interval)
+		if !ok {
+			return nil, ErrUnsupportedInterval
+		}
+		data, err := bitget.GetKlines(symbol, bar, from, to, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range data {
+			rows = append(rows, kline{k.OpenTime, k.Open, k.High, k.Low, k.Close, k.Volume, k.QuoteVolume})
+		}
+
+	case "bybit":
+		bar, ok := bybit.KlineInterval(interval)
+		if !ok {
+			return nil, ErrUnsupportedInterval
+		}
+		data, err := bybit.GetKlines(symbol, bar, from, to, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range data {
+			rows = append(rows, kline{k.OpenTime, k.Open, k.High, k.Low, k.Close, k.Volume, k.QuoteVolume})
+		}
+
+	default:
+		return nil, errors.New("unsupported exchange: " + exchange)
+	}
+
+	result := make([]*KlineInfo, 0, len(rows))
+	for _, k := range rows {
+		// Exchanges pad the range differently, so keep exactly [from, to]
+		if k.openTime < from || k.openTime > to {
+			continue
+		}
+		result = append(result, &KlineInfo{
+			Symbol:      symbol,
+			Interval:    interval,
+			OpenTime:    k.openTime,
+			Open:        k.open,
+			High:        k.high,
+			Low:         k.low,
+			Close:       k.close,
+			Volume:      k.volume,
+			QuoteVolume: k.quoteVolume,
+			Exchange:    ex,
+		})
+	}
+	return result, nil
+}
+
+// ToKlineData converts the candle into a kline row with the exchange source. Values are parsed
+// as exact decimals; derived prices keep 18 decimals like the aggregator's.
+func (k *KlineInfo) ToKlineData() (models.SymbolKlineData, error) {
+	var values [6]decimal.Decimal
+	for i, raw := range []string{k.Open, k.High, k.Low, k.Close, k.Volume, k.QuoteVolume} {
+		v, err := decimal.NewFromString(raw)
+		if err != nil {
+			return models.SymbolKlineData{}, fmt.Errorf("parse %s kline at %d: %w", k.Symbol, k.OpenTime, err)
+		}
+		values[i] = v
+	}
+	open, high, low, closePrice, volume, turnover := values[0], values[1], values[2], values[3], values[4], values[5]
+
+	typical := high.Add(low).Add(closePrice).DivRound(decimal.NewFromInt(3), 18)
+	vwap := typical
+	if volume.IsPositive() {
+		vwap = turnover.DivRound(volume, 18)
+	}
+
+	return models.SymbolKlineData{
+		Symbol:       k.Symbol,
+		Interval:     k.Interval,
+		Open:         open,
+		High:         high,
+		Low:          low,
+		Close:        closePrice,
+		OpenTime:     k.OpenTime,
+		Source:       models.SourceExchange,
+		Volume:       volume,
+		Turnover:     turnover,
+		VWAP:         vwap,
+		TypicalPrice: typical,
+	}, nil
+}
+
+// InstrumentInfo is the price and quantity increment of a contract.
+type InstrumentInfo struct {
+	Symbol   string
+	TickSize string // smallest price increment, e.g. "0.0000001"
+	StepSize string // smallest quantity increment
+	Exchange string
+}
+
+// CoreFuturesInstruments fetches the tick and step sizes of every contract on the exchange.
+func CoreFuturesInstruments(exchange string) ([]*InstrumentInfo, error) {
+	ex := strings.ToLower(exchange)
+	var result []*InstrumentInfo
+
+	switch ex {
+	case "binance":
+		data, err := binance.GetInstruments()
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range data {
+			result = append(result, &InstrumentInfo{Symbol: i.Symbol, TickSize: i.TickSize, StepSize: i.StepSize, Exchange: ex})
+		}
+
+	case "okx":
+		data, err := okx.GetInstruments()
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range data {
+			result = append(result, &InstrumentInfo{Symbol: i.InstrumentID, TickSize: i.TickSize, StepSize: i.BaseLotSize(), Exchange: ex})
+		}
+
+	case "bitget":
+		data, err := bitget.GetInstruments()
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range data {
+			result = append(result, &InstrumentInfo{Symbol: i.Symbol, TickSize: i.TickSize(), StepSize: i.SizeMultiplier, Exchange: ex})
+		}
+
+	case "bybit":
+		data, err := bybit.GetInstruments()
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range data {
+			result = append(result, &InstrumentInfo{Symbol: i.Symbol, TickSize: i.TickSize, StepSize: i.StepSize, Exchange: ex})
+		}
+
+	default:
+		return nil, errors.New("unsupported exchange: " + exchange)
+	}
+	return result, nil
+}
+
+// DecimalPlaces returns the number of significant decimals of an increment such as "0.00010",
+// or 0 when it does not parse.
+func DecimalPlaces(increment string) int {
+	d, err := decimal.NewFromString(increment)
+	if err != nil {
+		return 0
+	}
+	places := 0
+	for !d.Equal(d.Truncate(int32(places))) {
+		places++
+	}
+	return places
+}
+
+// RateLimitBudget reports the remaining request budget for the exchange.
+// ok is false until the exchange has reported its limits.
+func RateLimitBudget(exchange string) (remaining, limit int, ok bool) {
+	switch strings.ToLower(exchange) {
+	case "binance":
+		return binance.RateLimitBudget()
+	case "okx":
+		return okx.RateLimitBudget()
+	case "bitget":
+		return bitget.RateLimitBudget()
+	case "bybit":
+		return bybit.RateLimitBudget()
+	default:
+		return 0, 0, false
+	}
+}
+
+func fromBinance(t *binance.TickerInfo) *TickerInfo {
+	return &TickerInfo{
+		Symbol:      t.Symbol,
+		LastPrice:   t.LastPrice,
+		High24h:     "",
+		Low24h:      "",
+		Vol24h:      t.Volume,
+		QuoteVol24h: t.QuoteVolume,
+		Change24h:   t.PriceChangePercent,
+		Exchange:    "binance",
+		Timestamp:   exchangeTimestamp(t.CloseTime),
+	}
+}
+
+// fromOKX reports volumes in the base currency rather than contracts. OKX tickers carry
+// no quote volume, so it is derived from the base volume and the last price.
+func fromOKX(t *okx.OKXTickerInfo) *TickerInfo {
+	var quoteVol string
+	base, baseErr := decimal.NewFromString(t.VolCcy24h)
+	last, lastErr := decimal.NewFromString(t.LastPrice)
+	if baseErr == nil && lastErr == nil {
+		quoteVol = base.Mul(last).String()
+	}
+
+	return &TickerInfo{
+		Symbol:      t.InstrumentID,
+		LastPrice:   t.LastPrice,
+		High24h:     t.High24h,
+		Low24h:      t.Low24h,
+		Vol24h:      t.VolCcy24h,
+		QuoteVol24h: quoteVol,
+		Change24h:   t.Change24hPct,
+		Exchange:    "okx",
+		Timestamp:   parseTimestamp(t.Timestamp),
+	}
+}
+
+func fromBitget(t *bitget.BitgetTickerInfo) *TickerInfo {
+	return &TickerInfo{
+		Symbol:      t.Symbol,
+		LastPrice:   t.LastPrice,
+		High24h:     t.High24h,
+		Low24h:      t.Low24h,
+		Vol24h:      t.BaseVolume,
+		QuoteVol24h: t.QuoteVolume,
+		Change24h:   t.Change24hPercent,
+		Exchange:    "bitget",
+		Timestamp:   parseTimestamp(t.Timestamp),
+	}
+}
+
+func fromBybit(t *bybit.BybitTickerInfo) *TickerInfo {
+	return &TickerInfo{
+		Symbol:      t.Symbol,
+		LastPrice:   t.LastPrice,
+		High24h:     t.HighPrice24h,
+		Low24h:      t.LowPrice24h,
+		Vol24h:      t.Volume24h,
+		QuoteVol24h: t.Turnover24h,
+		Change24h:   t.Price24hPcnt,
+		Exchange:    "bybit",
+		Timestamp:   exchangeTimestamp(t.Timestamp),
+	}
+}
+
+func parseTimestamp(raw string) int64 {
+	ms, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return time.Now().UnixMilli()
+	}
+	return exchangeTimestamp(ms)
+}
+
+// exchangeTimestamp returns the exchange event time, or now when the exchange did not provide one.
+// Timestamps ahead of the local clock are clamped so clock skew cannot open future candles.
+func exchangeTimestamp(ms int64) int64 {
+	now := time.Now().UnixMilli()
+	if ms <= 0 || ms > now {
+		return now
+	}
+	return ms
+}
@@ -0,0 +1,54 @@
+package exchanges
+
+import (
+	"testing"
+
+	"scanner.magictradebot.com/pkg/okx"
+)
+
+func TestFromOKXUsesBaseVolumes(t *testing.T) {
+	tests := []struct {
+		name      string
+		ticker    okx.OKXTickerInfo
+		wantVol   string
+		wantQuote string
+	}{
+		{
+			name:      "linear swap",
+			ticker:    okx.OKXTickerInfo{InstrumentID: "BTC-USDT-SWAP", LastPrice: "60000", Vol24h: "1000", VolCcy24h: "10"},
+			wantVol:   "10",
+			wantQuote: "600000",
+		},
+		{
+			name:      "no last price",
+			ticker:    okx.OKXTickerInfo{InstrumentID: "ETH-USDT-SWAP", Vol24h: "50", VolCcy24h: "5"},
+			wantVol:   "5",
+			wantQuote: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fromOKX(&tt.ticker)
+			if got.Vol24h != tt.wantVol || got.QuoteVol24h != tt.wantQuote {
+				t.Errorf("volume = %q, quote volume = %q, want %q and %q", got.Vol24h, got.QuoteVol24h, tt.wantVol, tt.wantQuote)
+			}
+		})
+	}
+}
+
+func TestOKXBaseLotSize(t *testing.T) {
+	tests := []struct {
+		lot, ctVal string
+		want       string
+	}{
+		{lot: "0.01", ctVal: "0.01", want: "0.0001"},
+		{lot: "1", ctVal: "10", want: "10"},
+		{lot: "1", ctVal: "", want: "1"},
+	}
+	for _, tt := range tests {
+		i := okx.Instrument{LotSize: tt.lot, CtVal: tt.ctVal}
+		if got := i.BaseLotSize(); got != tt.want {
+			t.Errorf("lot %s × ctVal %q = %s, want %s", tt.lot, tt.ctVal, got, tt.want)
+		}
+	}
+}
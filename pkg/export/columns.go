@@ -0,0 +1,78 @@
+package export
+
+import (
+	"fmt"
+	"strings"
+
+	"scanner.magictradebot.com/models"
+)
+
+// column describes one exportable kline field.
+type column struct {
+	name        string
+	parquetType string // type clause of the Parquet JSON schema
+	value       func(k *models.SymbolKlineData) interface{}
+}
+
+// decimalType stores exact prices and volumes as their decimal text.
+const decimalType = "type=BYTE_ARRAY, convertedtype=UTF8"
+
+var allColumns = []column{
+	{"exchange", "type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY", func(k *models.SymbolKlineData) interface{} { return k.Exchange }},
+	{"symbol", "type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY", func(k *models.SymbolKlineData) interface{} { return k.Symbol }},
+	{"interval", "type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY", func(k *models.SymbolKlineData) interface{} { return k.Interval }},
+	{"source", "type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY", func(k *models.SymbolKlineData) interface{} { return k.Source }},
+	{"open_time", "type=INT64, convertedtype=TIMESTAMP_MILLIS", func(k *models.SymbolKlineData) interface{} { return k.OpenTime }},
+	{"open", decimalType, func(k *models.SymbolKlineData) interface{} { return k.Open }},
+	{"high", decimalType, func(k *models.SymbolKlineData) interface{} { return k.High }},
+	{"low", decimalType, func(k *models.SymbolKlineData) interface{} { return k.Low }},
+	{"close", decimalType, func(k *models.SymbolKlineData) interface{} { return k.Close }},
+	{"volume", decimalType, func(k *models.SymbolKlineData) interface{} { return k.Volume }},
+	{"turnover", decimalType, func(k *models.SymbolKlineData) interface{} { return k.Turnover }},
+	{"vwap", decimalType, func(k *models.SymbolKlineData) interface{} { return k.VWAP }},
+	{"typical_price", decimalType, func(k *models.SymbolKlineData) interface{} { return k.TypicalPrice }},
+	{"trade_count", "type=INT64", func(k *models.SymbolKlineData) interface{} { return k.TradeCount }},
+	{"amendments", "type=INT32", func(k *models.SymbolKlineData) interface{} { return int32(k.Amendments) }},
+	{"fill_policy", "type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY", func(k *models.SymbolKlineData) interface{} { return k.FillPolicy }},
+	{"synthetic", "type=BOOLEAN", func(k *models.SymbolKlineData) interface{} { return k.Synthetic }},
+	{"superseded", "type=BOOLEAN", func(k *models.SymbolKlineData) interface{} { return k.Superseded }},
+	{"alignment", "type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY", func(k *models.SymbolKlineData) interface{} { return k.Alignment }},
+	{"sample_count", "type=INT64", func(k *models.SymbolKlineData) interface{} { return k.SampleCount }},
+	{"first_sample_at", "type=INT64", func(k *models.SymbolKlineData) interface{} { return k.FirstSampleAt }},
+	{"last_sample_at", "type=INT64", func(k *models.SymbolKlineData) interface{} { return k.LastSampleAt }},
+	{"coverage", "type=DOUBLE", func(k *models.SymbolKlineData) interface{} { return k.Coverage }},
+	{"max_gap_ms", "type=INT64", func(k *models.SymbolKlineData) interface{} { return k.MaxGapMs }},
+	{"partial", "type=BOOLEAN", func(k *models.SymbolKlineData) interface{} { return k.Partial }},
+	{"instance", "type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY", func(k *models.SymbolKlineData) interface{} { return k.Instance }},
+}
+
+// ColumnNames lists every exportable column in default order.
+func ColumnNames() []string {
+	names := make([]string, 0, len(allColumns))
+	for _, c := range allColumns {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// selectColumns resolves the requested column names, defaulting to all columns.
+func selectColumns(names []string) ([]column, error) {
+	if len(names) == 0 {
+		return allColumns, nil
+	}
+
+	byName := make(map[string]column, len(allColumns))
+	for _, c := range allColumns {
+		byName[c.name] = c
+	}
+
+	selected := make([]column, 0, len(names))
+	for _, name := range names {
+		c, ok := byName[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q, available: %s", name, strings.Join(ColumnNames(), ", "))
+		}
+		selected = append(selected, c)
+	}
+	return selected, nil
+}
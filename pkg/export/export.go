@@ -0,0 +1,243 @@
+package export
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/shopspring/decimal"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+	"scanner.magictradebot.com/models"
+	"scanner.magictradebot.com/pkg/storage"
+)
+
+// Output formats.
+const (
+	FormatCSV     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+// Options selects and shapes the exported klines.
+type Options struct {
+	Filter      storage.KlineFilter
+	Format      string   // csv, jsonl or parquet
+	Compression string   // none, gzip or zstd; Parquet compresses its pages instead of the stream
+	Columns     []string // empty for all columns
+}
+
+// rowWriter encodes klines in one output format.
+type rowWriter interface {
+	Write(k *models.SymbolKlineData) error
+	Close() error
+}
+
+// Run streams the klines selected by opts from repo into w and returns the number of rows written.
+func Run(repo storage.KlineRepository, opts Options, w io.Writer) (int64, error) {
+	columns, err := selectColumns(opts.Columns)
+	if err != nil {
+		return 0, err
+	}
+
+	buffered := bufio.NewWriterSize(w, 1<<20)
+	out, closeStream, err := compressStream(buffered, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	var rw rowWriter
+	switch strings.ToLower(opts.Format) {
+	case FormatCSV, "":
+		rw, err = newCSVWriter(out, columns)
+	case FormatJSONL, "json":
+		rw = &jsonlWriter{out: out, columns: columns}
+	case FormatParquet:
+		rw, err = newParquetWriter(out, columns, opts.Compression)
+	default:
+		err = fmt.Errorf("unsupported export format %q", opts.Format)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	err = repo.EachKline(opts.Filter, func(k models.SymbolKlineData) error {
+		count++
+		return rw.Write(&k)
+	})
+	if err != nil {
+		return count, err
+	}
+
+	if err := rw.Close(); err != nil {
+		return count, err
+	}
+	if err := closeStream(); err != nil {
+		return count, err
+	}
+	return count, buffered.Flush()
+}
+
+// compressStream wraps w in the requested stream compression.
+func compressStream(w io.Writer, opts Options) (io.Writer, func() error, error) {
+	noop := func() error { return nil }
+	if strings.EqualFold(opts.Format, FormatParquet) {
+		return w, noop, nil
+	}
+
+	switch strings.ToLower(opts.Compression) {
+	case "", "none":
+		return w, noop, nil
+	case "gzip":
+		gz := gzip.NewWriter(w)
+		return gz, gz.Close, nil
+	case "zstd":
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zw, zw.Close, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported compression %q", opts.Compression)
+}
+
+type csvWriter struct {
+	out     *csv.Writer
+	columns []column
+	record  []string
+}
+
+func newCSVWriter(w io.Writer, columns []column) (*csvWriter, error) {
+	cw := &csvWriter{out: csv.NewWriter(w), columns: columns, record: make([]string, len(columns))}
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c.name
+	}
+	return cw, cw.out.Write(header)
+}
+
+func (w *csvWriter) Write(k *models.SymbolKlineData) error {
+	for i, c := range w.columns {
+		w.record[i] = formatValue(c.value(k))
+	}
+	return w.out.Write(w.record)
+}
+
+func (w *csvWriter) Close() error {
+	w.out.Flush()
+	return w.out.Error()
+}
+
+type jsonlWriter struct {
+	out     io.Writer
+	columns []column
+	line    []byte
+}
+
+func (w *jsonlWriter) Write(k *models.SymbolKlineData) error {
+	line, err := encodeObject(w.line[:0], w.columns, k, false)
+	if err != nil {
+		return err
+	}
+	w.line = append(line, '\n')
+	_, err = w.out.Write(w.line)
+	return err
+}
+
+func (w *jsonlWriter) Close() error {
+	return nil
+}
+
+type parquetWriter struct {
+	pw      *writer.JSONWriter
+	columns []column
+	line    []byte
+}
+
+func newParquetWriter(w io.Writer, columns []column, compression string) (*parquetWriter, error) {
+	fields := make([]string, len(columns))
+	for i, c := range columns {
+		fields[i] = fmt.Sprintf(`{"Tag":"name=%s, %s"}`, c.name, c.parquetType)
+	}
+	schema := `{"Tag":"name=kline","Fields":[` + strings.Join(fields, ",") + `]}`
+
+	pw, err := writer.NewJSONWriterFromWriter(schema, w, 4)
+	if err != nil {
+		return nil, fmt.Errorf("parquet schema: %w", err)
+	}
+	switch strings.ToLower(compression) {
+	case "", "none":
+		pw.CompressionType = parquet.CompressionCodec_UNCOMPRESSED
+	case "gzip":
+		pw.CompressionType = parquet.CompressionCodec_GZIP
+	case "zstd":
+		pw.CompressionType = parquet.CompressionCodec_ZSTD
+	case "snappy":
+		pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+	return &parquetWriter{pw: pw, columns: columns}, nil
+}
+
+func (w *parquetWriter) Write(k *models.SymbolKlineData) error {
+	line, err := encodeObject(w.line[:0], w.columns, k, true)
+	if err != nil {
+		return err
+	}
+	w.line = line
+	return w.pw.Write(string(line))
+}
+
+func (w *parquetWriter) Close() error {
+	return w.pw.WriteStop()
+}
+
+// encodeObject appends the selected columns as a JSON object, keeping column order. Decimals
+// are written as exact JSON numbers, or as strings when quoteDecimals is set.
+func encodeObject(buf []byte, columns []column, k *models.SymbolKlineData, quoteDecimals bool) ([]byte, error) {
+	buf = append(buf, '{')
+	for i, c := range columns {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = strconv.AppendQuote(buf, c.name)
+		buf = append(buf, ':')
+		v := c.value(k)
+		if d, ok := v.(decimal.Decimal); ok && !quoteDecimals {
+			buf = append(buf, d.String()...)
+			continue
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, value...)
+	}
+	return append(buf, '}'), nil
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case decimal.Decimal:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,185 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/shopspring/decimal"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+	"scanner.magictradebot.com/models"
+	"scanner.magictradebot.com/pkg/storage"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// testRepository holds two candles each of BTC, ETH and ETHFI on one exchange.
+func testRepository(t *testing.T) *storage.MemoryRepository {
+	t.Helper()
+	repo := storage.NewMemoryRepository("binance")
+	var klines []models.SymbolKlineData
+	for i, symbol := range []string{"BTCUSDT", "ETHUSDT", "ETHFIUSDT"} {
+		for minute := int64(0); minute < 2; minute++ {
+			price := decimal.New(int64(i+1)*10_000+minute, -2)
+			klines = append(klines, models.SymbolKlineData{
+				Symbol:       symbol,
+				Interval:     "1m",
+				OpenTime:     1_700_000_040_000 + minute*60_000,
+				Open:         price,
+				High:         price.Add(decimal.New(5, -1)),
+				Low:          price.Sub(decimal.New(25, -2)),
+				Close:        price.Add(decimal.New(1, -8)),
+				Volume:       decimal.RequireFromString("0.000000000000000123"),
+				Turnover:     decimal.RequireFromString("12345678901234.5"),
+				VWAP:         price,
+				TypicalPrice: price,
+				TradeCount:   42,
+				Alignment:    "UTC",
+				SampleCount:  12,
+				Coverage:     0.75,
+				Instance:     "test",
+			})
+		}
+	}
+	if err := repo.SaveKlines(klines); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func export(t *testing.T, opts Options) ([]byte, int64) {
+	t.Helper()
+	var out bytes.Buffer
+	count, err := Run(testRepository(t), opts, &out)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	return out.Bytes(), count
+}
+
+// golden compares got with testdata/name, rewriting the file when -update is set.
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file:\n%s", name, got)
+	}
+}
+
+func TestRunGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   Options
+		golden string
+		rows   int64
+	}{
+		{name: "csv", opts: Options{Format: FormatCSV}, golden: "all.csv", rows: 6},
+		{name: "jsonl", opts: Options{Format: FormatJSONL}, golden: "all.jsonl", rows: 6},
+		{name: "columns", opts: Options{Format: FormatCSV, Columns: []string{"Symbol", " open_time", "close", "volume"}}, golden: "columns.csv", rows: 6},
+		{name: "symbol glob", opts: Options{Format: FormatJSONL, Filter: storage.KlineFilter{Symbols: []string{"ETH*USDT"}}, Columns: []string{"symbol", "open_time", "close"}}, golden: "glob.jsonl", rows: 4},
+		{name: "exact symbol", opts: Options{Format: FormatJSONL, Filter: storage.KlineFilter{Symbols: []string{"ETH-USDT-SWAP"}}, Columns: []string{"symbol", "open_time", "close"}}, golden: "exact.jsonl", rows: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, count := export(t, tt.opts)
+			if count != tt.rows {
+				t.Errorf("exported %d rows, want %d", count, tt.rows)
+			}
+			golden(t, tt.golden, got)
+		})
+	}
+}
+
+func TestRunCompression(t *testing.T) {
+	plain, _ := export(t, Options{Format: FormatJSONL})
+	tests := []struct {
+		compression string
+		decompress  func(io.Reader) (io.Reader, error)
+	}{
+		{"gzip", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"zstd", func(r io.Reader) (io.Reader, error) {
+			d, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.compression, func(t *testing.T) {
+			compressed, _ := export(t, Options{Format: FormatJSONL, Compression: tt.compression})
+			if bytes.Equal(compressed, plain) {
+				t.Fatal("output was not compressed")
+			}
+			r, err := tt.decompress(bytes.NewReader(compressed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Errorf("decompressed output differs:\n%s", got)
+			}
+		})
+	}
+
+	var out bytes.Buffer
+	if _, err := Run(testRepository(t), Options{Format: FormatCSV, Compression: "lz4"}, &out); err == nil {
+		t.Error("an unknown compression was accepted")
+	}
+}
+
+func TestRunParquetGolden(t *testing.T) {
+	for _, compression := range []string{"none", "snappy", "gzip", "zstd"} {
+		t.Run(compression, func(t *testing.T) {
+			data, count := export(t, Options{Format: FormatParquet, Compression: compression, Columns: []string{"symbol", "open_time", "close", "volume", "trade_count", "coverage"}})
+			if count != 6 {
+				t.Errorf("exported %d rows, want 6", count)
+			}
+
+			file, err := buffer.NewBufferFile(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pr, err := reader.NewParquetReader(file, nil, 1)
+			if err != nil {
+				t.Fatalf("open exported parquet: %v", err)
+			}
+			defer pr.ReadStop()
+			rows, err := pr.ReadByNumber(int(pr.GetNumRows()))
+			if err != nil {
+				t.Fatalf("read exported parquet: %v", err)
+			}
+			decoded, err := json.MarshalIndent(rows, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden(t, "parquet.json", append(decoded, '\n'))
+		})
+	}
+}
+
+func TestRunRejectsUnknownColumns(t *testing.T) {
+	var out bytes.Buffer
+	if _, err := Run(testRepository(t), Options{Columns: []string{"symbol", "bogus"}}, &out); err == nil {
+		t.Error("an unknown column was accepted")
+	}
+}
@@ -13,25 +13,17 @@ import (
 )
 
 var (
-	RedisClient *redis.Client
-	KafkaWriter *kafka.Writer
+	RedisClient   *redis.Client
+	KafkaWriter   *kafka.Writer
+	RawTickWriter *kafka.Writer
+
+	StreamSourceReader *kafka.Reader
 )
 
 // InitStreamingClients initializes Redis or Kafka clients based on the config.
 func InitStreamingClients(cfg config.StreamingConfig) {
 	if cfg.Provider == "redis" && cfg.Redis.Address != "" {
-		RedisClient = redis.NewClient(&redis.Options{
-			Addr:     cfg.Redis.Address,
-			Password: cfg.Redis.Password,
-			DB:       cfg.Redis.DB,
-		})
-
-		// Optional: test connection
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-		defer cancel()
-		if err := RedisClient.Ping(ctx).Err(); err != nil {
-			panic("❌ Failed to connect to Redis: " + err.Error())
-		}
+		initRedisClient(cfg)
 	}
 
 	if cfg.Provider == "kafka" && len(cfg.Kafka.Brokers) > 0 {
@@ -45,6 +37,58 @@ func InitStreamingClients(cfg config.StreamingConfig) {
 	}
 }
 
+// InitRawTickClients initializes the output used to persist raw ticks, reusing the
+// streaming Redis client when one is already connected.
+func InitRawTickClients(cfg config.StreamingConfig, raw config.RawTickSettings) {
+	switch raw.Output {
+	case "redis":
+		if RedisClient == nil {
+			initRedisClient(cfg)
+		}
+	case "kafka":
+		RawTickWriter = &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Kafka.Brokers...),
+			Topic:        raw.Target,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			Async:        false,
+		}
+	}
+}
+
+// InitStreamSourceClients initializes the input ticks are consumed from, reusing the
+// streaming Redis client when one is already connected.
+func InitStreamSourceClients(cfg config.StreamingConfig) {
+	source := cfg.Source
+	switch source.Input {
+	case "redis":
+		if RedisClient == nil {
+			initRedisClient(cfg)
+		}
+	case "kafka":
+		StreamSourceReader = kafka.NewReader(kafka.ReaderConfig{
+			Brokers: cfg.Kafka.Brokers,
+			Topic:   source.Target,
+			GroupID: source.GroupID,
+		})
+	}
+}
+
+func initRedisClient(cfg config.StreamingConfig) {
+	RedisClient = redis.NewClient(&redis.Options{
+		Addr:     cfg.Redis.Address,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	})
+
+	// Optional: test connection
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := RedisClient.Ping(ctx).Err(); err != nil {
+		panic("❌ Failed to connect to Redis: " + err.Error())
+	}
+}
+
 // ShutdownStreamingClients gracefully closes any initialized clients.
 func ShutdownStreamingClients() {
 	if RedisClient != nil {
@@ -53,6 +97,12 @@ func ShutdownStreamingClients() {
 	if KafkaWriter != nil {
 		_ = KafkaWriter.Close()
 	}
+	if RawTickWriter != nil {
+		_ = RawTickWriter.Close()
+	}
+	if StreamSourceReader != nil {
+		_ = StreamSourceReader.Close()
+	}
 }
 
 func ValidateStreamingConfig(cfg config.StreamingConfig, log *logrus.Logger) error {
@@ -76,3 +126,56 @@ func ValidateStreamingConfig(cfg config.StreamingConfig, log *logrus.Logger) err
 
 	return nil
 }
+
+func ValidateRawTickConfig(cfg config.StreamingConfig, raw config.RawTickSettings, log *logrus.Logger) error {
+	if !raw.Enabled {
+		log.Info("🔇 Raw tick persistence is disabled.")
+		return nil
+	}
+
+	if raw.Target == "" {
+		return fmt.Errorf("❌ Raw tick target (stream or topic) is not set")
+	}
+
+	switch raw.Output {
+	case "redis":
+		if cfg.Redis.Address == "" {
+			return fmt.Errorf("❌ Raw tick output is redis but Streaming.Redis.Address is not set")
+		}
+	case "kafka":
+		if len(cfg.Kafka.Brokers) == 0 {
+			return fmt.Errorf("❌ Raw tick output is kafka but Streaming.Kafka.Brokers is empty")
+		}
+	default:
+		return fmt.Errorf("❌ Unknown raw tick output: %s", raw.Output)
+	}
+
+	return nil
+}
+
+func ValidateStreamSourceConfig(cfg config.StreamingConfig, log *logrus.Logger) error {
+	source := cfg.Source
+	if !source.Enabled {
+		log.Info("🔇 Stream source is disabled.")
+		return nil
+	}
+
+	if source.Target == "" {
+		return fmt.Errorf("❌ Stream source target (stream or topic) is not set")
+	}
+
+	switch source.Input {
+	case "redis":
+		if cfg.Redis.Address == "" {
+			return fmt.Errorf("❌ Stream source input is redis but Streaming.Redis.Address is not set")
+		}
+	case "kafka":
+		if len(cfg.Kafka.Brokers) == 0 {
+			return fmt.Errorf("❌ Stream source input is kafka but Streaming.Kafka.Brokers is empty")
+		}
+	default:
+		return fmt.Errorf("❌ Unknown stream source input: %s", source.Input)
+	}
+
+	return nil
+}
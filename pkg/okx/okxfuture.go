@@ -5,7 +5,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/shopspring/decimal"
 )
 
 // OKXTickerInfo defines relevant fields from OKX futures ticker
@@ -15,8 +19,10 @@ type OKXTickerInfo struct {
 	Open24h      string `json:"open24h"`
 	High24h      string `json:"high24h"`
 	Low24h       string `json:"low24h"`
-	Vol24h       string `json:"vol24h"`
+	Vol24h       string `json:"vol24h"`    // in contracts
+	VolCcy24h    string `json:"volCcy24h"` // in the base currency
 	Change24hPct string `json:"change24h"` // calculated from open/last if not provided
+	Timestamp    string `json:"ts"`
 }
 
 // shared rate-limited client instance
@@ -24,6 +30,11 @@ var sharedClient = NewRateLimitedClient(&http.Client{
 	Timeout: 10 * time.Second,
 })
 
+// RateLimitBudget reports the remaining request budget of the shared client.
+func RateLimitBudget() (remaining, limit int, ok bool) {
+	return sharedClient.Budget()
+}
+
 // GetAllTickers fetches all perpetual (swap) futures tickers from OKX
 func GetAllTickers() ([]*OKXTickerInfo, error) {
 	url := "https://www.okx.com/api/v5/market/tickers?instType=SWAP"
@@ -87,3 +98,148 @@ func GetTickerInfo(instID string) (*OKXTickerInfo, error) {
 	}
 	return parsed.Data[0], nil
 }
+
+// Kline is one candle as reported by the exchange.
+type Kline struct {
+	OpenTime    int64
+	Open        string
+	High        string
+	Low         string
+	Close       string
+	Volume      string
+	QuoteVolume string
+}
+
+// KlineInterval maps a collector interval label to OKX's UTC-aligned bar, reporting false when unsupported.
+func KlineInterval(label string) (string, bool) {
+	switch label {
+	case "1m", "3m", "5m", "15m", "30m":
+		return label, true
+	case "1h", "2h", "4h":
+		return strings.ToUpper(label), true
+	case "6h", "12h":
+		return strings.ToUpper(label) + "utc", true
+	case "1d", "2d", "3d":
+		return strings.ToUpper(label) + "utc", true
+	case "1w":
+		return "1Wutc", true
+	case "1M":
+		return "1Mutc", true
+	}
+	return "", false
+}
+
+// GetKlines fetches the confirmed candles of an instrument opening within [startTime, endTime] (unix ms).
+func GetKlines(instID, bar string, startTime, endTime int64, limit int) ([]*Kline, error) {
+	if limit > 100 {
+		limit = 100
+	}
+	// after/before are exclusive bounds on the candle open time
+	url := fmt.Sprintf("https://www.okx.com/api/v5/market/history-candles?instId=%s&bar=%s&after=%d&before=%d&limit=%d",
+		instID, bar, endTime+1, startTime-1, limit)
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := sharedClient.SendWithRetry(req, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non-200 response: %d\n%s", resp.StatusCode, string(body))
+	}
+
+	// [ts, open, high, low, close, vol, volCcy, volCcyQuote, confirm]
+	var parsed struct {
+		Code string     `json:"code"`
+		Msg  string     `json:"msg"`
+		Data [][]string `json:"data"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, err
+	}
+	if parsed.Code != "0" {
+		return nil, fmt.Errorf("okx API error: %s", parsed.Msg)
+	}
+
+	klines := make([]*Kline, 0, len(parsed.Data))
+	for _, row := range parsed.Data {
+		if len(row) < 9 || row[8] != "1" {
+			continue
+		}
+		openTime, err := strconv.ParseInt(row[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		klines = append(klines, &Kline{
+			OpenTime:    openTime,
+			Open:        row[1],
+			High:        row[2],
+			Low:         row[3],
+			Close:       row[4],
+			Volume:      row[6], // vol is in contracts, volCcy in the base currency
+			QuoteVolume: row[7],
+		})
+	}
+	return klines, nil
+}
+
+// Instrument holds the price and quantity increments of a contract.
+type Instrument struct {
+	InstrumentID string `json:"instId"`
+	TickSize     string `json:"tickSz"`
+	LotSize      string `json:"lotSz"` // in contracts
+	CtVal        string `json:"ctVal"` // base currency per contract
+}
+
+// BaseLotSize is the lot size in the base currency, or the lot size in contracts when the
+// contract value is not reported.
+func (i *Instrument) BaseLotSize() string {
+	lot, err := decimal.NewFromString(i.LotSize)
+	if err != nil {
+		return i.LotSize
+	}
+	ctVal, err := decimal.NewFromString(i.CtVal)
+	if err != nil || !ctVal.IsPositive() {
+		return i.LotSize
+	}
+	return lot.Mul(ctVal).String()
+}
+
+// GetInstruments fetches the tick and lot sizes of every perpetual swap.
+func GetInstruments() ([]*Instrument, error) {
+	url := "https://www.okx.com/api/v5/public/instruments?instType=SWAP"
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := sharedClient.SendWithRetry(req, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non-200 response: %d\n%s", resp.StatusCode, string(body))
+	}
+
+	var parsed struct {
+		Code string        `json:"code"`
+		Msg  string        `json:"msg"`
+		Data []*Instrument `json:"data"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, err
+	}
+	if parsed.Code != "0" {
+		return nil, fmt.Errorf("API error: %s", parsed.Msg)
+	}
+	return parsed.Data, nil
+}
@@ -0,0 +1,174 @@
+package parquetstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xitongsys/parquet-go/parquet"
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/models"
+)
+
+// CompactStats summarizes a compaction run.
+type CompactStats struct {
+	Partitions  int
+	FilesMerged int
+	RowsRead    int
+	RowsWritten int
+}
+
+// Compact merges the small files of every partition under the configured directory into one
+// file, keeping the most amended version of each candle and upgrading rows to the current
+// schema. The merged file is committed before the inputs are removed, so a crash in between
+// only leaves duplicates that readers already resolve.
+func Compact(cfg config.ParquetSettings, log *logrus.Logger) (CompactStats, error) {
+	var stats CompactStats
+
+	codec, err := compressionCodec(strings.ToLower(cfg.Compression))
+	if err != nil {
+		return stats, err
+	}
+	root := cfg.Directory
+	if root == "" {
+		root = "data/parquet"
+	}
+	belowMB := cfg.CompactBelowMB
+	if belowMB <= 0 {
+		belowMB = 32
+	}
+	threshold := int64(belowMB) * 1024 * 1024
+
+	small := make(map[string][]string) // partition directory -> files to merge
+	err = filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") || filepath.Ext(d.Name()) != ".parquet" {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() < threshold {
+			small[filepath.Dir(path)] = append(small[filepath.Dir(path)], path)
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("scan %s: %w", root, err)
+	}
+
+	for dir, files := range small {
+		if len(files) < 2 {
+			continue
+		}
+		read, written, err := compactPartition(dir, files, codec)
+		if err != nil {
+			return stats, err
+		}
+		stats.Partitions++
+		stats.FilesMerged += len(files)
+		stats.RowsRead += read
+		stats.RowsWritten += written
+
+		log.WithFields(logrus.Fields{
+			"partition": dir,
+			"files":     len(files),
+			"rows":      written,
+		}).Info("🗜️ Compacted parquet partition")
+	}
+	return stats, nil
+}
+
+func compactPartition(dir string, files []string, codec parquet.CompressionCodec) (read, written int, err error) {
+	type key struct {
+		symbol   string
+		source   string
+		openTime int64
+	}
+	latest := make(map[key]klineRow)
+	exchange := ""
+	for _, path := range files {
+		rows, err := readRows(path)
+		if err != nil {
+			return 0, 0, err
+		}
+		read += len(rows)
+		for _, row := range rows {
+			if row.Source == "" {
+				row.Source = models.SourceAggregated
+			}
+			k := key{row.Symbol, row.Source, row.OpenTime}
+			if current, ok := latest[k]; !ok || row.Amendments > current.Amendments {
+				latest[k] = row
+			}
+		}
+	}
+	for _, part := range strings.Split(filepath.ToSlash(dir), "/") {
+		if strings.HasPrefix(part, "exchange=") {
+			exchange = strings.TrimPrefix(part, "exchange=")
+		}
+	}
+
+	rows := make([]klineRow, 0, len(latest))
+	for _, row := range latest {
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Symbol != rows[j].Symbol {
+			return rows[i].Symbol < rows[j].Symbol
+		}
+		if rows[i].OpenTime != rows[j].OpenTime {
+			return rows[i].OpenTime < rows[j].OpenTime
+		}
+		return rows[i].Source < rows[j].Source
+	})
+
+	name := fmt.Sprintf("part-%d-compacted.parquet", time.Now().UnixNano())
+	tmpPath := filepath.Join(dir, "."+name+".tmp")
+	if err := writeFile(tmpPath, rows, codec, exchange); err != nil {
+		os.Remove(tmpPath)
+		return 0, 0, err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(dir, name)); err != nil {
+		return 0, 0, fmt.Errorf("commit compacted file: %w", err)
+	}
+
+	for _, path := range files {
+		if err := os.Remove(path); err != nil {
+			return 0, 0, fmt.Errorf("remove compacted input: %w", err)
+		}
+	}
+	return read, len(rows), nil
+}
+
+func writeFile(path string, rows []klineRow, codec parquet.CompressionCodec, exchange string) error {
+	file, err := createLocalFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	pw, err := newRowWriter(file, codec)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := pw.Write(row); err != nil {
+			return fmt.Errorf("write compacted row: %w", err)
+		}
+	}
+	if err := finishRowWriter(pw, exchange); err != nil {
+		return fmt.Errorf("finish compacted file: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return file.Close()
+}
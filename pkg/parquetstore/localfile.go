@@ -0,0 +1,40 @@
+package parquetstore
+
+import (
+	"os"
+
+	"github.com/xitongsys/parquet-go/source"
+)
+
+// localFile adapts an os.File to the parquet library's file interface.
+type localFile struct {
+	*os.File
+}
+
+func createLocalFile(path string) (*localFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &localFile{f}, nil
+}
+
+func openLocalFile(path string) (*localFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &localFile{f}, nil
+}
+
+// Open reopens the file, or another one, for the reader's parallel column readers.
+func (f *localFile) Open(name string) (source.ParquetFile, error) {
+	if name == "" {
+		name = f.Name()
+	}
+	return openLocalFile(name)
+}
+
+func (f *localFile) Create(name string) (source.ParquetFile, error) {
+	return createLocalFile(name)
+}
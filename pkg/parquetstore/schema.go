@@ -0,0 +1,187 @@
+package parquetstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/shopspring/decimal"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/writer"
+	"scanner.magictradebot.com/models"
+	"scanner.magictradebot.com/pkg/storage"
+)
+
+// SchemaVersion is recorded in every file's metadata. Bump it when a column is added or retyped.
+//
+// Columns are never renamed or removed. New ones are appended and must be OPTIONAL pointer
+// fields: files written before the column existed read back as nil. A column may change its
+// type in place only when readRows keeps decoding every earlier type of it. Compaction
+// rewrites old files with the current schema.
+//
+// Version 2 retyped prices and volumes from doubles to exact decimal strings; decodedRow
+// reads both.
+const SchemaVersion = 2
+
+// klineRow is one candle as stored in Parquet. json tags mirror the column names so files
+// written with older schemas can be mapped onto it by name.
+type klineRow struct {
+	Symbol        string  `parquet:"name=symbol, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY" json:"symbol"`
+	Interval      string  `parquet:"name=interval, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY" json:"interval"`
+	Source        string  `parquet:"name=source, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY" json:"source"`
+	OpenTime      int64   `parquet:"name=open_time, type=INT64, convertedtype=TIMESTAMP_MILLIS" json:"open_time"`
+	Open          string  `parquet:"name=open, type=BYTE_ARRAY, convertedtype=UTF8" json:"open"`
+	High          string  `parquet:"name=high, type=BYTE_ARRAY, convertedtype=UTF8" json:"high"`
+	Low           string  `parquet:"name=low, type=BYTE_ARRAY, convertedtype=UTF8" json:"low"`
+	Close         string  `parquet:"name=close, type=BYTE_ARRAY, convertedtype=UTF8" json:"close"`
+	Volume        string  `parquet:"name=volume, type=BYTE_ARRAY, convertedtype=UTF8" json:"volume"`
+	Turnover      string  `parquet:"name=turnover, type=BYTE_ARRAY, convertedtype=UTF8" json:"turnover"`
+	VWAP          string  `parquet:"name=vwap, type=BYTE_ARRAY, convertedtype=UTF8" json:"vwap"`
+	TypicalPrice  string  `parquet:"name=typical_price, type=BYTE_ARRAY, convertedtype=UTF8" json:"typical_price"`
+	TradeCount    int64   `parquet:"name=trade_count, type=INT64" json:"trade_count"`
+	Amendments    int32   `parquet:"name=amendments, type=INT32" json:"amendments"`
+	FillPolicy    string  `parquet:"name=fill_policy, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY" json:"fill_policy"`
+	Synthetic     bool    `parquet:"name=synthetic, type=BOOLEAN" json:"synthetic"`
+	Alignment     string  `parquet:"name=alignment, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY" json:"alignment"`
+	SampleCount   int64   `parquet:"name=sample_count, type=INT64" json:"sample_count"`
+	FirstSampleAt int64   `parquet:"name=first_sample_at, type=INT64" json:"first_sample_at"`
+	LastSampleAt  int64   `parquet:"name=last_sample_at, type=INT64" json:"last_sample_at"`
+	Coverage      float64 `parquet:"name=coverage, type=DOUBLE" json:"coverage"`
+	MaxGapMs      int64   `parquet:"name=max_gap_ms, type=INT64" json:"max_gap_ms"`
+	Partial       bool    `parquet:"name=partial, type=BOOLEAN" json:"partial"`
+	Instance      string  `parquet:"name=instance, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY" json:"instance"`
+}
+
+// toRow converts a candle, normalizing its symbol the way the database stores it.
+func toRow(k models.SymbolKlineData, instance string) klineRow {
+	source := k.Source
+	if source == "" {
+		source = models.SourceAggregated
+	}
+	alignment := k.Alignment
+	if alignment == "" {
+		alignment = "UTC"
+	}
+	return klineRow{
+		Symbol:        storage.StoredSymbol(k.Symbol),
+		Interval:      k.Interval,
+		Source:        source,
+		OpenTime:      k.OpenTime,
+		Open:          k.Open.String(),
+		High:          k.High.String(),
+		Low:           k.Low.String(),
+		Close:         k.Close.String(),
+		Volume:        k.Volume.String(),
+		Turnover:      k.Turnover.String(),
+		VWAP:          k.VWAP.String(),
+		TypicalPrice:  k.TypicalPrice.String(),
+		TradeCount:    k.TradeCount,
+		Amendments:    int32(k.Amendments),
+		FillPolicy:    k.FillPolicy,
+		Synthetic:     k.Synthetic,
+		Alignment:     alignment,
+		SampleCount:   k.SampleCount,
+		FirstSampleAt: k.FirstSampleAt,
+		LastSampleAt:  k.LastSampleAt,
+		Coverage:      k.Coverage,
+		MaxGapMs:      k.MaxGapMs,
+		Partial:       k.Partial,
+		Instance:      instance,
+	}
+}
+
+// compressionCodec maps a configured compression name to the Parquet codec.
+func compressionCodec(name string) (parquet.CompressionCodec, error) {
+	switch name {
+	case "", "snappy":
+		return parquet.CompressionCodec_SNAPPY, nil
+	case "gzip":
+		return parquet.CompressionCodec_GZIP, nil
+	case "zstd":
+		return parquet.CompressionCodec_ZSTD, nil
+	case "none":
+		return parquet.CompressionCodec_UNCOMPRESSED, nil
+	}
+	return 0, fmt.Errorf("unsupported parquet compression %q", name)
+}
+
+// newRowWriter starts a Parquet file with the current schema.
+func newRowWriter(f *localFile, codec parquet.CompressionCodec) (*writer.ParquetWriter, error) {
+	pw, err := writer.NewParquetWriter(f, new(klineRow), 4)
+	if err != nil {
+		return nil, err
+	}
+	pw.CompressionType = codec
+	pw.RowGroupSize = 16 * 1024 * 1024
+	return pw, nil
+}
+
+// finishRowWriter writes the footer with the schema version.
+func finishRowWriter(pw *writer.ParquetWriter, exchange string) error {
+	if err := pw.Flush(true); err != nil {
+		return err
+	}
+	version := strconv.Itoa(SchemaVersion)
+	pw.Footer.KeyValueMetadata = []*parquet.KeyValue{
+		{Key: "schema_version", Value: &version},
+		{Key: "exchange", Value: &exchange},
+	}
+	return pw.WriteStop()
+}
+
+// readRows loads every row of a file using the schema stored in it, so files written with
+// an older schema map onto klineRow by column name.
+func readRows(path string) ([]klineRow, error) {
+	f, err := openLocalFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pr, err := reader.NewParquetReader(f, nil, 4)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer pr.ReadStop()
+
+	raw, err := pr.ReadByNumber(int(pr.GetNumRows()))
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var decoded []decodedRow
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	rows := make([]klineRow, len(decoded))
+	for i, d := range decoded {
+		rows[i] = d.klineRow
+		rows[i].Open = d.Open.String()
+		rows[i].High = d.High.String()
+		rows[i].Low = d.Low.String()
+		rows[i].Close = d.Close.String()
+		rows[i].Volume = d.Volume.String()
+		rows[i].Turnover = d.Turnover.String()
+		rows[i].VWAP = d.VWAP.String()
+		rows[i].TypicalPrice = d.TypicalPrice.String()
+	}
+	return rows, nil
+}
+
+// decodedRow reads the price columns of both schema versions: decimals accept the doubles of
+// version 1 and the strings of version 2. The outer fields shadow the embedded ones.
+type decodedRow struct {
+	klineRow
+	Open         decimal.Decimal `json:"open"`
+	High         decimal.Decimal `json:"high"`
+	Low          decimal.Decimal `json:"low"`
+	Close        decimal.Decimal `json:"close"`
+	Volume       decimal.Decimal `json:"volume"`
+	Turnover     decimal.Decimal `json:"turnover"`
+	VWAP         decimal.Decimal `json:"vwap"`
+	TypicalPrice decimal.Decimal `json:"typical_price"`
+}
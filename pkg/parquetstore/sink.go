@@ -0,0 +1,229 @@
+package parquetstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/models"
+	"scanner.magictradebot.com/pkg/storage"
+)
+
+// Sink writes finalized candles into Hive-style partitions
+// (exchange=<name>/interval=<label>/date=<yyyy-mm-dd>). Each partition has one open file that
+// is written under a hidden temporary name and renamed into place once complete, so readers
+// never see a half-written file. Amended candles are appended; readers and compaction keep
+// the row with the most amendments.
+type Sink struct {
+	lock     sync.Mutex
+	root     string
+	exchange string
+	instance string
+	codec    parquet.CompressionCodec
+	maxBytes int64
+	rollAge  time.Duration
+	files    map[string]*openFile // partition directory -> open file
+	seq      int64
+	log      *logrus.Logger
+}
+
+var _ storage.KlineSink = (*Sink)(nil)
+
+type openFile struct {
+	file     *localFile
+	writer   *writer.ParquetWriter
+	tmpPath  string
+	path     string
+	rows     int64
+	openedAt time.Time
+}
+
+// NewSink prepares the directory tree and removes temporary files left by a crash.
+func NewSink(cfg config.ParquetSettings, exchange, instance string, log *logrus.Logger) (*Sink, error) {
+	codec, err := compressionCodec(strings.ToLower(cfg.Compression))
+	if err != nil {
+		return nil, err
+	}
+
+	root := cfg.Directory
+	if root == "" {
+		root = "data/parquet"
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("create parquet directory: %w", err)
+	}
+
+	maxMB := cfg.MaxFileMB
+	if maxMB <= 0 {
+		maxMB = 128
+	}
+	rollSeconds := cfg.RollSeconds
+	if rollSeconds <= 0 {
+		rollSeconds = 300
+	}
+
+	if err := removeTemporary(root, log); err != nil {
+		return nil, err
+	}
+
+	return &Sink{
+		root:     root,
+		exchange: strings.ToLower(exchange),
+		instance: instance,
+		codec:    codec,
+		maxBytes: int64(maxMB) * 1024 * 1024,
+		rollAge:  time.Duration(rollSeconds) * time.Second,
+		files:    make(map[string]*openFile),
+		log:      log,
+	}, nil
+}
+
+func (s *Sink) SaveKlines(data []models.SymbolKlineData) error {
+	return s.write(data)
+}
+
+// UpsertKlines appends amended candles; the higher amendment count wins when read back.
+func (s *Sink) UpsertKlines(data []models.SymbolKlineData) error {
+	return s.write(data)
+}
+
+func (s *Sink) write(data []models.SymbolKlineData) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	touched := make(map[string]*openFile)
+	for _, k := range data {
+		dir := s.partition(k)
+		f, err := s.fileFor(dir)
+		if err != nil {
+			return err
+		}
+		if err := f.writer.Write(toRow(k, s.instance)); err != nil {
+			return fmt.Errorf("write parquet row: %w", err)
+		}
+		f.rows++
+		touched[dir] = f
+	}
+
+	for dir, f := range touched {
+		if f.writer.Offset+f.writer.ObjsSize >= s.maxBytes {
+			if err := s.commit(dir); err != nil {
+				return err
+			}
+		}
+	}
+	return s.commitExpired(time.Now())
+}
+
+// partition returns the directory a candle belongs to.
+func (s *Sink) partition(k models.SymbolKlineData) string {
+	date := time.UnixMilli(k.OpenTime).UTC().Format("2006-01-02")
+	return filepath.Join(s.root, "exchange="+s.exchange, "interval="+k.Interval, "date="+date)
+}
+
+func (s *Sink) fileFor(dir string) (*openFile, error) {
+	if f, ok := s.files[dir]; ok {
+		return f, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create partition: %w", err)
+	}
+
+	s.seq++
+	name := fmt.Sprintf("part-%d-%04d.parquet", time.Now().UnixNano(), s.seq)
+	tmpPath := filepath.Join(dir, "."+name+".tmp")
+
+	file, err := createLocalFile(tmpPath)
+	if err != nil {
+		return nil, fmt.Errorf("create parquet file: %w", err)
+	}
+	pw, err := newRowWriter(file, s.codec)
+	if err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("start parquet file: %w", err)
+	}
+
+	f := &openFile{
+		file:     file,
+		writer:   pw,
+		tmpPath:  tmpPath,
+		path:     filepath.Join(dir, name),
+		openedAt: time.Now(),
+	}
+	s.files[dir] = f
+	return f, nil
+}
+
+// commit finishes a partition's open file and moves it into place.
+func (s *Sink) commit(dir string) error {
+	f := s.files[dir]
+	delete(s.files, dir)
+
+	if err := finishRowWriter(f.writer, s.exchange); err != nil {
+		f.file.Close()
+		return fmt.Errorf("finish %s: %w", f.path, err)
+	}
+	if err := f.file.Sync(); err != nil {
+		f.file.Close()
+		return fmt.Errorf("sync %s: %w", f.path, err)
+	}
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", f.path, err)
+	}
+	if err := os.Rename(f.tmpPath, f.path); err != nil {
+		return fmt.Errorf("commit %s: %w", f.path, err)
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"file": f.path,
+		"rows": f.rows,
+	}).Debug("📦 Parquet file committed")
+	return nil
+}
+
+// commitExpired commits files open longer than the roll age so readers see recent candles.
+func (s *Sink) commitExpired(now time.Time) error {
+	var errs []error
+	for dir, f := range s.files {
+		if now.Sub(f.openedAt) >= s.rollAge {
+			errs = append(errs, s.commit(dir))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close commits every open file.
+func (s *Sink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var errs []error
+	for dir := range s.files {
+		errs = append(errs, s.commit(dir))
+	}
+	return errors.Join(errs...)
+}
+
+// removeTemporary deletes files that were being written when the process stopped. Their
+// rows never became visible and have no footer to recover them from.
+func removeTemporary(root string, log *logrus.Logger) error {
+	return filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".tmp") {
+			return nil
+		}
+		log.WithField("file", path).Warn("⚠️ Removing incomplete parquet file")
+		return os.Remove(path)
+	})
+}
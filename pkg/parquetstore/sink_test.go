@@ -0,0 +1,237 @@
+package parquetstore
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/models"
+)
+
+func testLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+func newTestSink(t *testing.T) (*Sink, string) {
+	t.Helper()
+	dir := t.TempDir()
+	sink, err := NewSink(config.ParquetSettings{Directory: dir, Compression: "none"}, "Binance", "test", testLogger())
+	if err != nil {
+		t.Fatalf("NewSink: %v", err)
+	}
+	return sink, dir
+}
+
+func kline(symbol string, openTime int64, close string, amendments int) models.SymbolKlineData {
+	price := decimal.RequireFromString(close)
+	return models.SymbolKlineData{
+		Symbol:     symbol,
+		Interval:   "1m",
+		OpenTime:   openTime,
+		Open:       price,
+		High:       price,
+		Low:        price,
+		Close:      price,
+		Volume:     decimal.RequireFromString("0.000000000123"),
+		Amendments: amendments,
+	}
+}
+
+// listFiles returns the committed files under root and the temporary ones still being written.
+func listFiles(t *testing.T, root string) (committed, temporary []string) {
+	t.Helper()
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		switch {
+		case err != nil || d.IsDir():
+			return err
+		case strings.HasSuffix(path, ".tmp"):
+			temporary = append(temporary, path)
+		default:
+			committed = append(committed, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(committed)
+	return committed, temporary
+}
+
+func TestSinkCommitsOnClose(t *testing.T) {
+	sink, dir := newTestSink(t)
+	day := int64(24 * 60 * 60_000)
+	if err := sink.SaveKlines([]models.SymbolKlineData{kline("BTC-USDT-SWAP", 0, "100.5", 0), kline("ETHUSDT", day, "2000", 0)}); err != nil {
+		t.Fatalf("SaveKlines: %v", err)
+	}
+	if files, temporary := listFiles(t, dir); len(files) != 0 || len(temporary) != 2 {
+		t.Fatalf("before Close: committed %v, temporary %v", files, temporary)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	files, temporary := listFiles(t, dir)
+	if len(files) != 2 || len(temporary) != 0 {
+		t.Fatalf("after Close: committed %v, temporary %v, want one file per date partition", files, temporary)
+	}
+	if !strings.Contains(files[0], filepath.Join("exchange=binance", "interval=1m", "date=1970-01-01")) {
+		t.Errorf("first file is in %s", files[0])
+	}
+	rows, err := readRows(files[0])
+	if err != nil {
+		t.Fatalf("readRows: %v", err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("read %d rows, want 1", len(rows))
+	}
+	if r := rows[0]; r.Symbol != "BTC" || r.Close != "100.5" || r.Volume != "0.000000000123" || r.Instance != "test" || r.Source != models.SourceAggregated {
+		t.Errorf("row = %+v", r)
+	}
+}
+
+func TestSinkRollsFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		apply func(*Sink)
+	}{
+		{name: "by size", apply: func(s *Sink) { s.maxBytes = 1 }},
+		{name: "by age", apply: func(s *Sink) { s.rollAge = 0 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink, dir := newTestSink(t)
+			tt.apply(sink)
+			for i := int64(0); i < 3; i++ {
+				if err := sink.SaveKlines([]models.SymbolKlineData{kline("BTCUSDT", i*60_000, "100", 0)}); err != nil {
+					t.Fatalf("SaveKlines: %v", err)
+				}
+			}
+			if files, temporary := listFiles(t, dir); len(files) != 3 || len(temporary) != 0 {
+				t.Errorf("committed %d files with %d temporary, want one per write", len(files), len(temporary))
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+		})
+	}
+}
+
+// klineRowV1 is the schema version 1 layout, with prices and volumes as doubles.
+type klineRowV1 struct {
+	Symbol     string  `parquet:"name=symbol, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Interval   string  `parquet:"name=interval, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Source     string  `parquet:"name=source, type=BYTE_ARRAY, convertedtype=UTF8"`
+	OpenTime   int64   `parquet:"name=open_time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Open       float64 `parquet:"name=open, type=DOUBLE"`
+	High       float64 `parquet:"name=high, type=DOUBLE"`
+	Low        float64 `parquet:"name=low, type=DOUBLE"`
+	Close      float64 `parquet:"name=close, type=DOUBLE"`
+	Volume     float64 `parquet:"name=volume, type=DOUBLE"`
+	Amendments int32   `parquet:"name=amendments, type=INT32"`
+}
+
+func writeV1(t *testing.T, path string, rows ...klineRowV1) {
+	t.Helper()
+	file, err := createLocalFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	pw, err := writer.NewParquetWriter(file, new(klineRowV1), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := pw.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadRowsOfBothSchemaVersions(t *testing.T) {
+	dir := t.TempDir()
+	v1 := filepath.Join(dir, "v1.parquet")
+	writeV1(t, v1, klineRowV1{Symbol: "BTC", Interval: "1m", Source: "aggregated", OpenTime: 60_000, Open: 100.25, High: 101, Low: 99.5, Close: 100.75, Volume: 12.5})
+
+	v2 := filepath.Join(dir, "v2.parquet")
+	row := toRow(kline("BTCUSDT", 60_000, "100.123456789012345678", 1), "test")
+	if err := writeFile(v2, []klineRow{row}, parquet.CompressionCodec_UNCOMPRESSED, "binance"); err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := readRows(v1)
+	if err != nil {
+		t.Fatalf("read version 1: %v", err)
+	}
+	if len(old) != 1 {
+		t.Fatalf("read %d version 1 rows", len(old))
+	}
+	if r := old[0]; r.Symbol != "BTC" || r.Open != "100.25" || r.Low != "99.5" || r.Close != "100.75" || r.Volume != "12.5" || r.Turnover != "0" || r.Instance != "" {
+		t.Errorf("version 1 row = %+v", r)
+	}
+
+	current, err := readRows(v2)
+	if err != nil {
+		t.Fatalf("read version 2: %v", err)
+	}
+	if len(current) != 1 || current[0] != row {
+		t.Errorf("version 2 rows = %+v, want %+v", current, row)
+	}
+}
+
+func TestCompactKeepsTheMostAmendedRow(t *testing.T) {
+	sink, dir := newTestSink(t)
+	if err := sink.SaveKlines([]models.SymbolKlineData{kline("BTCUSDT", 0, "100", 0), kline("ETHUSDT", 0, "2000", 0)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.UpsertKlines([]models.SymbolKlineData{kline("BTCUSDT", 0, "101", 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	written, _ := listFiles(t, dir)
+	partition := filepath.Dir(written[0])
+	writeV1(t, filepath.Join(partition, "part-0-v1.parquet"), klineRowV1{Symbol: "XRP", Interval: "1m", Source: "aggregated", OpenTime: 60_000, Close: 0.5})
+
+	stats, err := Compact(config.ParquetSettings{Directory: dir, Compression: "none"}, testLogger())
+	if err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if stats.Partitions != 1 || stats.FilesMerged != 3 || stats.RowsRead != 4 || stats.RowsWritten != 3 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	files, temporary := listFiles(t, dir)
+	if len(files) != 1 || len(temporary) != 0 {
+		t.Fatalf("after compaction: committed %v, temporary %v", files, temporary)
+	}
+	rows, err := readRows(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	closes := make(map[string]string)
+	for _, r := range rows {
+		closes[r.Symbol] = r.Close
+	}
+	if closes["BTC"] != "101" || closes["ETH"] != "2000" || closes["XRP"] != "0.5" {
+		t.Errorf("closes after compaction = %v", closes)
+	}
+}
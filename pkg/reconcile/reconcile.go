@@ -0,0 +1,273 @@
+package reconcile
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"scanner.magictradebot.com/models"
+	"scanner.magictradebot.com/pkg/aggregator"
+	"scanner.magictradebot.com/pkg/exchanges"
+	"scanner.magictradebot.com/pkg/storage"
+)
+
+// pageSize is the number of candles requested from the exchange at a time.
+const pageSize = 100
+
+// Options selects what is reconciled.
+type Options struct {
+	Exchange     string
+	Symbols      []string
+	Sample       int // symbols picked at random from Symbols, 0 for all
+	Interval     string
+	From         int64 // unix ms, inclusive
+	To           int64 // unix ms, inclusive
+	ToleranceBps float64
+}
+
+// SymbolStats summarizes how far the collected candles of one symbol drift from the official ones.
+type SymbolStats struct {
+	Symbol            string  `json:"symbol"`
+	Interval          string  `json:"interval"`
+	Official          int     `json:"official"`             // candles published by the exchange
+	Collected         int     `json:"collected"`            // aggregated candles stored in the range
+	Matched           int     `json:"matched"`              // candles present in both
+	Missing           int     `json:"missing"`              // official candles with no collected counterpart
+	Extra             int     `json:"extra"`                // collected candles with no official counterpart
+	OpenMeanBps       float64 `json:"open_mean_bps"`        // mean absolute open deviation
+	OpenMaxBps        float64 `json:"open_max_bps"`         // largest absolute open deviation
+	CloseMeanBps      float64 `json:"close_mean_bps"`       // mean absolute close deviation
+	CloseMaxBps       float64 `json:"close_max_bps"`        // largest absolute close deviation
+	MissedHighs       int     `json:"missed_highs"`         // collected high below the official high
+	MissedHighMeanBps float64 `json:"missed_high_mean_bps"` // mean shortfall of the missed highs
+	MissedLows        int     `json:"missed_lows"`          // collected low above the official low
+	MissedLowMeanBps  float64 `json:"missed_low_mean_bps"`  // mean excess of the missed lows
+	VolumeRatio       float64 `json:"volume_ratio"`         // collected over official volume of matched candles
+	Error             string  `json:"error,omitempty"`
+}
+
+// Run fetches the official candles for a sample of symbols and compares them with the aggregated
+// candles in repo. A symbol that fails is reported with its error rather than aborting the run.
+func Run(opts Options, repo storage.KlineRepository, log *logrus.Logger) ([]SymbolStats, error) {
+	intervalMs, err := aggregator.ParseInterval(opts.Interval)
+	if err != nil {
+		return nil, err
+	}
+	if opts.To < opts.From {
+		return nil, fmt.Errorf("range ends before it starts")
+	}
+
+	symbols := sample(opts.Symbols, opts.Sample)
+	results := make([]SymbolStats, 0, len(symbols))
+	for _, symbol := range symbols {
+		stats, err := reconcileSymbol(opts, repo, symbol, intervalMs)
+		if err != nil {
+			log.WithField("symbol", symbol).Warnf("⚠️ Reconciliation failed: %v", err)
+			stats = SymbolStats{Symbol: symbol, Interval: opts.Interval, Error: err.Error()}
+		}
+		results = append(results, stats)
+	}
+	return results, nil
+}
+
+func reconcileSymbol(opts Options, repo storage.KlineRepository, symbol string, intervalMs int64) (SymbolStats, error) {
+	official, err := fetchOfficial(opts, symbol, intervalMs)
+	if err != nil {
+		return SymbolStats{}, err
+	}
+	collected, err := repo.LoadKlines(symbol, opts.Interval, models.SourceAggregated, opts.From, opts.To)
+	if err != nil {
+		return SymbolStats{}, err
+	}
+	return Compare(symbol, opts.Interval, collected, official, opts.ToleranceBps)
+}
+
+// fetchOfficial pages through the exchange's candles for the whole range.
+func fetchOfficial(opts Options, symbol string, intervalMs int64) ([]models.SymbolKlineData, error) {
+	var official []models.SymbolKlineData
+	for from := opts.From; from <= opts.To; from += pageSize * intervalMs {
+		to := from + (pageSize-1)*intervalMs
+		if to > opts.To {
+			to = opts.To
+		}
+		data, err := exchanges.CoreFuturesKlines(opts.Exchange, symbol, opts.Interval, from, to, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range data {
+			kline, err := k.ToKlineData()
+			if err != nil {
+				return nil, err
+			}
+			official = append(official, kline)
+		}
+	}
+	return official, nil
+}
+
+// Compare matches candles by open time. Deviations are in basis points of the official price;
+// highs and lows only count as missed beyond toleranceBps. Two collected candles opening at the
+// same time, e.g. of different exchanges, are an error.
+func Compare(symbol, interval string, collected, official []models.SymbolKlineData, toleranceBps float64) (SymbolStats, error) {
+	stats := SymbolStats{
+		Symbol:    symbol,
+		Interval:  interval,
+		Official:  len(official),
+		Collected: len(collected),
+	}
+
+	byOpen := make(map[int64]models.SymbolKlineData, len(collected))
+	for _, k := range collected {
+		if other, ok := byOpen[k.OpenTime]; ok {
+			return SymbolStats{}, fmt.Errorf("two collected candles open at %d, of %q and %q", k.OpenTime, other.Exchange, k.Exchange)
+		}
+		byOpen[k.OpenTime] = k
+	}
+
+	var openSum, closeSum, highSum, lowSum float64
+	var collectedVolume, officialVolume decimal.Decimal
+	for _, o := range official {
+		c, ok := byOpen[o.OpenTime]
+		if !ok {
+			stats.Missing++
+			continue
+		}
+		stats.Matched++
+
+		openDev := math.Abs(bps(c.Open, o.Open))
+		openSum += openDev
+		stats.OpenMaxBps = math.Max(stats.OpenMaxBps, openDev)
+
+		closeDev := math.Abs(bps(c.Close, o.Close))
+		closeSum += closeDev
+		stats.CloseMaxBps = math.Max(stats.CloseMaxBps, closeDev)
+
+		if shortfall := -bps(c.High, o.High); shortfall > toleranceBps {
+			stats.MissedHighs++
+			highSum += shortfall
+		}
+		if excess := bps(c.Low, o.Low); excess > toleranceBps {
+			stats.MissedLows++
+			lowSum += excess
+		}
+
+		collectedVolume = collectedVolume.Add(c.Volume)
+		officialVolume = officialVolume.Add(o.Volume)
+	}
+
+	stats.Extra = len(collected) - stats.Matched
+
+	if stats.Matched > 0 {
+		stats.OpenMeanBps = openSum / float64(stats.Matched)
+		stats.CloseMeanBps = closeSum / float64(stats.Matched)
+	}
+	if stats.MissedHighs > 0 {
+		stats.MissedHighMeanBps = highSum / float64(stats.MissedHighs)
+	}
+	if stats.MissedLows > 0 {
+		stats.MissedLowMeanBps = lowSum / float64(stats.MissedLows)
+	}
+	if officialVolume.IsPositive() {
+		stats.VolumeRatio = collectedVolume.Div(officialVolume).InexactFloat64()
+	}
+	return stats, nil
+}
+
+// bps returns the deviation of value from reference in basis points. The difference is taken
+// exactly, so prices far below one still compare correctly.
+func bps(value, reference decimal.Decimal) float64 {
+	if reference.IsZero() {
+		return 0
+	}
+	return value.Sub(reference).Div(reference).InexactFloat64() * 10_000
+}
+
+// sample picks n symbols at random, keeping their configured order.
+func sample(symbols []string, n int) []string {
+	if n <= 0 || n >= len(symbols) {
+		return symbols
+	}
+	picked := rand.Perm(len(symbols))[:n]
+	sort.Ints(picked)
+
+	result := make([]string, 0, n)
+	for _, i := range picked {
+		result = append(result, symbols[i])
+	}
+	return result
+}
+
+var columns = []string{
+	"symbol", "interval", "official", "collected", "matched", "missing", "extra",
+	"open_mean_bps", "open_max_bps", "close_mean_bps", "close_max_bps",
+	"missed_highs", "missed_high_mean_bps", "missed_lows", "missed_low_mean_bps",
+	"volume_ratio", "error",
+}
+
+func (s SymbolStats) values() []string {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+	return []string{
+		s.Symbol, s.Interval, strconv.Itoa(s.Official), strconv.Itoa(s.Collected),
+		strconv.Itoa(s.Matched), strconv.Itoa(s.Missing), strconv.Itoa(s.Extra),
+		f(s.OpenMeanBps), f(s.OpenMaxBps), f(s.CloseMeanBps), f(s.CloseMaxBps),
+		strconv.Itoa(s.MissedHighs), f(s.MissedHighMeanBps), strconv.Itoa(s.MissedLows), f(s.MissedLowMeanBps),
+		strconv.FormatFloat(s.VolumeRatio, 'f', 4, 64), s.Error,
+	}
+}
+
+// PrintTable writes the statistics as an aligned table.
+func PrintTable(w io.Writer, stats []SymbolStats) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, strings.Join(columns, "\t")+"\t")
+	for _, s := range stats {
+		fmt.Fprintln(tw, strings.Join(s.values(), "\t")+"\t")
+	}
+	return tw.Flush()
+}
+
+// WriteReport saves the statistics to path as CSV or JSON, chosen by the file extension.
+func WriteReport(path string, stats []SymbolStats) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create report: %w", err)
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(stats)
+	case ".csv":
+		cw := csv.NewWriter(f)
+		if err = cw.Write(columns); err != nil {
+			break
+		}
+		for _, s := range stats {
+			if err = cw.Write(s.values()); err != nil {
+				break
+			}
+		}
+		cw.Flush()
+		if err == nil {
+			err = cw.Error()
+		}
+	default:
+		return fmt.Errorf("unsupported report format %q, use .csv or .json", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,90 @@
+package reconcile
+
+import (
+	"math"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"scanner.magictradebot.com/models"
+)
+
+// candle is a kline of the exchange opening at the given minute.
+func candle(exchange string, minute int64, open, high, low, close, volume string) models.SymbolKlineData {
+	return models.SymbolKlineData{
+		Exchange: exchange,
+		Symbol:   "BTC",
+		Interval: "1m",
+		OpenTime: minute * 60_000,
+		Open:     decimal.RequireFromString(open),
+		High:     decimal.RequireFromString(high),
+		Low:      decimal.RequireFromString(low),
+		Close:    decimal.RequireFromString(close),
+		Volume:   decimal.RequireFromString(volume),
+	}
+}
+
+func TestCompare(t *testing.T) {
+	official := []models.SymbolKlineData{
+		candle("", 0, "100", "110", "90", "105", "10"),
+		candle("", 1, "105", "120", "100", "110", "10"),
+	}
+
+	tests := []struct {
+		name      string
+		collected []models.SymbolKlineData
+		want      SymbolStats
+		wantErr   bool
+	}{
+		{
+			name: "matched",
+			collected: []models.SymbolKlineData{
+				candle("binance", 0, "100", "110", "90", "105", "10"),
+				candle("binance", 1, "105.21", "119", "100", "110", "5"),
+			},
+			want: SymbolStats{Official: 2, Collected: 2, Matched: 2, OpenMeanBps: 10, OpenMaxBps: 20, MissedHighs: 1, MissedHighMeanBps: 83.33, VolumeRatio: 0.75},
+		},
+		{
+			name:      "missing",
+			collected: []models.SymbolKlineData{candle("binance", 1, "105", "120", "100", "110", "10")},
+			want:      SymbolStats{Official: 2, Collected: 1, Matched: 1, Missing: 1, VolumeRatio: 1},
+		},
+		{
+			name: "extra",
+			collected: []models.SymbolKlineData{
+				candle("binance", 0, "100", "110", "90", "105", "10"),
+				candle("binance", 1, "105", "120", "101", "110", "10"),
+				candle("binance", 2, "110", "110", "110", "110", "1"),
+			},
+			want: SymbolStats{Official: 2, Collected: 3, Matched: 2, Extra: 1, MissedLows: 1, MissedLowMeanBps: 100, VolumeRatio: 1},
+		},
+		{
+			name: "duplicate exchange",
+			collected: []models.SymbolKlineData{
+				candle("binance", 0, "100", "110", "90", "105", "10"),
+				candle("okx", 0, "100", "110", "90", "105", "10"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Compare("BTC", "1m", tt.collected, official, 5)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			tt.want.Symbol, tt.want.Interval = "BTC", "1m"
+			round := func(s SymbolStats) SymbolStats {
+				for _, f := range []*float64{&s.OpenMeanBps, &s.OpenMaxBps, &s.CloseMeanBps, &s.CloseMaxBps, &s.MissedHighMeanBps, &s.MissedLowMeanBps, &s.VolumeRatio} {
+					*f = math.Round(*f*100) / 100
+				}
+				return s
+			}
+			if got = round(got); got != tt.want {
+				t.Errorf("Compare =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package retention
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/models"
+	"scanner.magictradebot.com/pkg/aggregator"
+	"scanner.magictradebot.com/pkg/export"
+	"scanner.magictradebot.com/pkg/storage"
+)
+
+// Store is the repository the retention job trims.
+type Store interface {
+	storage.Store
+	// RollUpKlines resamples the klines of one interval opening within [from, before) into the
+	// coarser target interval, inserting the candles not stored yet, and returns how many were built.
+	RollUpKlines(interval, target string, alignment config.AlignmentSettings, from, before int64, dryRun bool) (int64, error)
+}
+
+// Result reports what one policy did, or would do in a dry run.
+type Result struct {
+	Interval string
+	Cutoff   int64  // klines opening before this (unix ms) expire
+	Expired  int64  // stored klines past the cutoff
+	Oldest   int64  // open time of the oldest expired kline
+	RollUpTo string // interval the expired klines are rolled up into
+	RolledUp int64  // coarser candles built from the expired klines
+	Archive  string // file holding the expired klines
+	Purged   int64  // klines deleted
+	Error    string
+}
+
+// policy is a validated retention policy.
+type policy struct {
+	interval string
+	ms       int64
+	keep     time.Duration
+	rollUpTo string
+	target   aggregator.Boundary
+}
+
+// Job applies the configured retention policies to the exchange's klines.
+type Job struct {
+	cfg       config.RetentionSettings
+	alignment map[string]config.AlignmentSettings
+	exchange  string
+	store     Store
+	policies  []policy
+	running   sync.Mutex
+	log       *logrus.Logger
+}
+
+// New validates the policies. Roll-ups must target a coarser interval.
+func New(cfg config.RetentionSettings, alignment map[string]config.AlignmentSettings, exchange string, store Store, log *logrus.Logger) (*Job, error) {
+	if cfg.CheckMinutes <= 0 {
+		cfg.CheckMinutes = 60
+	}
+	if cfg.Archive.Directory == "" {
+		cfg.Archive.Directory = "data/archive"
+	}
+	if cfg.Archive.Format == "" {
+		cfg.Archive.Format = export.FormatJSONL
+	}
+	if cfg.Archive.Compression == "" {
+		cfg.Archive.Compression = "gzip"
+	}
+
+	j := &Job{cfg: cfg, alignment: alignment, exchange: strings.ToLower(exchange), store: store, log: log}
+	for interval, p := range cfg.Policies {
+		ms, err := aggregator.ParseInterval(interval)
+		if err != nil {
+			return nil, fmt.Errorf("retention policy %s: %w", interval, err)
+		}
+		if p.KeepDays < 0 {
+			return nil, fmt.Errorf("retention policy %s: KeepDays must not be negative", interval)
+		}
+		pol := policy{interval: interval, ms: ms, keep: time.Duration(p.KeepDays) * 24 * time.Hour, rollUpTo: p.RollUpTo}
+		if p.RollUpTo != "" {
+			targetMs, err := aggregator.ParseInterval(p.RollUpTo)
+			if err != nil {
+				return nil, fmt.Errorf("retention policy %s: %w", interval, err)
+			}
+			if targetMs <= ms {
+				return nil, fmt.Errorf("retention policy %s: cannot roll up into the finer or equal interval %s", interval, p.RollUpTo)
+			}
+			if pol.target, err = aggregator.NewBoundary(p.RollUpTo, alignment[p.RollUpTo]); err != nil {
+				return nil, fmt.Errorf("retention policy %s: %w", interval, err)
+			}
+		}
+		j.policies = append(j.policies, pol)
+	}
+	// Fine intervals go first so their roll-ups land before coarser ones are trimmed.
+	sort.Slice(j.policies, func(a, b int) bool { return j.policies[a].ms < j.policies[b].ms })
+	return j, nil
+}
+
+// Every is how often the job should run.
+func (j *Job) Every() time.Duration {
+	return time.Duration(j.cfg.CheckMinutes) * time.Minute
+}
+
+// Run applies every policy with now as the reference time. A policy that fails is reported
+// with its error and its klines are left in place.
+func (j *Job) Run(now time.Time, dryRun bool) []Result {
+	j.running.Lock()
+	defer j.running.Unlock()
+	return j.run(now, dryRun)
+}
+
+// RunBackground runs the job as configured and logs the results, skipping the run if the
+// previous one is still going.
+func (j *Job) RunBackground(now time.Time) {
+	if !j.running.TryLock() {
+		j.log.Warn("⚠️ Retention job still running, skipping this round")
+		return
+	}
+	defer j.running.Unlock()
+
+	for _, res := range j.run(now, j.cfg.DryRun) {
+		fields := logrus.Fields{
+			"interval":  res.Interval,
+			"cutoff":    time.UnixMilli(res.Cutoff).UTC().Format(time.RFC3339),
+			"expired":   res.Expired,
+			"rolled_up": res.RolledUp,
+			"archive":   res.Archive,
+			"purged":    res.Purged,
+		}
+		switch {
+		case res.Error != "":
+			j.log.WithFields(fields).Errorf("❌ Retention failed: %s", res.Error)
+		case res.Expired == 0:
+		case j.cfg.DryRun:
+			j.log.WithFields(fields).Info("🧹 Retention dry run")
+		default:
+			j.log.WithFields(fields).Info("🧹 Retention applied")
+		}
+	}
+}
+
+func (j *Job) run(now time.Time, dryRun bool) []Result {
+	results := make([]Result, 0, len(j.policies))
+	for _, p := range j.policies {
+		if p.keep == 0 {
+			continue
+		}
+		res, err := j.apply(p, now, dryRun)
+		if err != nil {
+			res.Error = err.Error()
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// apply rolls up, archives and deletes the expired klines of one interval, stopping at the
+// first step that fails so nothing is deleted before it is safely kept elsewhere.
+func (j *Job) apply(p policy, now time.Time, dryRun bool) (Result, error) {
+	cutoff := now.Add(-p.keep).UnixMilli()
+	if p.rollUpTo != "" {
+		// Whole target buckets expire at once, so no roll-up is ever built from half its klines.
+		cutoff = p.target.Floor(cutoff)
+	}
+	res := Result{Interval: p.interval, Cutoff: cutoff, RollUpTo: p.rollUpTo}
+
+	// Klines stored from here on are neither archived nor purged, so none is lost unarchived.
+	count, oldest, maxID, err := j.store.CountKlines(p.interval, cutoff)
+	if err != nil {
+		return res, err
+	}
+	res.Expired, res.Oldest = count, oldest
+	if count == 0 {
+		return res, nil
+	}
+
+	if p.rollUpTo != "" {
+		from := oldest
+		if target, ok := j.cfg.Policies[p.rollUpTo]; ok && target.KeepDays > 0 {
+			// Candles the target interval would expire right away are not worth building.
+			from = max(from, now.AddDate(0, 0, -target.KeepDays).UnixMilli())
+		}
+		if from < cutoff {
+			res.RolledUp, err = j.store.RollUpKlines(p.interval, p.rollUpTo, j.alignment[p.rollUpTo], from, cutoff, dryRun)
+			if err != nil {
+				return res, err
+			}
+		}
+	}
+
+	if j.cfg.Archive.Enabled {
+		res.Archive = j.archivePath(p.interval, oldest, cutoff)
+		if !dryRun {
+			if err := j.archive(res.Archive, p.interval, cutoff, maxID); err != nil {
+				return res, err
+			}
+		}
+	}
+
+	if dryRun {
+		return res, nil
+	}
+	res.Purged, err = j.store.PurgeKlines(p.interval, cutoff, maxID)
+	return res, err
+}
+
+// archivePath names the file for klines of one interval opening within [from, before).
+func (j *Job) archivePath(interval string, from, before int64) string {
+	layout := "20060102T1504"
+	ext := "." + strings.ToLower(j.cfg.Archive.Format)
+	switch strings.ToLower(j.cfg.Archive.Compression) {
+	case "gzip":
+		ext += ".gz"
+	case "zstd":
+		ext += ".zst"
+	}
+	if strings.EqualFold(j.cfg.Archive.Format, export.FormatParquet) {
+		ext = ".parquet"
+	}
+	name := fmt.Sprintf("klines_%s_%s%s", time.UnixMilli(from).UTC().Format(layout), time.UnixMilli(before).UTC().Format(layout), ext)
+	return filepath.Join(j.cfg.Archive.Directory, j.exchange, interval, name)
+}
+
+// archive exports the klines of one interval opening before the cutoff and stored no later
+// than the one with maxID to path. The file only appears once it is complete and synced.
+func (j *Job) archive(path, interval string, before, maxID int64) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create archive directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("create archive: %w", err)
+	}
+	defer os.Remove(tmp)
+
+	_, err = export.Run(storedUpTo{j.store, maxID}, export.Options{
+		Filter:      storage.KlineFilter{Exchange: j.exchange, Interval: interval, To: before - 1},
+		Format:      j.cfg.Archive.Format,
+		Compression: j.cfg.Archive.Compression,
+	}, f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("archive %s klines: %w", interval, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// storedUpTo hides the klines stored after the one with maxID.
+type storedUpTo struct {
+	storage.KlineRepository
+	maxID int64
+}
+
+func (s storedUpTo) EachKline(filter storage.KlineFilter, fn func(models.SymbolKlineData) error) error {
+	return s.KlineRepository.EachKline(filter, func(k models.SymbolKlineData) error {
+		if k.ID > s.maxID {
+			return nil
+		}
+		return fn(k)
+	})
+}
+
+// PrintReport writes the results as a table.
+func PrintReport(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "INTERVAL\tCUTOFF\tEXPIRED\tOLDEST\tROLL UP\tROLLED\tARCHIVE\tPURGED\tERROR")
+	for _, r := range results {
+		oldest, rollUp, archive := "-", "-", "-"
+		if r.Expired > 0 {
+			oldest = time.UnixMilli(r.Oldest).UTC().Format(time.RFC3339)
+		}
+		if r.RollUpTo != "" {
+			rollUp = r.RollUpTo
+		}
+		if r.Archive != "" {
+			archive = r.Archive
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%d\t%s\t%d\t%s\n",
+			r.Interval,
+			time.UnixMilli(r.Cutoff).UTC().Format(time.RFC3339),
+			r.Expired,
+			oldest,
+			rollUp,
+			r.RolledUp,
+			archive,
+			r.Purged,
+			r.Error,
+		)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,82 @@
+package retention
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/models"
+	"scanner.magictradebot.com/pkg/db"
+)
+
+// lateWriter stores a late kline while the job rolls up, after the expired klines were counted.
+type lateWriter struct {
+	*db.GormRepository
+	late models.SymbolKlineData
+}
+
+func (s lateWriter) RollUpKlines(interval, target string, alignment config.AlignmentSettings, from, before int64, dryRun bool) (int64, error) {
+	built, err := s.GormRepository.RollUpKlines(interval, target, alignment, from, before, dryRun)
+	if err == nil {
+		err = s.SaveKlines([]models.SymbolKlineData{s.late})
+	}
+	return built, err
+}
+
+func TestApplyKeepsKlinesStoredDuringTheRun(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	gormDB, err := db.Open(config.DatabaseSettings{Provider: "sqlite", ConnectionString: filepath.Join(t.TempDir(), "test.db")}, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := db.NewGormRepository(gormDB, "binance", "test", db.DecimalScale{Price: 8, Volume: 8}, config.PostgresSettings{}, log)
+	defer repo.Close()
+	if err := repo.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	expired := now.AddDate(0, 0, -5).UnixMilli()
+	if err := repo.SaveKlines([]models.SymbolKlineData{
+		{Symbol: "BTCUSDT", Interval: "1m", OpenTime: expired},
+		{Symbol: "BTCUSDT", Interval: "1m", OpenTime: expired + 60_000},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.RetentionSettings{Policies: map[string]config.RetentionPolicy{"1m": {KeepDays: 1, RollUpTo: "1h"}}}
+	cfg.Archive.Enabled = true
+	cfg.Archive.Directory = t.TempDir()
+	cfg.Archive.Compression = "none"
+	store := lateWriter{repo, models.SymbolKlineData{Symbol: "ETHUSDT", Interval: "1m", OpenTime: expired}}
+	job, err := New(cfg, nil, "binance", store, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := job.Run(now, false)
+	if len(results) != 1 || results[0].Error != "" {
+		t.Fatalf("results = %+v", results)
+	}
+	if res := results[0]; res.Expired != 2 || res.Purged != 2 {
+		t.Errorf("expired %d and purged %d klines, want 2 and 2", res.Expired, res.Purged)
+	}
+
+	archived, err := os.ReadFile(results[0].Archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(archived), "\n"); lines != 2 || strings.Contains(string(archived), "ETH") {
+		t.Errorf("archive holds %d lines:\n%s", lines, archived)
+	}
+	left, err := repo.LoadKlines("ETHUSDT", "1m", models.SourceAggregated, 0, now.UnixMilli())
+	if err != nil || len(left) != 1 {
+		t.Errorf("the late kline was purged unarchived: %v, %v", left, err)
+	}
+}
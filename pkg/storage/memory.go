@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"scanner.magictradebot.com/models"
+)
+
+// MemoryRepository keeps candles in memory, for tests and dry runs. Like the database it
+// tags candles with its exchange, stores symbols in their StoredSymbol form and only reads
+// back its own exchange's candles.
+type MemoryRepository struct {
+	lock     sync.Mutex
+	exchange string
+	klines   map[memoryKey]models.SymbolKlineData
+}
+
+type memoryKey struct {
+	exchange string
+	symbol   string
+	interval string
+	openTime int64
+	source   string
+}
+
+var _ KlineRepository = (*MemoryRepository)(nil)
+
+// NewMemoryRepository returns an empty repository for the exchange.
+func NewMemoryRepository(exchange string) *MemoryRepository {
+	return &MemoryRepository{exchange: strings.ToLower(exchange), klines: make(map[memoryKey]models.SymbolKlineData)}
+}
+
+// prepare tags a candle the way the database repository does before storing it.
+func (m *MemoryRepository) prepare(k models.SymbolKlineData) models.SymbolKlineData {
+	k.Exchange = m.exchange
+	k.Symbol = StoredSymbol(k.Symbol)
+	if k.Source == "" {
+		k.Source = models.SourceAggregated
+	}
+	return k
+}
+
+func keyOf(k models.SymbolKlineData) memoryKey {
+	return memoryKey{k.Exchange, k.Symbol, k.Interval, k.OpenTime, k.Source}
+}
+
+func (m *MemoryRepository) SaveKlines(data []models.SymbolKlineData) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, k := range data {
+		k = m.prepare(k)
+		if _, ok := m.klines[keyOf(k)]; !ok {
+			m.klines[keyOf(k)] = k
+		}
+	}
+	return nil
+}
+
+func (m *MemoryRepository) UpsertKlines(data []models.SymbolKlineData) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, k := range data {
+		k = m.prepare(k)
+		if stored, ok := m.klines[keyOf(k)]; !ok || stored.Amendments < k.Amendments {
+			m.klines[keyOf(k)] = k
+		}
+	}
+	return nil
+}
+
+func (m *MemoryRepository) SaveOfficialKlines(data []models.SymbolKlineData) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, k := range data {
+		k.Source = models.SourceExchange
+		k = m.prepare(k)
+		m.klines[keyOf(k)] = k
+
+		aggregated := memoryKey{k.Exchange, k.Symbol, k.Interval, k.OpenTime, models.SourceAggregated}
+		if stored, ok := m.klines[aggregated]; ok {
+			stored.Superseded = true
+			m.klines[aggregated] = stored
+		}
+	}
+	return nil
+}
+
+func (m *MemoryRepository) LoadKlines(symbol, interval, source string, from, to int64) ([]models.SymbolKlineData, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	symbol = StoredSymbol(symbol)
+	var rows []models.SymbolKlineData
+	for key, k := range m.klines {
+		if key.exchange == m.exchange && key.symbol == symbol && key.interval == interval && key.source == source && key.openTime >= from && key.openTime <= to {
+			rows = append(rows, k)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].OpenTime < rows[j].OpenTime })
+	return rows, nil
+}
+
+func (m *MemoryRepository) LatestKlines(interval, source string) ([]models.SymbolKlineData, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	latest := make(map[string]models.SymbolKlineData)
+	for key, k := range m.klines {
+		if key.exchange != m.exchange || key.interval != interval || key.source != source {
+			continue
+		}
+		if current, ok := latest[key.symbol]; !ok || k.OpenTime > current.OpenTime {
+			latest[key.symbol] = k
+		}
+	}
+
+	rows := make([]models.SymbolKlineData, 0, len(latest))
+	for _, k := range latest {
+		rows = append(rows, k)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Symbol < rows[j].Symbol })
+	return rows, nil
+}
+
+func (m *MemoryRepository) EachKline(filter KlineFilter, fn func(models.SymbolKlineData) error) error {
+	m.lock.Lock()
+	var rows []models.SymbolKlineData
+	for key, k := range m.klines {
+		if filter.Matches(key.symbol, key.exchange, key.interval, key.source, key.openTime) {
+			rows = append(rows, k)
+		}
+	}
+	m.lock.Unlock()
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Symbol != rows[j].Symbol {
+			return rows[i].Symbol < rows[j].Symbol
+		}
+		return rows[i].OpenTime < rows[j].OpenTime
+	})
+	for _, k := range rows {
+		if err := fn(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,9 +1,142 @@
 package storage
 
 import (
-	"fmt"
+	"errors"
+	"path"
+	"strings"
+
+	"scanner.magictradebot.com/models"
 )
 
-func AddPrice(symbol string, price float64, volume float64) {
-	fmt.Printf("Added price for %s: %.2f with volume %.2f\n", symbol, price, volume)
+// KlineSink receives finalized candles.
+type KlineSink interface {
+	// SaveKlines inserts candles, skipping ones already stored.
+	SaveKlines(data []models.SymbolKlineData) error
+	// UpsertKlines replaces stored candles with amended versions carrying more amendments.
+	UpsertKlines(data []models.SymbolKlineData) error
+}
+
+// KlineRepository stores candles and reads them back.
+type KlineRepository interface {
+	KlineSink
+	// SaveOfficialKlines stores exchange candles and marks the matching aggregated ones superseded.
+	SaveOfficialKlines(data []models.SymbolKlineData) error
+	// LoadKlines returns this exchange's candles of one symbol and source opening within
+	// [from, to] (unix ms), oldest first.
+	LoadKlines(symbol, interval, source string, from, to int64) ([]models.SymbolKlineData, error)
+	// LatestKlines returns the most recent candle of every symbol of this exchange.
+	LatestKlines(interval, source string) ([]models.SymbolKlineData, error)
+	// EachKline streams the candles matching filter, ordered by symbol and open time,
+	// stopping at the first error fn returns.
+	EachKline(filter KlineFilter, fn func(models.SymbolKlineData) error) error
+}
+
+// KlineFilter selects candles. Empty fields match everything.
+type KlineFilter struct {
+	Exchange string
+	Symbols  []string // exact symbols or globs with * and ?, both taken in their stored form (see StoredSymbol)
+	Interval string
+	Source   string
+	From     int64 // unix ms, inclusive
+	To       int64 // unix ms, inclusive; 0 for no upper bound
+}
+
+// Store is everything the collector persists.
+type Store interface {
+	KlineRepository
+	SaveBars(data []models.SymbolBarData) error
+	// NextBarSequences returns the sequence the next bar of each of this exchange's stored
+	// symbols and bar types takes.
+	NextBarSequences() (map[string]map[string]int64, error)
+	SaveQuarantinedTicks(data []models.QuarantinedTick) error
+	SaveSecondKlines(data []models.SymbolSecondKlineData) error
+	// PurgeSecondKlines deletes this exchange's sub-minute candles opened before the cutoff (unix ms).
+	PurgeSecondKlines(before int64) (int64, error)
+	// CountKlines returns how many of this exchange's candles of one interval opened before
+	// the cutoff (unix ms), when the oldest one opened and the highest id among them.
+	CountKlines(interval string, before int64) (count, oldest, maxID int64, err error)
+	// PurgeKlines deletes this exchange's candles of one interval opened before the cutoff,
+	// leaving alone those stored after the one with maxID.
+	PurgeKlines(interval string, before, maxID int64) (int64, error)
+	Migrate() error
+	Close() error
+}
+
+// MultiSink forwards candles to several sinks. Every sink is attempted even when one fails.
+type MultiSink []KlineSink
+
+func (m MultiSink) SaveKlines(data []models.SymbolKlineData) error {
+	var errs []error
+	for _, sink := range m {
+		errs = append(errs, sink.SaveKlines(data))
+	}
+	return errors.Join(errs...)
+}
+
+func (m MultiSink) UpsertKlines(data []models.SymbolKlineData) error {
+	var errs []error
+	for _, sink := range m {
+		errs = append(errs, sink.UpsertKlines(data))
+	}
+	return errors.Join(errs...)
+}
+
+// Matches reports whether a candle with the given keys passes the filter. Symbols and
+// patterns are compared in their stored form, as the database does.
+func (f KlineFilter) Matches(symbol, exchange, interval, source string, openTime int64) bool {
+	if f.Exchange != "" && !strings.EqualFold(f.Exchange, exchange) {
+		return false
+	}
+	if f.Interval != "" && f.Interval != interval {
+		return false
+	}
+	if f.Source != "" && f.Source != source {
+		return false
+	}
+	if openTime < f.From || (f.To > 0 && openTime > f.To) {
+		return false
+	}
+	if len(f.Symbols) == 0 {
+		return true
+	}
+	symbol = StoredSymbol(symbol)
+	for _, pattern := range f.Symbols {
+		if ok, _ := path.Match(StoredSymbol(pattern), symbol); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// IsGlob reports whether a symbol pattern contains wildcards.
+func IsGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?")
+}
+
+// StoredSymbol is the form symbols are stored in: upper case with the quote asset and
+// contract suffix removed, so "BTC-USDT-SWAP" and "BTCUSDT" are both stored as "BTC".
+func StoredSymbol(raw string) string {
+	raw = strings.ToUpper(raw) // Normalize
+
+	// Case 1: Hyphen-based format
+	if strings.Contains(raw, "-") {
+		suffixes := []string{"-USDT-SWAP", "-USDT", "-USD-SWAP", "-USD", "-PERP", "-FUTURE", "-SWAP"}
+		for _, s := range suffixes {
+			if strings.HasSuffix(raw, s) {
+				return strings.TrimSuffix(raw, s)
+			}
+		}
+		return raw
+	}
+
+	// Case 2: Concatenated format (e.g. BTCUSDT, ETHUSD)
+	quoteAssets := []string{"USDT", "USD", "BUSD", "USDC", "TUSD", "DAI", "USDT_UMCBL"}
+	for _, quote := range quoteAssets {
+		if strings.HasSuffix(raw, quote) {
+			return strings.TrimSuffix(raw, quote)
+		}
+	}
+
+	// Default: return raw symbol
+	return raw
 }
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"testing"
+
+	"scanner.magictradebot.com/models"
+)
+
+func TestKlineFilterMatchesStoredSymbols(t *testing.T) {
+	tests := []struct {
+		pattern string
+		symbol  string
+		want    bool
+	}{
+		{pattern: "ETH*", symbol: "ETHUSDT", want: true},
+		{pattern: "ETH*", symbol: "ETHFI-USDT-SWAP", want: true},
+		{pattern: "ETH*", symbol: "BTCUSDT", want: false},
+		{pattern: "*USDT", symbol: "BTCUSDT", want: true},
+		{pattern: "BTC-USDT-SWAP", symbol: "BTCUSDT", want: true},
+		{pattern: "btc", symbol: "BTC-USDT-SWAP", want: true},
+		{pattern: "BTCUSDT", symbol: "BTC", want: true},
+		{pattern: "E?H", symbol: "ETHUSDT", want: true},
+		{pattern: "E?H", symbol: "ETHFIUSDT", want: false},
+	}
+	for _, tt := range tests {
+		f := KlineFilter{Symbols: []string{tt.pattern}}
+		if got := f.Matches(tt.symbol, "binance", "1m", "aggregated", 0); got != tt.want {
+			t.Errorf("pattern %q on %q = %v, want %v", tt.pattern, tt.symbol, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryRepositoryStoresLikeTheDatabase(t *testing.T) {
+	repo := NewMemoryRepository("Binance")
+	input := []models.SymbolKlineData{
+		{Symbol: "BTCUSDT", Interval: "1m", OpenTime: 60_000, Exchange: "okx"},
+		{Symbol: "BTC-USDT-SWAP", Interval: "1m", OpenTime: 60_000},
+		{Symbol: "ETHUSDT", Interval: "1m", OpenTime: 120_000},
+	}
+	if err := repo.SaveKlines(input); err != nil {
+		t.Fatal(err)
+	}
+	if input[0].Symbol != "BTCUSDT" || input[0].Exchange != "okx" {
+		t.Errorf("SaveKlines rewrote its input: %+v", input[0])
+	}
+
+	loaded, err := repo.LoadKlines("btcusdt", "1m", models.SourceAggregated, 0, 60_000)
+	if err != nil || len(loaded) != 1 {
+		t.Fatalf("LoadKlines = %+v, %v, want the one BTC kline", loaded, err)
+	}
+	if k := loaded[0]; k.Symbol != "BTC" || k.Exchange != "binance" || k.Source != models.SourceAggregated {
+		t.Errorf("stored as %s on %s from %s, want BTC on binance from aggregated", k.Symbol, k.Exchange, k.Source)
+	}
+
+	latest, err := repo.LatestKlines("1m", models.SourceAggregated)
+	if err != nil || len(latest) != 2 || latest[0].Symbol != "BTC" || latest[1].Symbol != "ETH" {
+		t.Errorf("LatestKlines = %+v, %v", latest, err)
+	}
+}
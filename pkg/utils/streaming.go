@@ -26,3 +26,16 @@ func WriteKafkaMessage(writer *kafka.Writer, payload []byte) error {
 
 	return writer.WriteMessages(context.Background(), msg)
 }
+
+// WriteKafkaBatch writes one message per payload, keyed by symbol so each symbol stays ordered
+func WriteKafkaBatch(writer *kafka.Writer, symbols []string, payloads [][]byte) error {
+	msgs := make([]kafka.Message, len(payloads))
+	for i, payload := range payloads {
+		msgs[i] = kafka.Message{
+			Key:   []byte(symbols[i]),
+			Value: payload,
+		}
+	}
+
+	return writer.WriteMessages(context.Background(), msgs...)
+}
@@ -0,0 +1,416 @@
+package writequeue
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/models"
+	"scanner.magictradebot.com/pkg/storage"
+)
+
+// Ways a queued candle is written.
+const (
+	opSave   = "save"
+	opUpsert = "upsert"
+)
+
+// item is one candle waiting to be written.
+type item struct {
+	Op         string                 `json:"op"`
+	Kline      models.SymbolKlineData `json:"kline"`
+	EnqueuedAt int64                  `json:"at"` // unix ms
+}
+
+// Queue is a write-behind buffer in front of a sink. Candles are accepted at once and
+// written in batches by a background worker, which retries failed writes with exponential
+// backoff. While writes fail, or once the memory buffer is full, candles spill to disk and
+// are replayed when the sink recovers, including after a restart. Write order is not
+// preserved; inserts skip stored candles and upserts keep the most amended version, so it
+// does not change the result.
+type Queue struct {
+	sink       storage.KlineSink
+	batchSize  int
+	maxPending int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	drainTime  time.Duration
+	log        *logrus.Logger
+
+	lock       sync.Mutex
+	memory     []item
+	spill      *spillQueue
+	preferDisk bool // alternate between memory and disk while both hold candles
+	failing    bool // the last write failed
+	failures   int  // consecutive failed writes
+	written    int64
+	dropped    int64
+	closed     bool
+
+	wake    chan struct{}
+	closing chan struct{}
+	done    chan struct{}
+}
+
+var _ storage.KlineSink = (*Queue)(nil)
+
+// Stats is a point-in-time view of the queue.
+type Stats struct {
+	Buffered int       // candles held in memory
+	Spilled  int       // candles waiting on disk
+	Oldest   time.Time // when the oldest pending candle was queued; zero when nothing is pending
+	Written  int64     // candles written since start
+	Dropped  int64     // candles lost because neither the sink nor the disk took them
+	Failures int       // consecutive failed writes
+}
+
+// New starts the worker writing to sink. Candles spilled by a previous run are replayed first.
+func New(cfg config.WriteQueueSettings, sink storage.KlineSink, log *logrus.Logger) (*Queue, error) {
+	dir := cfg.Directory
+	if dir == "" {
+		dir = "data/writequeue"
+	}
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	maxPending := cfg.MaxPending
+	if maxPending <= 0 {
+		maxPending = 50000
+	}
+	segmentSize := cfg.SegmentSize
+	if segmentSize <= 0 {
+		segmentSize = 10000
+	}
+	minBackoff := cfg.MinBackoffMillis
+	if minBackoff <= 0 {
+		minBackoff = 500
+	}
+	maxBackoff := cfg.MaxBackoffSeconds
+	if maxBackoff <= 0 {
+		maxBackoff = 60
+	}
+	drainSeconds := cfg.ShutdownSeconds
+	if drainSeconds <= 0 {
+		drainSeconds = 10
+	}
+
+	spill, err := openSpillQueue(dir, segmentSize)
+	if err != nil {
+		return nil, err
+	}
+	if pending := spill.pending(); pending > 0 {
+		log.WithFields(logrus.Fields{
+			"directory": dir,
+			"klines":    pending,
+		}).Info("📂 Replaying klines spilled by a previous run")
+	}
+
+	q := &Queue{
+		sink:       sink,
+		batchSize:  batchSize,
+		maxPending: maxPending,
+		minBackoff: time.Duration(minBackoff) * time.Millisecond,
+		maxBackoff: time.Duration(maxBackoff) * time.Second,
+		drainTime:  time.Duration(drainSeconds) * time.Second,
+		log:        log,
+		spill:      spill,
+		wake:       make(chan struct{}, 1),
+		closing:    make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go q.run()
+	return q, nil
+}
+
+func (q *Queue) SaveKlines(data []models.SymbolKlineData) error {
+	return q.enqueue(opSave, data)
+}
+
+func (q *Queue) UpsertKlines(data []models.SymbolKlineData) error {
+	return q.enqueue(opUpsert, data)
+}
+
+// enqueue buffers candles in memory, or on disk while writes fail or memory is full.
+func (q *Queue) enqueue(op string, data []models.SymbolKlineData) error {
+	if len(data) == 0 {
+		return nil
+	}
+	now := time.Now().UnixMilli()
+	entries := make([]item, len(data))
+	for i, k := range data {
+		entries[i] = item{Op: op, Kline: k, EnqueuedAt: now}
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return errors.New("write queue is closed")
+	}
+	if q.failing || len(q.memory)+len(entries) > q.maxPending {
+		q.spillLocked(entries)
+	} else {
+		q.memory = append(q.memory, entries...)
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// spillLocked moves entries to disk. If the disk fails too they stay in memory up to its
+// bound and the rest are dropped; the buffer's head is never touched, since the worker may be
+// writing it. The lock must be held.
+func (q *Queue) spillLocked(entries []item) {
+	err := q.spill.append(entries)
+	if err == nil {
+		return
+	}
+	q.log.Errorf("❌ Failed to spill klines to disk: %v", err)
+
+	room := max(q.maxPending-len(q.memory), 0)
+	if over := len(entries) - room; over > 0 {
+		entries = entries[:room]
+		q.dropped += int64(over)
+		q.log.WithField("dropped", over).Error("🗑️ Write queue full, dropped klines")
+	}
+	q.memory = append(q.memory, entries...)
+}
+
+// run writes batches until the queue is closed.
+func (q *Queue) run() {
+	defer close(q.done)
+
+	backoff := q.minBackoff
+	for {
+		select {
+		case <-q.closing:
+			q.drain()
+			return
+		default:
+		}
+
+		batch, fromDisk, err := q.next()
+		if err == nil && len(batch) == 0 {
+			select {
+			case <-q.wake:
+			case <-q.closing:
+			}
+			continue
+		}
+		if err == nil {
+			err = q.write(batch)
+		}
+		if err != nil {
+			q.fail(err, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-q.closing:
+			}
+			backoff = min(backoff*2, q.maxBackoff)
+			continue
+		}
+
+		backoff = q.minBackoff
+		if err := q.commit(len(batch), fromDisk); err != nil {
+			q.log.Errorf("❌ Failed to advance write queue: %v", err)
+		}
+	}
+}
+
+// next copies the next batch, alternating between memory and disk while both hold candles.
+func (q *Queue) next() ([]item, bool, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	fromDisk := len(q.memory) == 0 || (q.preferDisk && q.spill.pending() > 0)
+	q.preferDisk = !q.preferDisk
+	if !fromDisk {
+		n := min(q.batchSize, len(q.memory))
+		return append([]item(nil), q.memory[:n]...), false, nil
+	}
+	for q.spill.pending() > 0 {
+		batch, err := q.spill.peek(q.batchSize)
+		if err != nil {
+			return nil, true, err
+		}
+		if len(batch) > 0 {
+			return append([]item(nil), batch...), true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// write hands a batch to the sink. The candles are copied, so ids assigned by a failed
+// attempt never leak into the retry.
+func (q *Queue) write(batch []item) error {
+	var saves, upserts []models.SymbolKlineData
+	for _, entry := range batch {
+		if entry.Op == opUpsert {
+			upserts = append(upserts, entry.Kline)
+		} else {
+			saves = append(saves, entry.Kline)
+		}
+	}
+	if len(saves) > 0 {
+		if err := q.sink.SaveKlines(saves); err != nil {
+			return err
+		}
+	}
+	if len(upserts) > 0 {
+		if err := q.sink.UpsertKlines(upserts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fail records a failed write and moves the memory buffer to disk, so a crash while the
+// sink is down loses nothing.
+func (q *Queue) fail(err error, retryIn time.Duration) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.failures++
+	if !q.failing {
+		q.failing = true
+		q.log.WithField("buffered", len(q.memory)).Warn("🔌 Kline writes failing, spilling to disk until they recover")
+	}
+	q.log.WithFields(logrus.Fields{
+		"attempt":  q.failures,
+		"retry_in": retryIn.String(),
+	}).Errorf("❌ Failed to write klines: %v", err)
+
+	if len(q.memory) > 0 {
+		memory := q.memory
+		q.memory = nil
+		q.spillLocked(memory)
+	}
+}
+
+// commit removes a written batch from where it came from.
+func (q *Queue) commit(n int, fromDisk bool) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.written += int64(n)
+	var err error
+	if fromDisk {
+		err = q.spill.advance(n)
+	} else {
+		q.memory = q.memory[n:]
+	}
+	if q.failing {
+		q.log.WithFields(logrus.Fields{
+			"failed_attempts": q.failures,
+			"spilled":         q.spill.pending(),
+		}).Info("🔁 Kline writes recovered, replaying spilled klines")
+		q.failing = false
+		q.failures = 0
+	}
+	return err
+}
+
+// drain writes what is left in memory until the shutdown deadline, then spills the rest.
+// Candles already on disk wait for the next start.
+func (q *Queue) drain() {
+	deadline := time.Now().Add(q.drainTime)
+	for !q.isFailing() && time.Now().Before(deadline) {
+		q.lock.Lock()
+		n := min(q.batchSize, len(q.memory))
+		batch := append([]item(nil), q.memory[:n]...)
+		q.lock.Unlock()
+		if n == 0 {
+			break
+		}
+		if err := q.write(batch); err != nil {
+			q.fail(err, 0)
+			break
+		}
+		_ = q.commit(n, false)
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.memory) > 0 {
+		memory := q.memory
+		q.memory = nil
+		q.spillLocked(memory)
+	}
+}
+
+func (q *Queue) isFailing() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.failing
+}
+
+// Close stops accepting candles, drains the memory buffer and closes the spill file.
+func (q *Queue) Close() error {
+	q.lock.Lock()
+	if q.closed {
+		q.lock.Unlock()
+		return nil
+	}
+	q.closed = true
+	q.lock.Unlock()
+
+	close(q.closing)
+	<-q.done
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if n := len(q.memory); n > 0 {
+		return fmt.Errorf("%d klines could not be written or spilled", n)
+	}
+	return q.spill.close()
+}
+
+// Stats reports the queue depth and the age of its oldest candle.
+func (q *Queue) Stats() Stats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	oldest := q.spill.oldest()
+	if len(q.memory) > 0 && (oldest == 0 || q.memory[0].EnqueuedAt < oldest) {
+		oldest = q.memory[0].EnqueuedAt
+	}
+	stats := Stats{
+		Buffered: len(q.memory),
+		Spilled:  q.spill.pending(),
+		Written:  q.written,
+		Dropped:  q.dropped,
+		Failures: q.failures,
+	}
+	if oldest > 0 {
+		stats.Oldest = time.UnixMilli(oldest)
+	}
+	return stats
+}
+
+// Depth is the number of candles not yet written.
+func (s Stats) Depth() int {
+	return s.Buffered + s.Spilled
+}
+
+// Log writes the stats as a single structured entry.
+func (s Stats) Log(log *logrus.Logger) {
+	var oldestMs int64
+	if !s.Oldest.IsZero() {
+		oldestMs = time.Since(s.Oldest).Milliseconds()
+	}
+	log.WithFields(logrus.Fields{
+		"depth":             s.Depth(),
+		"buffered":          s.Buffered,
+		"spilled":           s.Spilled,
+		"oldest_pending_ms": oldestMs,
+		"written":           s.Written,
+		"dropped":           s.Dropped,
+		"failures":          s.Failures,
+	}).Info("📬 Write queue stats")
+}
@@ -0,0 +1,248 @@
+package writequeue
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"scanner.magictradebot.com/config"
+	"scanner.magictradebot.com/models"
+)
+
+// fakeSink records what it is given and fails while it is down or has failures left.
+type fakeSink struct {
+	lock     sync.Mutex
+	down     bool
+	failures int           // calls still to fail
+	gate     chan struct{} // when set, calls wait for it to be closed
+	attempts []time.Time   // when each call was made
+	saved    map[int64]int // open time -> times saved
+	upserted map[int64]int // open time -> times upserted
+}
+
+func newFakeSink() *fakeSink {
+	return &fakeSink{saved: make(map[int64]int), upserted: make(map[int64]int)}
+}
+
+func (s *fakeSink) SaveKlines(data []models.SymbolKlineData) error {
+	return s.write(s.saved, data)
+}
+
+func (s *fakeSink) UpsertKlines(data []models.SymbolKlineData) error {
+	return s.write(s.upserted, data)
+}
+
+func (s *fakeSink) write(into map[int64]int, data []models.SymbolKlineData) error {
+	s.lock.Lock()
+	gate := s.gate
+	s.lock.Unlock()
+	if gate != nil {
+		<-gate
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.attempts = append(s.attempts, time.Now())
+	if s.down || s.failures > 0 {
+		s.failures--
+		return errors.New("database unavailable")
+	}
+	for _, k := range data {
+		into[k.OpenTime]++
+	}
+	return nil
+}
+
+func (s *fakeSink) setDown(down bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.down = down
+}
+
+// written returns how many candles were saved and upserted.
+func (s *fakeSink) written() (saved, upserted int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, n := range s.saved {
+		saved += n
+	}
+	for _, n := range s.upserted {
+		upserted += n
+	}
+	return saved, upserted
+}
+
+func newTestQueue(t *testing.T, dir string, sink *fakeSink) *Queue {
+	t.Helper()
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	q, err := New(config.WriteQueueSettings{
+		Directory:         dir,
+		BatchSize:         2,
+		MinBackoffMillis:  5,
+		MaxBackoffSeconds: 1,
+		ShutdownSeconds:   5,
+	}, sink, log)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return q
+}
+
+// waitFor polls until cond holds, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func klines(from, count int64) []models.SymbolKlineData {
+	data := make([]models.SymbolKlineData, count)
+	for i := range data {
+		data[i] = models.SymbolKlineData{Symbol: "BTCUSDT", Interval: "1m", OpenTime: (from + int64(i)) * 60_000}
+	}
+	return data
+}
+
+func spillFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "spill-*.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestQueueRetriesWithBackoff(t *testing.T) {
+	sink := newFakeSink()
+	sink.failures = 4
+	q := newTestQueue(t, t.TempDir(), sink)
+	defer q.Close()
+
+	if err := q.SaveKlines(klines(0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the write to succeed", func() bool { saved, _ := sink.written(); return saved == 1 })
+
+	sink.lock.Lock()
+	attempts := append([]time.Time(nil), sink.attempts...)
+	sink.lock.Unlock()
+	if len(attempts) != 5 {
+		t.Fatalf("%d attempts, want 4 failures and a success", len(attempts))
+	}
+	// Each retry waits at least twice as long as the one before
+	for i, want := 1, 5*time.Millisecond; i < len(attempts); i, want = i+1, want*2 {
+		if gap := attempts[i].Sub(attempts[i-1]); gap < want {
+			t.Errorf("retry %d came after %s, want at least %s", i, gap, want)
+		}
+	}
+
+	stats := q.Stats()
+	if stats.Failures != 0 || stats.Written != 1 || stats.Depth() != 0 {
+		t.Errorf("stats after recovery = %+v", stats)
+	}
+}
+
+func TestQueueSpillsWhileTheSinkIsDown(t *testing.T) {
+	dir := t.TempDir()
+	sink := newFakeSink()
+	sink.setDown(true)
+	q := newTestQueue(t, dir, sink)
+	defer q.Close()
+
+	if err := q.SaveKlines(klines(0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the first failure", func() bool { return q.Stats().Failures > 0 })
+	if err := q.UpsertKlines(klines(1, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := q.Stats()
+	if stats.Buffered != 0 || stats.Spilled != 5 || stats.Oldest.IsZero() {
+		t.Errorf("stats while down = %+v, want every candle on disk", stats)
+	}
+	if len(spillFiles(t, dir)) == 0 {
+		t.Error("nothing was spilled to disk")
+	}
+
+	sink.setDown(false)
+	waitFor(t, "the spilled candles to be replayed", func() bool { return q.Stats().Depth() == 0 })
+	if saved, upserted := sink.written(); saved != 1 || upserted != 4 {
+		t.Errorf("replayed %d saves and %d upserts, want 1 and 4", saved, upserted)
+	}
+	if files := spillFiles(t, dir); len(files) != 0 {
+		t.Errorf("replayed spill files were left behind: %v", files)
+	}
+}
+
+func TestQueueReplaysAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	down := newFakeSink()
+	down.setDown(true)
+	before := newTestQueue(t, dir, down)
+
+	if err := before.SaveKlines(klines(0, 2)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the first failure", func() bool { return before.Stats().Failures > 0 })
+	if err := before.UpsertKlines(klines(2, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := before.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := before.SaveKlines(klines(3, 1)); err == nil {
+		t.Error("a closed queue accepted candles")
+	}
+
+	sink := newFakeSink()
+	after := newTestQueue(t, dir, sink)
+	defer after.Close()
+	waitFor(t, "the previous run's candles", func() bool { saved, upserted := sink.written(); return saved+upserted == 3 })
+	if sink.saved[0] != 1 || sink.saved[60_000] != 1 || sink.upserted[120_000] != 1 {
+		t.Errorf("replayed saves %v and upserts %v", sink.saved, sink.upserted)
+	}
+	waitFor(t, "the spill files to be removed", func() bool { return len(spillFiles(t, dir)) == 0 })
+}
+
+func TestQueueDrainsOnShutdown(t *testing.T) {
+	dir := t.TempDir()
+	sink := newFakeSink()
+	gate := make(chan struct{})
+	sink.gate = gate
+	q := newTestQueue(t, dir, sink)
+
+	// The sink holds every write until shutdown has begun, so the candles are still in memory
+	if err := q.SaveKlines(klines(0, 6)); err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan error)
+	go func() { closed <- q.Close() }()
+	waitFor(t, "the queue to stop accepting candles", func() bool {
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		return q.closed
+	})
+	close(gate)
+
+	if err := <-closed; err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if saved, _ := sink.written(); saved != 6 {
+		t.Errorf("%d candles written before shutdown, want 6", saved)
+	}
+	if files := spillFiles(t, dir); len(files) != 0 {
+		t.Errorf("a healthy sink left spill files: %v", files)
+	}
+}
@@ -0,0 +1,236 @@
+package writequeue
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// spillQueue keeps candles on disk as numbered JSON-lines segments, oldest first. New
+// entries go to the active segment; replay loads the oldest closed segment whole and removes
+// it once every entry has been written. A crash during replay only writes some candles
+// twice, which inserts skip and amendment-aware upserts ignore.
+type spillQueue struct {
+	dir         string
+	segmentSize int
+
+	segments []spillSegment // closed segments, oldest first
+	active   *os.File
+	writer   *bufio.Writer
+	current  spillSegment // the active segment while it is open
+
+	loaded []item // entries of segments[0] while it is replayed
+	offset int    // entries of loaded already written
+	seq    int
+}
+
+type spillSegment struct {
+	path    string
+	entries int
+	oldest  int64 // enqueue time of its first entry (unix ms)
+}
+
+// openSpillQueue picks up the segments a previous run left in dir.
+func openSpillQueue(dir string, segmentSize int) (*spillQueue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create write queue directory: %w", err)
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "spill-*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	s := &spillQueue{dir: dir, segmentSize: segmentSize}
+	for _, path := range paths {
+		entries, err := readSegment(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			if err := os.Remove(path); err != nil {
+				return nil, fmt.Errorf("remove empty spill file: %w", err)
+			}
+			continue
+		}
+		s.segments = append(s.segments, spillSegment{path: path, entries: len(entries), oldest: entries[0].EnqueuedAt})
+		var seq int
+		if _, err := fmt.Sscanf(filepath.Base(path), "spill-%d.jsonl", &seq); err == nil && seq > s.seq {
+			s.seq = seq
+		}
+	}
+	return s, nil
+}
+
+// readSegment decodes a segment. Lines torn by a crash mid-append are skipped.
+func readSegment(path string) ([]item, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open spill file: %w", err)
+	}
+	defer f.Close()
+
+	var entries []item
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var entry item
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// pending returns the number of entries on disk that have not been written yet.
+func (s *spillQueue) pending() int {
+	n := s.current.entries
+	for _, seg := range s.segments {
+		n += seg.entries
+	}
+	return n
+}
+
+// oldest returns the enqueue time of the oldest entry not yet written, or 0 when empty.
+func (s *spillQueue) oldest() int64 {
+	if s.offset < len(s.loaded) {
+		return s.loaded[s.offset].EnqueuedAt
+	}
+	if len(s.segments) > 0 {
+		return s.segments[0].oldest
+	}
+	return s.current.oldest
+}
+
+// append adds entries to the active segment, rolling to a new one when it is full. The
+// entries are synced before append returns.
+func (s *spillQueue) append(entries []item) error {
+	for _, entry := range entries {
+		if s.active == nil {
+			if err := s.open(entry.EnqueuedAt); err != nil {
+				return err
+			}
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("encode spilled kline: %w", err)
+		}
+		if _, err := s.writer.Write(append(line, '\n')); err != nil {
+			return fmt.Errorf("write spill file: %w", err)
+		}
+		s.current.entries++
+		if s.current.entries >= s.segmentSize {
+			if err := s.rotate(); err != nil {
+				return err
+			}
+		}
+	}
+	return s.sync()
+}
+
+func (s *spillQueue) open(oldest int64) error {
+	s.seq++
+	path := filepath.Join(s.dir, fmt.Sprintf("spill-%010d.jsonl", s.seq))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("create spill file: %w", err)
+	}
+	s.active = f
+	s.writer = bufio.NewWriter(f)
+	s.current = spillSegment{path: path, oldest: oldest}
+	return nil
+}
+
+func (s *spillQueue) sync() error {
+	if s.active == nil {
+		return nil
+	}
+	if err := s.writer.Flush(); err != nil {
+		return fmt.Errorf("flush spill file: %w", err)
+	}
+	return s.active.Sync()
+}
+
+// rotate closes the active segment so it can be replayed.
+func (s *spillQueue) rotate() error {
+	if s.active == nil {
+		return nil
+	}
+	if err := s.sync(); err != nil {
+		return err
+	}
+	if err := s.active.Close(); err != nil {
+		return fmt.Errorf("close spill file: %w", err)
+	}
+	s.segments = append(s.segments, s.current)
+	s.active, s.writer, s.current = nil, nil, spillSegment{}
+	return nil
+}
+
+// peek returns up to n of the oldest entries without removing them.
+func (s *spillQueue) peek(n int) ([]item, error) {
+	if s.offset >= len(s.loaded) {
+		if len(s.segments) == 0 {
+			if err := s.rotate(); err != nil {
+				return nil, err
+			}
+		}
+		if len(s.segments) == 0 {
+			return nil, nil
+		}
+		entries, err := readSegment(s.segments[0].path)
+		if err != nil {
+			return nil, err
+		}
+		s.loaded, s.offset = entries, 0
+		s.segments[0].entries = len(entries)
+		if len(entries) == 0 {
+			return nil, s.dropSegment()
+		}
+	}
+	end := min(s.offset+n, len(s.loaded))
+	return s.loaded[s.offset:end], nil
+}
+
+// advance marks the next n entries as written and removes the segment once all of them are.
+func (s *spillQueue) advance(n int) error {
+	s.offset += n
+	s.segments[0].entries -= n
+	if s.offset < len(s.loaded) {
+		return nil
+	}
+	return s.dropSegment()
+}
+
+func (s *spillQueue) dropSegment() error {
+	path := s.segments[0].path
+	s.segments = s.segments[1:]
+	s.loaded, s.offset = nil, 0
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove replayed spill file: %w", err)
+	}
+	return nil
+}
+
+// close syncs and closes the active segment; its entries are replayed on the next start.
+func (s *spillQueue) close() error {
+	if s.active == nil {
+		return nil
+	}
+	if err := s.sync(); err != nil {
+		return err
+	}
+	return s.active.Close()
+}